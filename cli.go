@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/atsinformatica/firebird-sync-agent/internal/config"
+	"github.com/atsinformatica/firebird-sync-agent/internal/db"
+	"github.com/atsinformatica/firebird-sync-agent/internal/ui"
+	"github.com/atsinformatica/firebird-sync-agent/internal/webhook"
+)
+
+// runCommandCLI envia um comando ao agente em execução (endpoint /command) e
+// imprime o resultado. O agente executa localmente ou encaminha via Relay.
+func runCommandCLI(configPath string, args []string) int {
+	if len(args) < 2 {
+		fmt.Fprintln(os.Stderr, "Uso: command [-config path] <NODE_ID> <comando> [args JSON]")
+		return 2
+	}
+	cfg, err := loadCLIConfig(configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Erro ao carregar config: %v\n", err)
+		return 1
+	}
+
+	req := webhook.CommandRequest{Target: args[0], Name: args[1]}
+	if len(args) > 2 {
+		if !json.Valid([]byte(args[2])) {
+			fmt.Fprintln(os.Stderr, "Argumentos do comando devem ser um JSON válido")
+			return 2
+		}
+		req.Args = json.RawMessage(args[2])
+	}
+	result, err := callLocalAgent(cfg, req)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Erro: %v\n", err)
+		return 1
+	}
+
+	printJSON(result)
+	return 0
+}
+
+// callLocalAgent executa o comando no agente em execução (endpoint /command)
+func callLocalAgent(cfg *config.Config, req webhook.CommandRequest) (json.RawMessage, error) {
+	body, _ := json.Marshal(req)
+
+	httpReq, err := http.NewRequest(http.MethodPost, localAgentURL(cfg.Webhook.ListenAddr)+"/command", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("falha ao montar requisição: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("X-Admin-Token", cfg.Commands.Token)
+
+	client := &http.Client{Timeout: 5 * time.Minute}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao falar com o agente (ele está rodando?): %w", err)
+	}
+	defer resp.Body.Close()
+
+	raw, _ := io.ReadAll(resp.Body)
+	var result webhook.RelayCommandResult
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return nil, fmt.Errorf("resposta inesperada do agente (%d): %s", resp.StatusCode, bytes.TrimSpace(raw))
+	}
+	if !result.OK {
+		return nil, errors.New(result.Error)
+	}
+	return result.Result, nil
+}
+
+func printJSON(raw json.RawMessage) {
+	var pretty bytes.Buffer
+	if err := json.Indent(&pretty, raw, "", "  "); err != nil {
+		fmt.Println(string(raw))
+	} else {
+		fmt.Println(pretty.String())
+	}
+}
+
+// runResyncCLI faz a carga inicial de uma tabela (ou de todas) direto no banco local,
+// sem depender do agente estar rodando. Interrompida com Ctrl+C, retoma na próxima
+// execução a partir da última página gravada.
+func runResyncCLI(configPath string, args []string) int {
+	fs := flag.NewFlagSet("resync", flag.ContinueOnError)
+	nodeID := fs.String("node", "", "Nó de destino (padrão: todos os nós ativos)")
+	restart := fs.Bool("restart", false, "Descarta o progresso anterior e recomeça do início")
+	pageSize := fs.Int("page", 500, "Linhas por página")
+	if len(args) < 1 || strings.HasPrefix(args[0], "-") {
+		fmt.Fprintln(os.Stderr, "Uso: resync [-config path] <TABELA|all> [-node NODE_ID] [-restart] [-page N]")
+		return 2
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	cfg, err := loadCLIConfig(configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Erro ao carregar config: %v\n", err)
+		return 1
+	}
+	dbConn, err := db.Connect(cfg.Firebird.DSN)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	defer dbConn.Close()
+	ui.EnsureQueueSchema(dbConn)
+	queue := db.NewQueueManager(dbConn, cfg.NodeID)
+
+	tables := []string{args[0]}
+	if strings.EqualFold(args[0], "all") {
+		if tables, err = db.GetIntegratedTables(dbConn); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	for _, table := range tables {
+		job, err := queue.StartResync(table, *nodeID, *restart)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", table, err)
+			return 1
+		}
+		fmt.Printf("Carga %d: %s para %s (já enfileiradas: %d)\n", job.ID, job.Tabela, job.NodeID, job.Total)
+
+		job, err = queue.RunResync(ctx, job.ID, *pageSize, func(j *db.ResyncJob) {
+			fmt.Printf("  %s: %d linha(s) enfileirada(s)\n", j.Tabela, j.Total)
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v (execute novamente para retomar)\n", table, err)
+			return 1
+		}
+		if ctx.Err() != nil {
+			fmt.Printf("Interrompido. Execute novamente para retomar a carga %d de %s.\n", job.ID, job.Tabela)
+			return 1
+		}
+		fmt.Printf("Carga %d concluída: %d linha(s) de %s enfileirada(s)\n", job.ID, job.Total, job.Tabela)
+	}
+	return 0
+}
+
+const conflictsUsage = `Uso:
+  conflicts [-config path] [list [-all] [-table TABELA] [-limit N]]
+  conflicts [-config path] show <ID>
+  conflicts [-config path] accept <ID>                      Aplica o evento recebido
+  conflicts [-config path] keep <ID>                        Mantém a linha local e a reenvia à origem
+  conflicts [-config path] edit <ID> <JSON | @arquivo.json>  Aplica o evento com os campos corrigidos`
+
+// runConflictsCLI revisa os eventos retidos em SYNC_CONFLITOS pelo agente em execução
+// (comandos conflicts e resolve_conflict), sem precisar de acesso ao banco
+func runConflictsCLI(configPath string, args []string) int {
+	action := "list"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		action, args = args[0], args[1:]
+	}
+
+	cfg, err := loadCLIConfig(configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Erro ao carregar config: %v\n", err)
+		return 1
+	}
+	call := func(name string, cmdArgs interface{}) (json.RawMessage, bool) {
+		data, _ := json.Marshal(cmdArgs)
+		result, err := callLocalAgent(cfg, webhook.CommandRequest{Target: cfg.NodeID, Name: name, Args: data})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Erro: %v\n", err)
+			return nil, false
+		}
+		return result, true
+	}
+
+	if action == "list" {
+		fs := flag.NewFlagSet("conflicts", flag.ContinueOnError)
+		all := fs.Bool("all", false, "Inclui os conflitos já resolvidos")
+		table := fs.String("table", "", "Filtra pela tabela")
+		limit := fs.Int("limit", 50, "Máximo de conflitos listados")
+		if err := fs.Parse(args); err != nil {
+			return 2
+		}
+		status := ""
+		if *all {
+			status = "all"
+		}
+		raw, ok := call("conflicts", map[string]interface{}{"status": status, "table": *table, "limit": *limit})
+		if !ok {
+			return 1
+		}
+		var list []db.ConflictRecord
+		if err := json.Unmarshal(raw, &list); err != nil {
+			printJSON(raw)
+			return 0
+		}
+		printConflicts(list)
+		return 0
+	}
+
+	if len(args) < 1 {
+		fmt.Fprintln(os.Stderr, conflictsUsage)
+		return 2
+	}
+	id, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ID de conflito inválido: %s\n", args[0])
+		return 2
+	}
+
+	req := map[string]interface{}{"id": id, "user": cliUser()}
+	switch action {
+	case "show":
+		raw, ok := call("conflicts", map[string]interface{}{"id": id})
+		if !ok {
+			return 1
+		}
+		printJSON(raw)
+		return 0
+	case "accept":
+		req["resolution"] = db.ResolutionIncoming
+	case "keep":
+		req["resolution"] = db.ResolutionLocal
+	case "edit":
+		if len(args) < 2 {
+			fmt.Fprintln(os.Stderr, conflictsUsage)
+			return 2
+		}
+		data := []byte(args[1])
+		if strings.HasPrefix(args[1], "@") {
+			if data, err = os.ReadFile(args[1][1:]); err != nil {
+				fmt.Fprintf(os.Stderr, "Erro ao ler %s: %v\n", args[1][1:], err)
+				return 1
+			}
+		}
+		var fields map[string]interface{}
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		if err := decoder.Decode(&fields); err != nil || len(fields) == 0 {
+			fmt.Fprintln(os.Stderr, "Os dados corrigidos devem ser um objeto JSON com as colunas a alterar")
+			return 2
+		}
+		req["resolution"] = db.ResolutionEdited
+		req["data"] = fields
+	default:
+		fmt.Fprintln(os.Stderr, conflictsUsage)
+		return 2
+	}
+
+	raw, ok := call("resolve_conflict", req)
+	if !ok {
+		return 1
+	}
+	var c db.ConflictRecord
+	if err := json.Unmarshal(raw, &c); err != nil {
+		printJSON(raw)
+		return 0
+	}
+	fmt.Printf("Conflito %d (%s %s) resolvido: %s, por %s\n", c.ID, c.Tabela, c.PK, c.Resolucao, c.ResolvidoPor)
+	return 0
+}
+
+func printConflicts(list []db.ConflictRecord) {
+	if len(list) == 0 {
+		fmt.Println("Nenhum conflito.")
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tDATA\tTABELA\tOP\tORIGEM\tPK\tSITUAÇÃO\tMOTIVO")
+	for _, c := range list {
+		situacao := "pendente"
+		if c.Status == db.ConflictResolved {
+			situacao = c.Resolucao
+		}
+		motivo := c.Motivo
+		if c.Erro != "" {
+			motivo += ": " + c.Erro
+		}
+		if len(motivo) > 80 {
+			motivo = motivo[:77] + "..."
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", c.ID, c.DTConflito.Format("2006-01-02 15:04:05"),
+			c.Tabela, c.Operacao, c.Origem, c.PK, situacao, strings.ReplaceAll(motivo, "\n", " "))
+	}
+	w.Flush()
+}
+
+// cliUser identifica o operador na resolução de conflitos (gravado em RESOLVIDO_POR)
+func cliUser() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	return os.Getenv("USERNAME")
+}
+
+// loadCLIConfig carrega o config informado ou o config.yaml ao lado do executável
+func loadCLIConfig(configPath string) (*config.Config, error) {
+	if configPath == "" {
+		exePath, _ := os.Executable()
+		configPath = filepath.Join(filepath.Dir(exePath), "config.yaml")
+	}
+	return config.Load(configPath)
+}
+
+// localAgentURL converte o listen_addr do webhook (":8081", "0.0.0.0:8081") no endereço local
+func localAgentURL(listenAddr string) string {
+	host, port, err := net.SplitHostPort(listenAddr)
+	if err != nil {
+		return "http://127.0.0.1" + listenAddr
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+	return "http://" + net.JoinHostPort(host, port)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// NodeStatus é a visão de um nó na API administrativa
+type NodeStatus struct {
+	NodeID      string     `json:"node_id"`
+	Online      bool       `json:"online"`
+	ConnectedAt *time.Time `json:"connected_at,omitempty"`
+	RemoteAddr  string     `json:"remote_addr,omitempty"`
+	MsgsIn      int64      `json:"msgs_in"`
+	MsgsOut     int64      `json:"msgs_out"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+	Queued      int        `json:"queued"` // Mensagens guardadas no spool aguardando o nó
+}
+
+// AdminAPI expõe em /admin a presença dos nós e ações de suporte:
+//
+//	GET  /admin/nodes            lista nós conectados e nós com mensagens guardadas
+//	GET  /admin/nodes/{id}       detalhe de um nó
+//	POST /admin/nodes/{id}/kick  derruba a conexão do nó
+type AdminAPI struct {
+	hub   *Hub
+	token string
+}
+
+func (a *AdminAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if a.token == "" {
+		http.Error(w, "API administrativa desabilitada", http.StatusNotFound)
+		return
+	}
+	if !a.authorized(r) {
+		http.Error(w, "Não autorizado", http.StatusUnauthorized)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/"), "/"), "/")
+	switch {
+	case len(parts) == 1 && parts[0] == "nodes" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, a.hub.NodeStatuses())
+
+	case len(parts) == 2 && parts[0] == "nodes" && r.Method == http.MethodGet:
+		for _, st := range a.hub.NodeStatuses() {
+			if st.NodeID == parts[1] {
+				writeJSON(w, http.StatusOK, st)
+				return
+			}
+		}
+		http.Error(w, "Nó não encontrado", http.StatusNotFound)
+
+	case len(parts) == 3 && parts[0] == "nodes" && parts[2] == "kick" && r.Method == http.MethodPost:
+		if !a.hub.Kick(parts[1]) {
+			http.Error(w, "Nó não conectado", http.StatusNotFound)
+			return
+		}
+		log.Printf("[ADMIN] Nó %s desconectado via API (%s)", parts[1], r.RemoteAddr)
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+
+	default:
+		http.Error(w, "Rota não encontrada", http.StatusNotFound)
+	}
+}
+
+// authorized aceita "Authorization: Bearer <token>" ou o header X-Admin-Token
+func (a *AdminAPI) authorized(r *http.Request) bool {
+	provided := r.Header.Get("X-Admin-Token")
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		provided = strings.TrimPrefix(auth, "Bearer ")
+	}
+	return provided != "" && subtle.ConstantTimeCompare([]byte(provided), []byte(a.token)) == 1
+}
+
+// NodeStatuses reúne os nós conectados e os offline que têm mensagens no spool
+func (h *Hub) NodeStatuses() []NodeStatus {
+	pending := h.spool.Pending()
+	byNode := make(map[string]NodeStatus)
+
+	h.nodes.Range(func(key, value interface{}) bool {
+		s := value.(*nodeSession)
+		connectedAt := s.connectedAt
+		st := NodeStatus{
+			NodeID:      s.nodeID,
+			Online:      true,
+			ConnectedAt: &connectedAt,
+			RemoteAddr:  s.remoteAddr,
+			MsgsIn:      s.msgsIn.Load(),
+			MsgsOut:     s.msgsOut.Load(),
+			Queued:      pending[s.nodeID],
+		}
+		s.mu.Lock()
+		if s.lastError != "" {
+			lastErrorAt := s.lastErrorAt
+			st.LastError = s.lastError
+			st.LastErrorAt = &lastErrorAt
+		}
+		s.mu.Unlock()
+		byNode[s.nodeID] = st
+		return true
+	})
+
+	for nodeID, queued := range pending {
+		if _, ok := byNode[nodeID]; !ok {
+			byNode[nodeID] = NodeStatus{NodeID: nodeID, Queued: queued}
+		}
+	}
+
+	statuses := make([]NodeStatus, 0, len(byNode))
+	for _, st := range byNode {
+		statuses = append(statuses, st)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].NodeID < statuses[j].NodeID })
+	return statuses
+}
+
+// Kick encerra a conexão do nó; o agente reconecta sozinho
+func (h *Hub) Kick(nodeID string) bool {
+	value, ok := h.nodes.Load(nodeID)
+	if !ok {
+		return false
+	}
+	value.(*nodeSession).conn.Close()
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/pbkdf2"
+	"gopkg.in/yaml.v3"
+)
+
+// Formato do hash: pbkdf2-sha256$<iterações>$<salt base64>$<hash base64>
+const (
+	hashScheme     = "pbkdf2-sha256"
+	hashIterations = 100000
+	hashSaltSize   = 16
+)
+
+// NodeCredential é a entrada de um nó no arquivo de credenciais
+type NodeCredential struct {
+	SecretHash string   `yaml:"secret_hash"`
+	Allow      []string `yaml:"allow"` // Nós para os quais este nó pode enviar mensagens ("*" = todos)
+}
+
+type registryFile struct {
+	Nodes map[string]NodeCredential `yaml:"nodes"`
+}
+
+// Registry guarda as credenciais por nó, lidas de um arquivo YAML.
+// O arquivo é relido automaticamente quando modificado.
+type Registry struct {
+	mu      sync.RWMutex
+	path    string
+	modTime time.Time
+	nodes   map[string]NodeCredential
+}
+
+func LoadRegistry(path string) (*Registry, error) {
+	r := &Registry{path: path}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Registry) load() error {
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return fmt.Errorf("erro ao ler arquivo de credenciais: %w", err)
+	}
+	data, err := os.ReadFile(r.path)
+	if err != nil {
+		return fmt.Errorf("erro ao ler arquivo de credenciais: %w", err)
+	}
+
+	var file registryFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("erro ao interpretar arquivo de credenciais: %w", err)
+	}
+	for nodeID, cred := range file.Nodes {
+		if _, _, _, err := parseSecretHash(cred.SecretHash); err != nil {
+			return fmt.Errorf("credencial inválida para o nó %s: %w", nodeID, err)
+		}
+	}
+
+	r.mu.Lock()
+	r.nodes = file.Nodes
+	r.modTime = info.ModTime()
+	r.mu.Unlock()
+	log.Printf("[AUTH] %d nó(s) carregado(s) de %s", len(file.Nodes), r.path)
+	return nil
+}
+
+// reloadIfChanged relê o arquivo se ele foi alterado desde a última leitura.
+// Em caso de erro, mantém as credenciais anteriores.
+func (r *Registry) reloadIfChanged() {
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return
+	}
+	r.mu.RLock()
+	changed := !info.ModTime().Equal(r.modTime)
+	r.mu.RUnlock()
+	if !changed {
+		return
+	}
+	if err := r.load(); err != nil {
+		log.Printf("[AUTH] %v (mantendo credenciais anteriores)", err)
+	}
+}
+
+// Authenticate confere o segredo apresentado pelo nó. Um nó desconhecido custa o
+// mesmo que um segredo errado, para a resposta não revelar quais nós existem.
+func (r *Registry) Authenticate(nodeID, secret string) bool {
+	r.reloadIfChanged()
+
+	r.mu.RLock()
+	cred, ok := r.nodes[nodeID]
+	r.mu.RUnlock()
+	if !ok {
+		verifySecret(dummySecretHash(), secret)
+		return false
+	}
+	return verifySecret(cred.SecretHash, secret) && secret != ""
+}
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     string
+)
+
+// dummySecretHash é um hash válido de um segredo aleatório, usado para nós desconhecidos
+func dummySecretHash() string {
+	dummyHashOnce.Do(func() {
+		secret := make([]byte, 32)
+		rand.Read(secret)
+		dummyHash, _ = HashSecret(base64.RawStdEncoding.EncodeToString(secret))
+	})
+	return dummyHash
+}
+
+// Allowed indica se source pode enviar mensagens para target. Respostas ("ack" e
+// "command_result") também são aceitas quando o sentido contrário é permitido.
+func (r *Registry) Allowed(source, target, msgType string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.allows(source, target) {
+		return true
+	}
+	return (msgType == "ack" || msgType == "command_result") && r.allows(target, source)
+}
+
+func (r *Registry) allows(source, target string) bool {
+	cred, ok := r.nodes[source]
+	if !ok {
+		return false
+	}
+	for _, allowed := range cred.Allow {
+		if allowed == "*" || strings.EqualFold(allowed, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// HashSecret gera o hash a ser gravado em secret_hash
+func HashSecret(secret string) (string, error) {
+	salt := make([]byte, hashSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("erro ao gerar salt: %w", err)
+	}
+	key := pbkdf2.Key([]byte(secret), salt, hashIterations, sha256.Size, sha256.New)
+	return fmt.Sprintf("%s$%d$%s$%s", hashScheme, hashIterations,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func verifySecret(encoded, secret string) bool {
+	iterations, salt, expected, err := parseSecretHash(encoded)
+	if err != nil {
+		return false
+	}
+	key := pbkdf2.Key([]byte(secret), salt, iterations, sha256.Size, sha256.New)
+	return subtle.ConstantTimeCompare(key, expected) == 1
+}
+
+func parseSecretHash(encoded string) (int, []byte, []byte, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 4 || parts[0] != hashScheme {
+		return 0, nil, nil, fmt.Errorf("formato esperado %s$<iterações>$<salt>$<hash>", hashScheme)
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations <= 0 {
+		return 0, nil, nil, fmt.Errorf("número de iterações inválido")
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return 0, nil, nil, fmt.Errorf("salt inválido: %w", err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil || len(key) != sha256.Size {
+		return 0, nil, nil, fmt.Errorf("hash inválido")
+	}
+	return iterations, salt, key, nil
+}
+
+// Limites das tentativas de autenticação recusadas
+const (
+	authMaxFailures = 5               // Falhas seguidas por endereço e nó antes do bloqueio
+	authFailWindow  = time.Minute     // Janela em que as falhas são contadas
+	authBlockTime   = 5 * time.Minute // Duração do bloqueio
+)
+
+// authLimiter bloqueia por um tempo o par endereço+nó que errou o segredo várias vezes
+// e limita quantas verificações (PBKDF2, caras de propósito) rodam ao mesmo tempo
+type authLimiter struct {
+	mu       sync.Mutex
+	failures map[string]*authFailures
+	slots    chan struct{}
+}
+
+type authFailures struct {
+	count        int
+	first        time.Time
+	blockedUntil time.Time
+}
+
+func newAuthLimiter(concurrency int) *authLimiter {
+	return &authLimiter{
+		failures: make(map[string]*authFailures),
+		slots:    make(chan struct{}, concurrency),
+	}
+}
+
+// Blocked indica se a chave está bloqueada por excesso de falhas
+func (l *authLimiter) Blocked(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f, ok := l.failures[key]
+	return ok && time.Now().Before(f.blockedUntil)
+}
+
+// Verify roda a verificação ocupando uma das vagas e registra o resultado
+func (l *authLimiter) Verify(key string, verify func() bool) bool {
+	l.slots <- struct{}{}
+	ok := verify()
+	<-l.slots
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if ok {
+		delete(l.failures, key)
+		return true
+	}
+
+	now := time.Now()
+	f := l.failures[key]
+	if f == nil || now.Sub(f.first) > authFailWindow {
+		l.prune(now)
+		f = &authFailures{first: now}
+		l.failures[key] = f
+	}
+	f.count++
+	if f.count >= authMaxFailures {
+		f.blockedUntil = now.Add(authBlockTime)
+		log.Printf("[AUTH] %s bloqueado por %s após %d falhas de autenticação", key, authBlockTime, f.count)
+	}
+	return false
+}
+
+// prune descarta as entradas vencidas (chamado com mu travado)
+func (l *authLimiter) prune(now time.Time) {
+	for key, f := range l.failures {
+		if now.Sub(f.first) > authFailWindow && now.After(f.blockedUntil) {
+			delete(l.failures, key)
+		}
+	}
+}
@@ -1,19 +1,39 @@
 package main
 
 import (
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"runtime"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
 
 var (
-	addr  = flag.String("addr", ":8000", "Endereço de escuta do Relay")
-	token = flag.String("token", "ATS_RELAY_SECRET", "Token de autenticação do Relay")
+	addr     = flag.String("addr", ":8000", "Endereço de escuta do Relay")
+	token    = flag.String("token", "", "Token compartilhado do Relay (modo legado, sem -nodes-file)")
+	spoolDir = flag.String("spool-dir", "spool", "Diretório onde ficam as mensagens de nós offline")
+	spoolTTL = flag.Duration("spool-ttl", 72*time.Hour, "Tempo máximo que uma mensagem fica guardada para um nó offline")
+
+	nodesFile  = flag.String("nodes-file", "", "Arquivo YAML com as credenciais por nó (vazio = token compartilhado)")
+	hashSecret = flag.String("hash-secret", "", "Gera o secret_hash para o segredo informado e sai")
+	adminToken = flag.String("admin-token", "", "Token da API administrativa /admin (vazio = desabilitada)")
+
+	pingInterval = flag.Duration("ping-interval", 30*time.Second, "Intervalo entre pings enviados a cada nó")
+	pongTimeout  = flag.Duration("pong-timeout", 75*time.Second, "Tempo sem receber nada do nó até considerar a conexão morta")
+	writeTimeout = flag.Duration("write-timeout", 10*time.Second, "Prazo para concluir uma escrita na conexão do nó")
+
+	sendBuffer     = flag.Int("send-buffer", 256, "Tamanho da fila de saída de cada nó")
+	overflowPolicy = flag.String("overflow", OverflowSpool, "O que fazer quando a fila de saída de um nó enche: spool, drop ou disconnect")
 )
 
 var upgrader = websocket.Upgrader{
@@ -22,30 +42,44 @@ var upgrader = websocket.Upgrader{
 
 // RelayMessage define o envelope usado no túnel
 type RelayMessage struct {
+	ID         string          `json:"id,omitempty"` // Em "sync", o EVENT_ID
 	TargetNode string          `json:"target"`
 	SourceNode string          `json:"source"`
 	Payload    json.RawMessage `json:"payload"`
-	Type       string          `json:"type"` // e.g., "sync", "command", "ping"
+	Type       string          `json:"type"` // e.g., "sync", "sync_batch", "ack", "presence", "command", "command_result"
 }
 
 type Hub struct {
-	nodes sync.Map // map[string]*websocket.Conn
+	nodes    sync.Map // map[string]*nodeSession
+	spool    *Spool
+	registry *Registry // nil = modo legado com token compartilhado
+	limiter  *authLimiter
 }
 
 func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	// 1. Validar Token
-	if r.Header.Get("X-Relay-Token") != *token && r.URL.Query().Get("token") != *token {
-		http.Error(w, "Não autorizado", http.StatusUnauthorized)
-		return
-	}
-
-	// 2. Obter NodeID
+	// 1. Obter NodeID
 	nodeID := r.URL.Query().Get("node_id")
 	if nodeID == "" {
 		http.Error(w, "node_id é obrigatório", http.StatusBadRequest)
 		return
 	}
 
+	// 2. Validar credencial do nó
+	secret := r.Header.Get("X-Relay-Token")
+	if secret == "" {
+		secret = r.URL.Query().Get("token")
+	}
+	limitKey := remoteHost(r) + "/" + nodeID
+	if h.limiter.Blocked(limitKey) {
+		http.Error(w, "Muitas tentativas de autenticação", http.StatusTooManyRequests)
+		return
+	}
+	if !h.limiter.Verify(limitKey, func() bool { return h.authenticate(nodeID, secret) }) {
+		log.Printf("[RELAY] Autenticação recusada para o nó %s (%s)", nodeID, r.RemoteAddr)
+		http.Error(w, "Não autorizado", http.StatusUnauthorized)
+		return
+	}
+
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("[RELAY] Erro no upgrade do nó %s: %v", nodeID, err)
@@ -53,41 +87,234 @@ func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 	defer conn.Close()
 
-	h.nodes.Store(nodeID, conn)
+	session := newNodeSession(h, nodeID, conn, r.RemoteAddr)
+	h.register(session)
 	log.Printf("[RELAY] Nó Conectado: %s", nodeID)
 	defer func() {
-		h.nodes.Delete(nodeID)
+		session.shutdown()
+		// Se a conexão foi substituída por uma mais nova, o nó continua online
+		if h.nodes.CompareAndDelete(nodeID, session) && !session.replaced.Load() {
+			h.broadcastPresence(nodeID, false)
+		}
+		close(session.stopped)
 		log.Printf("[RELAY] Nó Desconectado: %s", nodeID)
 	}()
 
+	stopKeepalive := session.keepalive()
+	defer close(stopKeepalive)
+
+	// Entrega o que ficou guardado enquanto o nó estava offline
+	h.spool.Lock()
+	delivered, err := h.spool.FlushLocked(nodeID, session.enqueueLocked)
+	if errors.Is(err, errQueueFull) {
+		// Não coube tudo na fila de saída: o writer drena o resto aos poucos
+		session.markSpilled()
+		err = nil
+	}
+	h.spool.Unlock()
+	if delivered > 0 {
+		log.Printf("[RELAY] %d mensagem(ns) guardada(s) encaminhada(s) para %s", delivered, nodeID)
+	}
+	if err != nil {
+		log.Printf("[RELAY] Erro ao entregar spool para %s: %v", nodeID, err)
+	}
+
+	h.sendPresenceSnapshot(session)
+	h.broadcastPresence(nodeID, true)
+
 	for {
 		_, message, err := conn.ReadMessage()
 		if err != nil {
 			log.Printf("[RELAY] Erro de leitura do nó %s: %v", nodeID, err)
 			break
 		}
+		session.extendDeadline()
+		session.msgsIn.Add(1)
 
 		var relayMsg RelayMessage
 		if err := json.Unmarshal(message, &relayMsg); err != nil {
 			log.Printf("[RELAY] Payload inválido do nó %s", nodeID)
+			session.setError(fmt.Errorf("payload inválido: %w", err))
+			continue
+		}
+
+		// A origem é sempre o nó autenticado nesta conexão
+		if relayMsg.SourceNode != "" && relayMsg.SourceNode != nodeID {
+			log.Printf("[RELAY] Nó %s tentou enviar como %s. Mensagem descartada", nodeID, relayMsg.SourceNode)
+			session.setError(fmt.Errorf("mensagem com origem %s descartada", relayMsg.SourceNode))
+			continue
+		}
+		relayMsg.SourceNode = nodeID
+
+		if h.registry != nil && !h.registry.Allowed(nodeID, relayMsg.TargetNode, relayMsg.Type) {
+			log.Printf("[RELAY] Nó %s não autorizado a enviar para %s", nodeID, relayMsg.TargetNode)
+			session.setError(fmt.Errorf("envio para %s não autorizado", relayMsg.TargetNode))
+			h.spool.Lock()
+			h.reject(session, relayMsg, "error", "nó "+nodeID+" não autorizado a enviar para "+relayMsg.TargetNode)
+			h.spool.Unlock()
 			continue
 		}
 
-		// Preenche a origem se estiver vazia para segurança
-		if relayMsg.SourceNode == "" {
-			relayMsg.SourceNode = nodeID
+		h.route(session, relayMsg)
+	}
+}
+
+// authenticate confere o segredo do nó no arquivo de credenciais ou,
+// no modo legado, contra o token compartilhado
+func (h *Hub) authenticate(nodeID, secret string) bool {
+	if h.registry != nil {
+		return h.registry.Authenticate(nodeID, secret)
+	}
+	return secret != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(*token)) == 1
+}
+
+// remoteHost é o endereço de origem da conexão, sem a porta
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// register coloca a sessão como a conexão ativa do nó. Uma conexão anterior do mesmo
+// nó (ex: reconexão após queda) é encerrada antes, e a nova só entra depois que a
+// antiga devolveu ao spool o que não entregou; assim nenhuma mensagem mais nova
+// chega ao nó antes das antigas.
+func (h *Hub) register(session *nodeSession) {
+	for {
+		value, loaded := h.nodes.LoadOrStore(session.nodeID, session)
+		if !loaded {
+			return
 		}
+		previous := value.(*nodeSession)
+		log.Printf("[RELAY] Nó %s reconectou; encerrando conexão anterior", session.nodeID)
+		previous.replaced.Store(true)
+		previous.conn.Close()
+		<-previous.stopped
+	}
+}
 
-		// Roteamento
-		if targetConn, ok := h.nodes.Load(relayMsg.TargetNode); ok {
-			wsTarget := targetConn.(*websocket.Conn)
-			msgOut, _ := json.Marshal(relayMsg)
-			if err := wsTarget.WriteMessage(websocket.TextMessage, msgOut); err != nil {
-				log.Printf("[RELAY] Erro ao enviar para %s: %v", relayMsg.TargetNode, err)
-			}
-		} else {
-			log.Printf("[RELAY] Destino não encontrado: %s (De: %s)", relayMsg.TargetNode, nodeID)
-			// Opcional: Responder ao emissor informando que o alvo está offline
+// route entrega a mensagem ao destino conectado ou, se ele estiver offline (ou ainda
+// houver mensagens anteriores guardadas para ele), guarda no spool para entrega posterior
+func (h *Hub) route(sender *nodeSession, relayMsg RelayMessage) {
+	h.spool.Lock()
+	defer h.spool.Unlock()
+
+	if value, online := h.nodes.Load(relayMsg.TargetNode); online {
+		target := value.(*nodeSession)
+		if h.spool.HasPendingLocked(relayMsg.TargetNode) {
+			// Ainda há mensagens anteriores no spool: esta entra atrás delas
+			target.markSpilled()
+		} else if err := target.enqueueLocked(relayMsg); err == nil {
+			return
+		} else if !h.handleOverflow(sender, target, relayMsg, err) {
+			return
+		}
+	}
+
+	if !spoolable(relayMsg) {
+		log.Printf("[RELAY] Mensagem %s para %s descartada: destino não disponível (De: %s)", relayMsg.Type, relayMsg.TargetNode, relayMsg.SourceNode)
+		h.reject(sender, relayMsg, "offline", "destino "+relayMsg.TargetNode+" não disponível no Hub")
+		return
+	}
+
+	if err := h.spool.AppendLocked(relayMsg.TargetNode, relayMsg); err != nil {
+		log.Printf("[RELAY] Erro ao guardar mensagem para %s: %v", relayMsg.TargetNode, err)
+		h.reject(sender, relayMsg, "offline", "destino "+relayMsg.TargetNode+" não conectado ao Hub")
+		return
+	}
+
+	if _, online := h.nodes.Load(relayMsg.TargetNode); !online {
+		log.Printf("[RELAY] Destino %s offline: mensagem guardada (De: %s)", relayMsg.TargetNode, relayMsg.SourceNode)
+	}
+	if relayMsg.Type == "sync" || relayMsg.Type == "sync_batch" {
+		h.replyStatus(sender, relayMsg, "queued", "")
+	}
+}
+
+// handleOverflow aplica a política de -overflow quando a fila de saída do destino
+// está cheia. Retorna true se a mensagem deve seguir para o spool. Exige a trava do spool.
+func (h *Hub) handleOverflow(sender, target *nodeSession, msg RelayMessage, err error) bool {
+	if errors.Is(err, errSessionClosed) {
+		return true
+	}
+
+	target.setError(fmt.Errorf("%w (política: %s)", err, *overflowPolicy))
+	switch *overflowPolicy {
+	case OverflowDrop:
+		log.Printf("[RELAY] Fila de %s cheia: mensagem %s descartada", target.nodeID, msg.Type)
+		h.reject(sender, msg, "offline", "fila de "+target.nodeID+" cheia no Hub")
+		return false
+	case OverflowDisconnect:
+		log.Printf("[RELAY] Fila de %s cheia: desconectando nó lento", target.nodeID)
+		target.conn.Close()
+		return true
+	default:
+		target.markSpilled()
+		return true
+	}
+}
+
+// reject avisa o emissor que a mensagem não será entregue, para que ele não fique
+// esperando o timeout: "sync" (e cada evento de "sync_batch") recebe um "ack" e
+// "command" um "command_result" com erro.
+// Exige a trava do spool.
+func (h *Hub) reject(sender *nodeSession, msg RelayMessage, status, errMsg string) {
+	switch msg.Type {
+	case "sync", "sync_batch":
+		h.replyStatus(sender, msg, status, errMsg)
+	case "command":
+		result, _ := json.Marshal(map[string]interface{}{"ok": false, "error": errMsg})
+		reply := RelayMessage{
+			ID:         msg.ID,
+			TargetNode: msg.SourceNode,
+			SourceNode: msg.TargetNode,
+			Payload:    result,
+			Type:       "command_result",
+		}
+		if err := sender.enqueueLocked(reply); err != nil {
+			log.Printf("[RELAY] Erro ao responder comando para %s: %v", msg.SourceNode, err)
+		}
+	}
+}
+
+// replyStatus responde ao emissor de um "sync" com um "ack" do próprio Hub:
+// "queued" (guardado para entrega posterior), "offline" (não foi possível guardar)
+// ou "error" (mensagem recusada). Um "sync_batch" recebe um "ack" por evento.
+// Exige a trava do spool.
+func (h *Hub) replyStatus(sender *nodeSession, msg RelayMessage, status, errMsg string) {
+	eventIDs := []string{msg.ID}
+	if msg.Type == "sync_batch" {
+		var events []struct {
+			EventID string `json:"event_id"`
+		}
+		if err := json.Unmarshal(msg.Payload, &events); err != nil {
+			log.Printf("[RELAY] Lote inválido de %s: %v", msg.SourceNode, err)
+			return
+		}
+		eventIDs = eventIDs[:0]
+		for _, e := range events {
+			eventIDs = append(eventIDs, e.EventID)
+		}
+	}
+
+	for _, eventID := range eventIDs {
+		ack, _ := json.Marshal(map[string]string{
+			"event_id": eventID,
+			"status":   status,
+			"error":    errMsg,
+		})
+		reply := RelayMessage{
+			ID:         eventID,
+			TargetNode: msg.SourceNode,
+			SourceNode: msg.TargetNode,
+			Payload:    ack,
+			Type:       "ack",
+		}
+		if err := sender.enqueueLocked(reply); err != nil {
+			log.Printf("[RELAY] Erro ao responder %s para %s: %v", status, msg.SourceNode, err)
+			return
 		}
 	}
 }
@@ -95,6 +322,15 @@ func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 func main() {
 	flag.Parse()
 
+	if *hashSecret != "" {
+		hash, err := HashSecret(*hashSecret)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(hash)
+		return
+	}
+
 	// Prioriza variáveis de ambiente (padrão em Docker/Coolify)
 	envAddr := os.Getenv("PORT")
 	if envAddr == "" {
@@ -107,8 +343,83 @@ func main() {
 		*token = envToken
 	}
 
-	hub := &Hub{}
+	if envSpoolDir := os.Getenv("RELAY_SPOOL_DIR"); envSpoolDir != "" {
+		*spoolDir = envSpoolDir
+	}
+	durationFromEnv("RELAY_SPOOL_TTL", spoolTTL)
+	durationFromEnv("RELAY_PING_INTERVAL", pingInterval)
+	durationFromEnv("RELAY_PONG_TIMEOUT", pongTimeout)
+	durationFromEnv("RELAY_WRITE_TIMEOUT", writeTimeout)
+	if envSendBuffer := os.Getenv("RELAY_SEND_BUFFER"); envSendBuffer != "" {
+		n, err := strconv.Atoi(envSendBuffer)
+		if err != nil {
+			log.Fatalf("[RELAY] RELAY_SEND_BUFFER inválido: %v", err)
+		}
+		*sendBuffer = n
+	}
+	if envOverflow := os.Getenv("RELAY_OVERFLOW"); envOverflow != "" {
+		*overflowPolicy = envOverflow
+	}
+	switch *overflowPolicy {
+	case OverflowSpool, OverflowDrop, OverflowDisconnect:
+	default:
+		log.Fatalf("[RELAY] Política de overflow inválida: %q (use spool, drop ou disconnect)", *overflowPolicy)
+	}
+	if *sendBuffer <= 0 {
+		log.Fatalf("[RELAY] send-buffer deve ser maior que zero")
+	}
+	if *pongTimeout <= *pingInterval {
+		log.Fatalf("[RELAY] pong-timeout (%s) deve ser maior que ping-interval (%s)", *pongTimeout, *pingInterval)
+	}
 
-	log.Printf("[RELAY] Iniciando Relay Hub em %s (Token: %s)...", listenAddr, *token)
-	log.Fatal(http.ListenAndServe(listenAddr, hub))
+	spool, err := NewSpool(*spoolDir, *spoolTTL)
+	if err != nil {
+		log.Fatal(err)
+	}
+	go spool.RunExpiry(10 * time.Minute)
+
+	hub := &Hub{spool: spool, limiter: newAuthLimiter(runtime.NumCPU())}
+	log.Printf("[RELAY] Spool em %s (TTL: %s)", *spoolDir, *spoolTTL)
+
+	if envNodesFile := os.Getenv("RELAY_NODES_FILE"); envNodesFile != "" {
+		*nodesFile = envNodesFile
+	}
+	if *nodesFile != "" {
+		registry, err := LoadRegistry(*nodesFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		hub.registry = registry
+	} else {
+		if *token == "" {
+			log.Fatal("[RELAY] Defina o arquivo de credenciais (-nodes-file / RELAY_NODES_FILE) ou o token compartilhado (-token / RELAY_TOKEN)")
+		}
+		log.Println("[RELAY] AVISO: sem arquivo de credenciais (-nodes-file / RELAY_NODES_FILE). Usando token compartilhado")
+	}
+
+	if envAdminToken := os.Getenv("RELAY_ADMIN_TOKEN"); envAdminToken != "" {
+		*adminToken = envAdminToken
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/admin/", &AdminAPI{hub: hub, token: *adminToken})
+	mux.Handle("/", hub)
+	if *adminToken == "" {
+		log.Println("[RELAY] API administrativa desabilitada (defina -admin-token / RELAY_ADMIN_TOKEN)")
+	}
+
+	log.Printf("[RELAY] Iniciando Relay Hub em %s...", listenAddr)
+	log.Fatal(http.ListenAndServe(listenAddr, mux))
+}
+
+// durationFromEnv sobrescreve a flag com a variável de ambiente, se definida (ex: "30s", "72h")
+func durationFromEnv(name string, target *time.Duration) {
+	value := os.Getenv(name)
+	if value == "" {
+		return
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("[RELAY] %s inválido: %v", name, err)
+	}
+	*target = d
 }
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+)
+
+// PresencePayload é o payload das mensagens "presence" enviadas pelo Hub.
+// Com Snapshot, Nodes é a lista completa de nós online (enviada logo após a conexão);
+// caso contrário informa a entrada ou saída de um único nó.
+type PresencePayload struct {
+	NodeID   string   `json:"node_id,omitempty"`
+	Online   bool     `json:"online"`
+	Snapshot bool     `json:"snapshot,omitempty"`
+	Nodes    []string `json:"nodes,omitempty"`
+}
+
+// canSee indica se viewer deve ser avisado da presença de subject:
+// só quem pode enviar mensagens para o nó precisa saber se ele está online
+func (h *Hub) canSee(viewer, subject string) bool {
+	return viewer != subject && (h.registry == nil || h.registry.Allowed(viewer, subject, "presence"))
+}
+
+// broadcastPresence avisa os demais nós que nodeID conectou ou desconectou
+func (h *Hub) broadcastPresence(nodeID string, online bool) {
+	payload, _ := json.Marshal(PresencePayload{NodeID: nodeID, Online: online})
+
+	// Mesma trava do roteamento
+	h.spool.Lock()
+	defer h.spool.Unlock()
+
+	h.nodes.Range(func(key, value interface{}) bool {
+		viewer := value.(*nodeSession)
+		if !h.canSee(viewer.nodeID, nodeID) {
+			return true
+		}
+		msg := RelayMessage{TargetNode: viewer.nodeID, SourceNode: nodeID, Payload: payload, Type: "presence"}
+		if err := viewer.enqueueLocked(msg); err != nil {
+			log.Printf("[RELAY] Erro ao avisar presença de %s para %s: %v", nodeID, viewer.nodeID, err)
+		}
+		return true
+	})
+}
+
+// sendPresenceSnapshot envia ao nó recém-conectado a lista de nós online que ele pode ver
+func (h *Hub) sendPresenceSnapshot(session *nodeSession) {
+	nodes := []string{}
+	h.nodes.Range(func(key, value interface{}) bool {
+		if other := key.(string); h.canSee(session.nodeID, other) {
+			nodes = append(nodes, other)
+		}
+		return true
+	})
+	sort.Strings(nodes)
+	payload, _ := json.Marshal(PresencePayload{Online: true, Snapshot: true, Nodes: nodes})
+
+	h.spool.Lock()
+	defer h.spool.Unlock()
+	if err := session.enqueueLocked(RelayMessage{TargetNode: session.nodeID, Payload: payload, Type: "presence"}); err != nil {
+		log.Printf("[RELAY] Erro ao enviar lista de presença para %s: %v", session.nodeID, err)
+	}
+}
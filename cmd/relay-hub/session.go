@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Políticas para quando a fila de saída de um nó lento enche
+const (
+	OverflowDrop       = "drop"       // Descarta a mensagem; o emissor recebe "offline" e reenvia depois
+	OverflowDisconnect = "disconnect" // Derruba o nó lento; as mensagens passam a ir para o spool
+	OverflowSpool      = "spool"      // Guarda em disco e entrega quando a fila esvaziar
+)
+
+var (
+	errQueueFull     = errors.New("fila de saída cheia")
+	errSessionClosed = errors.New("conexão encerrada")
+)
+
+// nodeSession é a conexão ativa de um nó, com os contadores exibidos na API administrativa.
+// Toda escrita de mensagens passa pela fila de saída e por um único writer, já que o
+// gorilla/websocket não permite escritas concorrentes na mesma conexão.
+type nodeSession struct {
+	hub         *Hub
+	nodeID      string
+	conn        *websocket.Conn
+	remoteAddr  string
+	connectedAt time.Time
+	msgsIn      atomic.Int64 // Mensagens recebidas do nó
+	msgsOut     atomic.Int64 // Mensagens entregues ao nó
+
+	out        chan RelayMessage
+	wake       chan struct{} // Avisa o writer que há mensagens do nó no spool
+	done       chan struct{}
+	writerDone chan struct{}
+	stopped    chan struct{} // Fechado quando a sessão saiu do Hub e o spool já foi devolvido
+	replaced   atomic.Bool   // Encerrada por uma conexão mais nova do mesmo nó
+	closed     bool          // Protegido pela trava do spool
+	spilled    atomic.Bool   // Há mensagens do nó no spool a drenar enquanto ele está online
+	unsent     *RelayMessage // Mensagem cuja escrita falhou (só o writer escreve)
+
+	mu          sync.Mutex
+	lastError   string
+	lastErrorAt time.Time
+}
+
+func newNodeSession(h *Hub, nodeID string, conn *websocket.Conn, remoteAddr string) *nodeSession {
+	s := &nodeSession{
+		hub:         h,
+		nodeID:      nodeID,
+		conn:        conn,
+		remoteAddr:  remoteAddr,
+		connectedAt: time.Now(),
+		out:         make(chan RelayMessage, *sendBuffer),
+		wake:        make(chan struct{}, 1),
+		done:        make(chan struct{}),
+		writerDone:  make(chan struct{}),
+		stopped:     make(chan struct{}),
+	}
+	go s.writeLoop()
+	return s
+}
+
+func (s *nodeSession) setError(err error) {
+	s.mu.Lock()
+	s.lastError = err.Error()
+	s.lastErrorAt = time.Now()
+	s.mu.Unlock()
+}
+
+// enqueueLocked coloca a mensagem na fila de saída sem bloquear (exige a trava do spool,
+// que serializa o roteamento do Hub)
+func (s *nodeSession) enqueueLocked(msg RelayMessage) error {
+	if s.closed {
+		return errSessionClosed
+	}
+	select {
+	case s.out <- msg:
+		return nil
+	default:
+		return errQueueFull
+	}
+}
+
+// markSpilled avisa o writer que há mensagens do nó no spool para drenar
+func (s *nodeSession) markSpilled() {
+	s.spilled.Store(true)
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *nodeSession) writeLoop() {
+	defer close(s.writerDone)
+	for {
+		select {
+		case msg := <-s.out:
+			if err := s.write(msg); err != nil {
+				s.unsent = &msg
+				s.conn.Close()
+				return
+			}
+			if len(s.out) == 0 && s.spilled.Load() {
+				s.drainSpool()
+			}
+		case <-s.wake:
+			s.drainSpool()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// write envia a mensagem ao nó e atualiza os contadores (só o writer chama)
+func (s *nodeSession) write(msg RelayMessage) error {
+	data, _ := json.Marshal(msg)
+	s.conn.SetWriteDeadline(time.Now().Add(*writeTimeout))
+	if err := s.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+		s.setError(err)
+		return err
+	}
+	s.msgsOut.Add(1)
+	return nil
+}
+
+// drainSpool move para a fila de saída o que couber das mensagens guardadas do nó
+func (s *nodeSession) drainSpool() {
+	s.hub.spool.Lock()
+	defer s.hub.spool.Unlock()
+
+	if s.closed {
+		return
+	}
+	_, err := s.hub.spool.FlushLocked(s.nodeID, s.enqueueLocked)
+	switch {
+	case err == nil:
+		s.spilled.Store(false)
+	case !errors.Is(err, errQueueFull):
+		log.Printf("[RELAY] Erro ao drenar spool de %s: %v", s.nodeID, err)
+	}
+}
+
+// shutdown para o writer e devolve ao início do spool o que ainda não foi entregue,
+// preservando a ordem para a próxima conexão do nó. A sessão continua registrada no
+// Hub até aqui, então a próxima conexão só entra depois (ver register).
+func (s *nodeSession) shutdown() {
+	close(s.done)
+	<-s.writerDone
+
+	h := s.hub
+	h.spool.Lock()
+	defer h.spool.Unlock()
+	s.closed = true
+
+	var leftover []RelayMessage
+	if s.unsent != nil {
+		leftover = append(leftover, *s.unsent)
+	}
+	for len(s.out) > 0 {
+		leftover = append(leftover, <-s.out)
+	}
+
+	var keep []RelayMessage
+	for _, msg := range leftover {
+		if spoolable(msg) {
+			keep = append(keep, msg)
+		}
+	}
+	if len(keep) == 0 {
+		return
+	}
+	if err := h.spool.PrependLocked(s.nodeID, keep); err != nil {
+		log.Printf("[RELAY] Erro ao devolver %d mensagem(ns) de %s ao spool: %v", len(keep), s.nodeID, err)
+		return
+	}
+	log.Printf("[RELAY] %d mensagem(ns) não entregue(s) a %s voltaram ao spool", len(keep), s.nodeID)
+}
+
+// keepalive envia pings periódicos e renova o prazo de leitura a cada ping/pong
+// recebido, derrubando conexões meio-abertas. Feche o canal retornado para parar.
+func (s *nodeSession) keepalive() chan struct{} {
+	s.extendDeadline()
+	s.conn.SetPongHandler(func(string) error {
+		s.extendDeadline()
+		return nil
+	})
+	s.conn.SetPingHandler(func(data string) error {
+		s.extendDeadline()
+		err := s.conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(*writeTimeout))
+		if err == websocket.ErrCloseSent {
+			return nil
+		}
+		return err
+	})
+
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(*pingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				// WriteControl pode ser chamado junto com as escritas do writer
+				if err := s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(*writeTimeout)); err != nil {
+					s.setError(fmt.Errorf("falha no ping: %w", err))
+					s.conn.Close()
+					return
+				}
+			}
+		}
+	}()
+	return stop
+}
+
+func (s *nodeSession) extendDeadline() {
+	s.conn.SetReadDeadline(time.Now().Add(*pongTimeout))
+}
+
+// spoolable indica se a mensagem vale a pena ser guardada: "sync", "sync_batch" e
+// "ack" continuam válidos depois; o resto perde sentido com o tempo
+func spoolable(msg RelayMessage) bool {
+	return msg.Type == "sync" || msg.Type == "sync_batch" || msg.Type == "ack"
+}
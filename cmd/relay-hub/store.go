@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// spoolEntry é uma linha do arquivo de spool de um nó
+type spoolEntry struct {
+	QueuedAt time.Time    `json:"queued_at"`
+	Message  RelayMessage `json:"message"`
+}
+
+// Spool guarda em disco (um arquivo JSONL por nó) as mensagens de nós offline,
+// para entregá-las em ordem quando o nó reconectar
+type Spool struct {
+	mu  sync.Mutex
+	dir string
+	ttl time.Duration
+
+	// Mensagens guardadas por nó, mantidas a cada gravação para a API administrativa
+	// não precisar ler os arquivos (nem disputar mu com o roteamento)
+	countMu sync.Mutex
+	counts  map[string]int
+}
+
+func NewSpool(dir string, ttl time.Duration) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("erro ao criar diretório de spool: %w", err)
+	}
+	s := &Spool{dir: dir, ttl: ttl, counts: make(map[string]int)}
+
+	// Contagem inicial dos spools deixados pela execução anterior
+	for _, nodeID := range s.nodes() {
+		entries, err := s.read(nodeID)
+		if err != nil {
+			log.Printf("[SPOOL] Erro ao ler spool de %s: %v", nodeID, err)
+			continue
+		}
+		s.setCount(nodeID, len(entries))
+	}
+	return s, nil
+}
+
+// setCount registra quantas mensagens o spool do nó tem agora
+func (s *Spool) setCount(nodeID string, n int) {
+	s.countMu.Lock()
+	defer s.countMu.Unlock()
+	if n > 0 {
+		s.counts[nodeID] = n
+	} else {
+		delete(s.counts, nodeID)
+	}
+}
+
+// nodes lista os nós que têm arquivo de spool
+func (s *Spool) nodes() []string {
+	files, err := filepath.Glob(filepath.Join(s.dir, "*.jsonl"))
+	if err != nil {
+		return nil
+	}
+	var nodes []string
+	for _, file := range files {
+		raw, err := hex.DecodeString(trimExt(filepath.Base(file)))
+		if err != nil {
+			continue
+		}
+		nodes = append(nodes, string(raw))
+	}
+	return nodes
+}
+
+// Lock/Unlock permitem ao Hub decidir entre entrega direta e spool de forma atômica
+func (s *Spool) Lock()   { s.mu.Lock() }
+func (s *Spool) Unlock() { s.mu.Unlock() }
+
+func (s *Spool) path(nodeID string) string {
+	// NodeID vem do cliente: codificado em hex para nunca virar caminho
+	return filepath.Join(s.dir, hex.EncodeToString([]byte(nodeID))+".jsonl")
+}
+
+// HasPendingLocked indica se há mensagens guardadas para o nó (exige Lock)
+func (s *Spool) HasPendingLocked(nodeID string) bool {
+	info, err := os.Stat(s.path(nodeID))
+	return err == nil && info.Size() > 0
+}
+
+// AppendLocked guarda a mensagem no fim do spool do nó (exige Lock)
+func (s *Spool) AppendLocked(nodeID string, msg RelayMessage) error {
+	line, err := json.Marshal(spoolEntry{QueuedAt: time.Now(), Message: msg})
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(s.path(nodeID), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+
+	s.countMu.Lock()
+	s.counts[nodeID]++
+	s.countMu.Unlock()
+	return nil
+}
+
+// PrependLocked devolve mensagens ao início do spool do nó, antes das já guardadas (exige Lock)
+func (s *Spool) PrependLocked(nodeID string, msgs []RelayMessage) error {
+	existing, err := s.read(nodeID)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	entries := make([]spoolEntry, 0, len(msgs)+len(existing))
+	for _, msg := range msgs {
+		entries = append(entries, spoolEntry{QueuedAt: now, Message: msg})
+	}
+	return s.rewrite(nodeID, append(entries, existing...))
+}
+
+// FlushLocked entrega em ordem as mensagens guardadas do nó (exige Lock).
+// Mensagens expiradas são descartadas; se a entrega falhar, o restante
+// permanece no spool para a próxima conexão.
+func (s *Spool) FlushLocked(nodeID string, deliver func(RelayMessage) error) (int, error) {
+	entries, err := s.read(nodeID)
+	if err != nil || len(entries) == 0 {
+		return 0, err
+	}
+
+	delivered := 0
+	for i, e := range entries {
+		if s.expired(e) {
+			continue
+		}
+		if err := deliver(e.Message); err != nil {
+			if werr := s.rewrite(nodeID, entries[i:]); werr != nil {
+				log.Printf("[SPOOL] Erro ao regravar spool de %s: %v", nodeID, werr)
+			}
+			return delivered, err
+		}
+		delivered++
+	}
+	s.setCount(nodeID, 0)
+	return delivered, os.Remove(s.path(nodeID))
+}
+
+// Pending retorna a quantidade de mensagens guardadas por nó (sem ler os arquivos)
+func (s *Spool) Pending() map[string]int {
+	s.countMu.Lock()
+	defer s.countMu.Unlock()
+
+	counts := make(map[string]int, len(s.counts))
+	for nodeID, n := range s.counts {
+		counts[nodeID] = n
+	}
+	return counts
+}
+
+// Expire remove de todos os spools as mensagens com mais de TTL
+func (s *Spool) Expire() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, nodeID := range s.nodes() {
+		entries, err := s.read(nodeID)
+		if err != nil {
+			log.Printf("[SPOOL] Erro ao ler spool de %s: %v", nodeID, err)
+			continue
+		}
+		var kept []spoolEntry
+		for _, e := range entries {
+			if !s.expired(e) {
+				kept = append(kept, e)
+			}
+		}
+		if len(kept) == len(entries) {
+			continue
+		}
+		log.Printf("[SPOOL] %d mensagem(ns) expirada(s) para %s", len(entries)-len(kept), nodeID)
+		if err := s.rewrite(nodeID, kept); err != nil {
+			log.Printf("[SPOOL] Erro ao regravar spool de %s: %v", nodeID, err)
+		}
+	}
+}
+
+// RunExpiry executa Expire periodicamente
+func (s *Spool) RunExpiry(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.Expire()
+	}
+}
+
+func (s *Spool) expired(e spoolEntry) bool {
+	return s.ttl > 0 && time.Since(e.QueuedAt) > s.ttl
+}
+
+func (s *Spool) read(nodeID string) ([]spoolEntry, error) {
+	f, err := os.Open(s.path(nodeID))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []spoolEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var e spoolEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			log.Printf("[SPOOL] Linha inválida no spool de %s descartada: %v", nodeID, err)
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+// rewrite substitui o spool do nó de forma atômica (arquivo temporário + rename)
+func (s *Spool) rewrite(nodeID string, entries []spoolEntry) error {
+	path := s.path(nodeID)
+	if len(entries) == 0 {
+		s.setCount(nodeID, 0)
+		err := os.Remove(path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, e := range entries {
+		line, _ := json.Marshal(e)
+		w.Write(append(line, '\n'))
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	s.setCount(nodeID, len(entries))
+	return nil
+}
+
+func trimExt(name string) string {
+	return name[:len(name)-len(filepath.Ext(name))]
+}
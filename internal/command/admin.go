@@ -0,0 +1,282 @@
+package command
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/atsinformatica/firebird-sync-agent/internal/config"
+	"github.com/atsinformatica/firebird-sync-agent/internal/db"
+	"github.com/atsinformatica/firebird-sync-agent/internal/ui"
+)
+
+// Admin agrupa os comandos que alteram o estado do nó. Diferente dos diagnósticos,
+// só podem ser chamados por nós listados em commands.admin_sources e toda execução
+// (inclusive as recusadas) fica registrada em SYNC_AUDITORIA no nó alvo.
+type Admin struct {
+	registry   *Registry
+	conf       *config.Store
+	configPath string
+	db         *sql.DB
+	queue      *db.QueueManager
+
+	mu      sync.Mutex
+	running map[int64]bool // Cargas (resync) rodando em segundo plano neste processo
+}
+
+// RegisterAdmin registra os comandos administrativos no registry
+func RegisterAdmin(r *Registry, conf *config.Store, configPath string, dbConn *sql.DB, queue *db.QueueManager) *Admin {
+	a := &Admin{registry: r, conf: conf, configPath: configPath, db: dbConn, queue: queue, running: make(map[int64]bool)}
+
+	a.Register("install_triggers", false, a.installTriggers)
+	a.Register("requeue_failed", false, a.requeueFailed)
+	a.Register("reload_config", false, a.reloadConfig)
+	a.Register("rotate_token", true, a.rotateToken)
+	a.Register("resync_table", false, a.resyncTable)
+
+	// Consulta de progresso é somente leitura: fica fora da auditoria
+	r.Register("resync_status", a.resyncStatus)
+	return a
+}
+
+func (a *Admin) cfg() *config.Config {
+	return a.conf.Get()
+}
+
+// Register adiciona um comando administrativo: a origem é validada e a execução
+// auditada antes de devolver o resultado. Com sensitive, os argumentos não são
+// gravados na auditoria (ex: tokens).
+func (a *Admin) Register(name string, sensitive bool, handler Handler) {
+	a.registry.Register(name, func(ctx context.Context, source string, args json.RawMessage) (interface{}, error) {
+		auditArgs := string(args)
+		if sensitive {
+			auditArgs = "(omitido)"
+		}
+
+		if !a.cfg().IsAdminSource(source) {
+			log.Printf("[COMANDO] %s recusado: origem %s não autorizada", name, source)
+			a.audit(source, name, auditArgs, db.AuditDenied, "origem não autorizada")
+			return nil, fmt.Errorf("nó %s não autorizado a executar %s", source, name)
+		}
+
+		log.Printf("[COMANDO] Executando %s (origem: %s)", name, source)
+		result, err := handler(ctx, source, args)
+		if err != nil {
+			log.Printf("[COMANDO] %s falhou: %v", name, err)
+			a.audit(source, name, auditArgs, db.AuditError, err.Error())
+			return nil, err
+		}
+
+		data, _ := json.Marshal(result)
+		a.audit(source, name, auditArgs, db.AuditOK, string(data))
+		return result, nil
+	})
+}
+
+func (a *Admin) audit(source, name, args, status, result string) {
+	if err := db.InsertAudit(a.db, source, name, args, status, result); err != nil {
+		log.Printf("[COMANDO] Erro ao gravar auditoria de %s: %v", name, err)
+	}
+}
+
+// installTriggers reinstala as triggers (por padrão, em todas as tabelas integradas),
+// útil depois de atualizar o agente para uma nova TriggerVersion
+func (a *Admin) installTriggers(ctx context.Context, source string, args json.RawMessage) (interface{}, error) {
+	var req struct {
+		Tables []string `json:"tables"`
+	}
+	if err := decodeArgs(args, &req); err != nil {
+		return nil, err
+	}
+	if a.cfg().CaptureMode() == config.CaptureTrace {
+		return nil, fmt.Errorf("nó em modo de captura trace: triggers não são usadas")
+	}
+
+	tables := req.Tables
+	if len(tables) == 0 {
+		var err error
+		if tables, err = db.GetIntegratedTables(a.db); err != nil {
+			return nil, err
+		}
+	}
+	if len(tables) == 0 {
+		return nil, fmt.Errorf("nenhuma tabela integrada")
+	}
+
+	if err := ui.InstallTriggers(a.db, tables); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"tabelas": tables, "versao": ui.TriggerVersion}, nil
+}
+
+// requeueFailed devolve à fila os destinos em falha definitiva
+func (a *Admin) requeueFailed(ctx context.Context, source string, args json.RawMessage) (interface{}, error) {
+	var req struct {
+		NodeID string `json:"node_id"`
+		Table  string `json:"table"`
+	}
+	if err := decodeArgs(args, &req); err != nil {
+		return nil, err
+	}
+	n, skipped, err := a.queue.RequeueFailed(req.NodeID, req.Table)
+	if err != nil {
+		return nil, err
+	}
+	// Ignorados: a linha já recebeu um evento mais novo no nó
+	return map[string]interface{}{"reenfileirados": n, "ignorados_versao_mais_nova": skipped}, nil
+}
+
+// reloadConfig relê o config.yaml e aplica o que pode mudar sem reiniciar
+func (a *Admin) reloadConfig(ctx context.Context, source string, args json.RawMessage) (interface{}, error) {
+	n, err := config.Load(a.configPath)
+	if err != nil {
+		return nil, err
+	}
+	var restart []string
+	if _, err := a.conf.Update(func(c *config.Config) error {
+		restart = c.ApplyReloadable(n)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	if restart == nil {
+		restart = []string{}
+	}
+	return map[string]interface{}{"recarregado": true, "requer_reinicio": restart}, nil
+}
+
+// rotateToken troca os tokens do Relay, do webhook e/ou do /command e grava no
+// config.yaml. O novo token do Relay vale na próxima reconexão; o hub precisa
+// aceitá-lo antes (no arquivo de credenciais), senão o nó fica sem conexão.
+func (a *Admin) rotateToken(ctx context.Context, source string, args json.RawMessage) (interface{}, error) {
+	var req struct {
+		RelayToken    string `json:"relay_token"`
+		WebhookToken  string `json:"webhook_token"`
+		CommandsToken string `json:"commands_token"`
+	}
+	if err := decodeArgs(args, &req); err != nil {
+		return nil, err
+	}
+	if req.RelayToken == "" && req.WebhookToken == "" && req.CommandsToken == "" {
+		return nil, fmt.Errorf("informe relay_token, webhook_token e/ou commands_token")
+	}
+
+	var changed []string
+	_, err := a.conf.Update(func(c *config.Config) error {
+		if req.RelayToken != "" {
+			c.Relay.Token = req.RelayToken
+			changed = append(changed, "relay.token")
+		}
+		if req.WebhookToken != "" {
+			c.Webhook.Token = req.WebhookToken
+			changed = append(changed, "webhook.token")
+		}
+		if req.CommandsToken != "" {
+			c.Commands.Token = req.CommandsToken
+			changed = append(changed, "commands.token")
+		}
+		// Grava antes de publicar: se falhar, o agente continua com os tokens antigos
+		return c.Save(a.configPath)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"alterados": changed}, nil
+}
+
+// resyncTable inicia (ou retoma) em segundo plano a carga inicial de uma tabela, ou
+// de todas as integradas com table "all", para um nó ou para todos os destinos.
+// O progresso é acompanhado com resync_status.
+func (a *Admin) resyncTable(ctx context.Context, source string, args json.RawMessage) (interface{}, error) {
+	var req struct {
+		Table    string `json:"table"`
+		NodeID   string `json:"node_id"`
+		Restart  bool   `json:"restart"`
+		PageSize int    `json:"page_size"`
+	}
+	if err := decodeArgs(args, &req); err != nil {
+		return nil, err
+	}
+	if req.Table == "" {
+		return nil, fmt.Errorf("informe table (ou \"all\" para todas as tabelas integradas)")
+	}
+
+	tables := []string{req.Table}
+	if strings.EqualFold(req.Table, "all") {
+		var err error
+		if tables, err = db.GetIntegratedTables(a.db); err != nil {
+			return nil, err
+		}
+	}
+
+	var jobs []*db.ResyncJob
+	for _, table := range tables {
+		job, err := a.queue.StartResync(table, req.NodeID, req.Restart)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", table, err)
+		}
+		jobs = append(jobs, job)
+	}
+
+	// Um único goroutine por chamada: as tabelas são carregadas em sequência
+	var started []*db.ResyncJob
+	a.mu.Lock()
+	for _, job := range jobs {
+		if !a.running[job.ID] {
+			a.running[job.ID] = true
+			started = append(started, job)
+		}
+	}
+	a.mu.Unlock()
+
+	go func() {
+		for _, job := range started {
+			a.runResync(job, req.PageSize)
+		}
+	}()
+	return map[string]interface{}{"cargas": jobs}, nil
+}
+
+func (a *Admin) runResync(job *db.ResyncJob, pageSize int) {
+	defer func() {
+		a.mu.Lock()
+		delete(a.running, job.ID)
+		a.mu.Unlock()
+	}()
+
+	log.Printf("[RESYNC] Carga %d: %s para %s (retomando de %d linha(s))", job.ID, job.Tabela, job.NodeID, job.Total)
+	final, err := a.queue.RunResync(context.Background(), job.ID, pageSize, nil)
+	if err != nil {
+		log.Printf("[RESYNC] Carga %d (%s) parou com erro: %v", job.ID, job.Tabela, err)
+		return
+	}
+	log.Printf("[RESYNC] Carga %d (%s) concluída: %d linha(s) enfileirada(s)", final.ID, final.Tabela, final.Total)
+}
+
+func (a *Admin) resyncStatus(ctx context.Context, source string, args json.RawMessage) (interface{}, error) {
+	var req struct {
+		Limit int `json:"limit"`
+	}
+	if err := decodeArgs(args, &req); err != nil {
+		return nil, err
+	}
+	if req.Limit <= 0 {
+		req.Limit = 20
+	}
+	jobs, err := a.queue.GetResyncJobs(req.Limit)
+	if err != nil {
+		return nil, err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	result := make([]map[string]interface{}, 0, len(jobs))
+	for _, job := range jobs {
+		result = append(result, map[string]interface{}{"carga": job, "executando": a.running[job.ID]})
+	}
+	return result, nil
+}
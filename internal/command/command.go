@@ -0,0 +1,78 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Handler executa um comando e devolve um resultado serializável em JSON.
+// source é o nó que pediu a execução (o próprio nó quando chamado localmente).
+type Handler func(ctx context.Context, source string, args json.RawMessage) (interface{}, error)
+
+// Registry guarda os comandos que o agente responde via Relay ou pelo endpoint /command
+type Registry struct {
+	mu       sync.RWMutex
+	handlers map[string]Handler
+}
+
+func NewRegistry() *Registry {
+	r := &Registry{handlers: make(map[string]Handler)}
+	r.Register("list_commands", func(ctx context.Context, source string, args json.RawMessage) (interface{}, error) {
+		return r.Names(), nil
+	})
+	return r
+}
+
+func (r *Registry) Register(name string, handler Handler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handlers[name] = handler
+}
+
+// Names retorna os comandos registrados, em ordem alfabética
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.handlers))
+	for name := range r.handlers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Execute roda o comando; um panic no handler vira erro em vez de derrubar o agente
+func (r *Registry) Execute(ctx context.Context, source, name string, args json.RawMessage) (result interface{}, err error) {
+	r.mu.RLock()
+	handler, ok := r.handlers[name]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("comando desconhecido: %s (disponíveis: %s)", name, strings.Join(r.Names(), ", "))
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("falha ao executar %s: %v", name, p)
+		}
+	}()
+	return handler(ctx, source, args)
+}
+
+// decodeArgs decodifica os argumentos do comando; argumentos vazios deixam v inalterado.
+// Números em campos interface{} (ex: valores de PK) chegam como json.Number, sem perder precisão.
+func decodeArgs(args json.RawMessage, v interface{}) error {
+	if len(args) == 0 || string(args) == "null" {
+		return nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(args))
+	decoder.UseNumber()
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("argumentos inválidos: %w", err)
+	}
+	return nil
+}
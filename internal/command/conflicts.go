@@ -0,0 +1,86 @@
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/atsinformatica/firebird-sync-agent/internal/db"
+)
+
+// ConflictResolver aplica a resolução de um conflito retido (webhook.Server)
+type ConflictResolver interface {
+	ResolveConflict(id int64, resolution string, data map[string]interface{}, by string) (*db.ConflictRecord, error)
+}
+
+// RegisterConflicts registra a revisão dos eventos retidos em SYNC_CONFLITOS:
+// conflicts lista/consulta e resolve_conflict (administrativo) aplica o recebido,
+// mantém o local ou aplica os dados corrigidos.
+func RegisterConflicts(a *Admin, resolver ConflictResolver) {
+	// Somente leitura, mas expõe dados das linhas: restrito às origens administrativas
+	// e fora da auditoria (o resultado pode ser grande)
+	a.registry.Register("conflicts", func(ctx context.Context, source string, args json.RawMessage) (interface{}, error) {
+		var req struct {
+			ID     int64  `json:"id"`
+			Status string `json:"status"`
+			Table  string `json:"table"`
+			Limit  int    `json:"limit"`
+		}
+		if err := decodeArgs(args, &req); err != nil {
+			return nil, err
+		}
+		if !a.cfg().IsAdminSource(source) {
+			return nil, fmt.Errorf("nó %s não autorizado a consultar conflitos", source)
+		}
+
+		if req.ID > 0 {
+			c, err := a.queue.GetConflict(req.ID)
+			if err != nil {
+				return nil, err
+			}
+			if c == nil {
+				return nil, fmt.Errorf("conflito %d não encontrado", req.ID)
+			}
+			return c, nil
+		}
+
+		// Padrão: só os pendentes; "all" lista também os resolvidos
+		status := strings.ToUpper(req.Status)
+		switch status {
+		case "":
+			status = db.ConflictPending
+		case "ALL":
+			status = ""
+		}
+		if req.Limit <= 0 {
+			req.Limit = 50
+		}
+		return a.queue.GetConflicts(status, req.Table, req.Limit)
+	})
+
+	a.Register("resolve_conflict", false, func(ctx context.Context, source string, args json.RawMessage) (interface{}, error) {
+		var req struct {
+			ID         int64                  `json:"id"`
+			Resolution string                 `json:"resolution"`
+			Data       map[string]interface{} `json:"data"`
+			User       string                 `json:"user"`
+		}
+		if err := decodeArgs(args, &req); err != nil {
+			return nil, err
+		}
+		if req.ID <= 0 || req.Resolution == "" {
+			return nil, fmt.Errorf("informe id e resolution (%s, %s ou %s)", db.ResolutionIncoming, db.ResolutionLocal, db.ResolutionEdited)
+		}
+		if req.Resolution != db.ResolutionEdited && len(req.Data) > 0 {
+			return nil, fmt.Errorf("data só é usado com resolution %s", db.ResolutionEdited)
+		}
+
+		// Quem decidiu: o usuário informado pelo cliente (CLI/back-office) e o nó de onde veio
+		by := source
+		if req.User != "" {
+			by = req.User + "@" + source
+		}
+		return resolver.ResolveConflict(req.ID, req.Resolution, req.Data, by)
+	})
+}
@@ -0,0 +1,109 @@
+package command
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/atsinformatica/firebird-sync-agent/internal/config"
+	"github.com/atsinformatica/firebird-sync-agent/internal/db"
+	"github.com/atsinformatica/firebird-sync-agent/internal/ui"
+)
+
+// RegisterDiagnostics registra os comandos somente-leitura usados para diagnosticar
+// um nó à distância (ex: loja atrás de NAT)
+func RegisterDiagnostics(r *Registry, conf *config.Store, dbConn *sql.DB, queue *db.QueueManager) {
+	r.Register("queue_status", func(ctx context.Context, source string, args json.RawMessage) (interface{}, error) {
+		return queue.GetQueueStatus()
+	})
+
+	r.Register("pending_by_table", func(ctx context.Context, source string, args json.RawMessage) (interface{}, error) {
+		return queue.GetPendingByTable()
+	})
+
+	r.Register("config_summary", func(ctx context.Context, source string, args json.RawMessage) (interface{}, error) {
+		return configSummary(conf.Get()), nil
+	})
+
+	r.Register("trigger_version", func(ctx context.Context, source string, args json.RawMessage) (interface{}, error) {
+		installed, err := ui.InstalledTriggerVersions(dbConn)
+		if err != nil {
+			return nil, err
+		}
+		outdated := []string{}
+		for table, version := range installed {
+			if version != ui.TriggerVersion {
+				outdated = append(outdated, table)
+			}
+		}
+		sort.Strings(outdated)
+		return map[string]interface{}{
+			"esperada":       ui.TriggerVersion,
+			"instaladas":     installed,
+			"desatualizadas": outdated,
+		}, nil
+	})
+
+	r.Register("row_lookup", func(ctx context.Context, source string, args json.RawMessage) (interface{}, error) {
+		var req struct {
+			Table string                 `json:"table"`
+			PK    map[string]interface{} `json:"pk"`
+		}
+		if err := decodeArgs(args, &req); err != nil {
+			return nil, err
+		}
+		if req.Table == "" || len(req.PK) == 0 {
+			return nil, fmt.Errorf("informe table e pk")
+		}
+		// Só tabelas integradas: evita expor tabelas do ERP que não participam da sincronização
+		if !db.IsTableIntegrated(dbConn, req.Table) {
+			return nil, fmt.Errorf("tabela %s não está integrada", strings.ToUpper(req.Table))
+		}
+		row, err := queue.LookupRow(req.Table, req.PK)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"encontrado": row != nil, "registro": row}, nil
+	})
+}
+
+// configSummary resume a configuração sem expor senhas e tokens
+func configSummary(cfg *config.Config) map[string]interface{} {
+	return map[string]interface{}{
+		"node_id":      cfg.NodeID,
+		"capture_mode": cfg.CaptureMode(),
+		"firebird_dsn": maskDSN(cfg.Firebird.DSN),
+		"webhook": map[string]interface{}{
+			"listen_addr":     cfg.Webhook.ListenAddr,
+			"remote_url":      cfg.Webhook.RemoteURL,
+			"token_definido":  cfg.Webhook.Token != "",
+			"unknown_columns": cfg.Webhook.UnknownColumns,
+		},
+		"relay": map[string]interface{}{
+			"enabled":        cfg.Relay.Enabled,
+			"hub_url":        cfg.Relay.HubURL,
+			"token_definido": cfg.Relay.Token != "",
+		},
+		"commands": map[string]interface{}{
+			"token_definido": cfg.Commands.Token != "",
+			"admin_sources":  cfg.Commands.AdminSources,
+		},
+		"integracao": cfg.Integracao,
+	}
+}
+
+// maskDSN troca a senha de "user:senha@host/path" por ****
+func maskDSN(dsn string) string {
+	at := strings.LastIndex(dsn, "@")
+	if at == -1 {
+		return dsn
+	}
+	colon := strings.Index(dsn[:at], ":")
+	if colon == -1 {
+		return dsn
+	}
+	return dsn[:colon+1] + "****" + dsn[at:]
+}
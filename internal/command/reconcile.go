@@ -0,0 +1,397 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/atsinformatica/firebird-sync-agent/internal/db"
+)
+
+// Caller executa um comando em outro nó (via Relay ou /command direto)
+type Caller interface {
+	Call(ctx context.Context, target, name string, args json.RawMessage) (json.RawMessage, error)
+}
+
+const (
+	reconcileBatch   = 50    // Intervalos por chamada de checksum_ranges
+	reconcileSplit   = 10    // Fator de divisão de um intervalo divergente
+	reconcileSample  = 50    // PKs listadas por tipo de divergência no relatório
+	reconcileMaxLeaf = 10000 // Máximo de linhas comparadas uma a uma num intervalo
+	reconcileUnsent  = 500   // PKs por chamada de unsent_rows
+)
+
+// reconcileReport é o resultado de reconcile
+type reconcileReport struct {
+	Tabela        string                     `json:"tabela"`
+	NodeID        string                     `json:"node_id"`
+	Colunas       []string                   `json:"colunas"`
+	Intervalos    int                        `json:"intervalos_comparados"`
+	Iguais        bool                       `json:"iguais"`
+	Faltando      int                        `json:"faltando_no_destino"` // Linhas que só existem neste nó
+	Sobrando      int                        `json:"sobrando_no_destino"` // Linhas que só existem no destino
+	Diferentes    int                        `json:"diferentes"`
+	Amostra       map[string][]interface{}   `json:"amostra,omitempty"`
+	NaoResolvidos []db.RangeChecksum         `json:"intervalos_nao_resolvidos,omitempty"`
+	Reparado      bool                       `json:"reparado"`
+	Enfileirados  int                        `json:"eventos_enfileirados"`
+	EmCurso       int                        `json:"ignorados_eventos_em_curso,omitempty"` // Linhas com eventos ainda não entregues
+	SemExclusao   int                        `json:"exclusoes_omitidas,omitempty"`         // Sobrando, sem "delete" no pedido
+	upserts       []map[string]interface{}   // Faltando + diferentes: reenviados como upsert
+	deletes       []map[string]interface{}   // Sobrando: reenviados como delete
+	sample        map[string]map[string]bool // Controle da amostra
+}
+
+func (r *reconcileReport) add(kind string, pk map[string]interface{}) {
+	switch kind {
+	case "faltando_no_destino":
+		r.Faltando++
+		r.upserts = append(r.upserts, pk)
+	case "sobrando_no_destino":
+		r.Sobrando++
+		r.deletes = append(r.deletes, pk)
+	case "diferentes":
+		r.Diferentes++
+		r.upserts = append(r.upserts, pk)
+	}
+	if len(r.Amostra[kind]) < reconcileSample {
+		r.Amostra[kind] = append(r.Amostra[kind], pk)
+	}
+}
+
+// RegisterReconcile registra a reconciliação por checksum entre nós: checksum_ranges,
+// checksum_rows e unsent_rows (somente leitura) respondem ao nó que conduz a
+// comparação; reconcile (administrativo) conduz a comparação a partir deste nó, que é
+// tratado como a referência, e com repair enfileira as correções para o destino.
+// Exclusões das linhas que só existem no destino exigem também delete.
+func RegisterReconcile(a *Admin, caller Caller) {
+	a.registry.Register("checksum_ranges", func(ctx context.Context, source string, args json.RawMessage) (interface{}, error) {
+		var req struct {
+			Table   string       `json:"table"`
+			Columns []string     `json:"columns"`
+			Ranges  []db.PKRange `json:"ranges"`
+		}
+		if err := decodeArgs(args, &req); err != nil {
+			return nil, err
+		}
+		if err := a.checkIntegrated(req.Table); err != nil {
+			return nil, err
+		}
+		return a.queue.ChecksumRanges(req.Table, req.Columns, req.Ranges)
+	})
+
+	a.registry.Register("checksum_rows", func(ctx context.Context, source string, args json.RawMessage) (interface{}, error) {
+		var req struct {
+			Table   string     `json:"table"`
+			Columns []string   `json:"columns"`
+			Range   db.PKRange `json:"range"`
+			Limit   int        `json:"limit"`
+		}
+		if err := decodeArgs(args, &req); err != nil {
+			return nil, err
+		}
+		if err := a.checkIntegrated(req.Table); err != nil {
+			return nil, err
+		}
+		if req.Limit <= 0 || req.Limit > reconcileMaxLeaf {
+			req.Limit = reconcileMaxLeaf
+		}
+		return a.queue.RowChecksums(req.Table, req.Columns, req.Range, req.Limit)
+	})
+
+	a.registry.Register("unsent_rows", func(ctx context.Context, source string, args json.RawMessage) (interface{}, error) {
+		var req struct {
+			Table string                   `json:"table"`
+			PKs   []map[string]interface{} `json:"pks"`
+		}
+		if err := decodeArgs(args, &req); err != nil {
+			return nil, err
+		}
+		if err := a.checkIntegrated(req.Table); err != nil {
+			return nil, err
+		}
+		unsent, err := a.queue.UnsentRowKeys(req.Table)
+		if err != nil {
+			return nil, err
+		}
+		result := []map[string]interface{}{}
+		for _, pk := range req.PKs {
+			if unsent[rowKey(req.Table, pk)] {
+				result = append(result, pk)
+			}
+		}
+		return result, nil
+	})
+
+	a.Register("reconcile", false, func(ctx context.Context, source string, args json.RawMessage) (interface{}, error) {
+		var req struct {
+			Table  string `json:"table"`
+			NodeID string `json:"node_id"`
+			Repair bool   `json:"repair"`
+			Delete bool   `json:"delete"` // Com repair: apaga no destino as linhas que só existem lá
+			Chunk  int    `json:"chunk"`
+			Leaf   int    `json:"leaf"`
+		}
+		if err := decodeArgs(args, &req); err != nil {
+			return nil, err
+		}
+		if req.Table == "" || req.NodeID == "" {
+			return nil, fmt.Errorf("informe table e node_id")
+		}
+		if req.NodeID == a.cfg().NodeID {
+			return nil, fmt.Errorf("o destino não pode ser o próprio nó")
+		}
+		if err := a.checkIntegrated(req.Table); err != nil {
+			return nil, err
+		}
+		if req.Chunk <= 0 {
+			req.Chunk = 1000
+		}
+		if req.Leaf <= 0 {
+			req.Leaf = 100
+		}
+		if req.Leaf > req.Chunk {
+			req.Leaf = req.Chunk
+		}
+
+		report, err := a.reconcile(ctx, caller, strings.ToUpper(req.Table), req.NodeID, req.Chunk, req.Leaf)
+		if err != nil {
+			return nil, err
+		}
+		if req.Repair {
+			if err := a.repair(ctx, caller, report, req.Delete); err != nil {
+				return nil, err
+			}
+		}
+		return report, nil
+	})
+}
+
+// repair enfileira as correções do relatório. Ficam de fora as linhas com eventos
+// ainda em curso em qualquer um dos nós (o evento levará o estado delas) e, sem
+// withDeletes, as que só existem no destino: podem ter sido criadas lá e ainda não
+// enviadas, e apagá-las perderia dados.
+func (a *Admin) repair(ctx context.Context, caller Caller, report *reconcileReport, withDeletes bool) error {
+	deletes := report.deletes
+	if !withDeletes {
+		report.SemExclusao = len(deletes)
+		deletes = nil
+	}
+	candidates := append(append([]map[string]interface{}{}, report.upserts...), deletes...)
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	skip, err := a.queue.UnsentRowKeys(report.Tabela)
+	if err != nil {
+		return err
+	}
+	for start := 0; start < len(candidates); start += reconcileUnsent {
+		end := start + reconcileUnsent
+		if end > len(candidates) {
+			end = len(candidates)
+		}
+		args, _ := json.Marshal(map[string]interface{}{"table": report.Tabela, "pks": candidates[start:end]})
+		var remote []map[string]interface{}
+		if err := callDecode(ctx, caller, report.NodeID, "unsent_rows", args, &remote); err != nil {
+			return err
+		}
+		for _, pk := range remote {
+			skip[rowKey(report.Tabela, pk)] = true
+		}
+	}
+
+	upserts := withoutRows(report.Tabela, report.upserts, skip)
+	deletes = withoutRows(report.Tabela, deletes, skip)
+	report.EmCurso = len(candidates) - len(upserts) - len(deletes)
+	if len(upserts)+len(deletes) == 0 {
+		return nil
+	}
+
+	n, err := a.queue.EnqueueRepair(report.Tabela, report.NodeID, upserts, deletes)
+	if err != nil {
+		return err
+	}
+	report.Reparado = true
+	report.Enfileirados = n
+	return nil
+}
+
+// withoutRows retorna as PKs que não estão em skip (chaves de rowKey)
+func withoutRows(table string, pks []map[string]interface{}, skip map[string]bool) []map[string]interface{} {
+	var kept []map[string]interface{}
+	for _, pk := range pks {
+		if !skip[rowKey(table, pk)] {
+			kept = append(kept, pk)
+		}
+	}
+	return kept
+}
+
+func (a *Admin) checkIntegrated(table string) error {
+	if table == "" {
+		return fmt.Errorf("informe table")
+	}
+	if !db.IsTableIntegrated(a.db, table) {
+		return fmt.Errorf("tabela %s não está integrada", strings.ToUpper(table))
+	}
+	return nil
+}
+
+// reconcile compara hashes por intervalo de PK e divide os intervalos divergentes
+// até ficarem pequenos o bastante para comparar linha a linha
+func (a *Admin) reconcile(ctx context.Context, caller Caller, table, nodeID string, chunk, leaf int) (*reconcileReport, error) {
+	if caller == nil {
+		return nil, fmt.Errorf("nenhum meio de falar com outros nós (relay ou webhook)")
+	}
+	cols, err := a.queue.ChecksumColumns(table)
+	if err != nil {
+		return nil, err
+	}
+	report := &reconcileReport{Tabela: table, NodeID: nodeID, Colunas: cols, Amostra: make(map[string][]interface{})}
+
+	pending, err := a.queue.SplitRange(table, cols, db.PKRange{}, chunk)
+	if err != nil {
+		return nil, err
+	}
+
+	var leaves []db.PKRange
+	for len(pending) > 0 {
+		var next []db.RangeChecksum
+		for start := 0; start < len(pending); start += reconcileBatch {
+			end := start + reconcileBatch
+			if end > len(pending) {
+				end = len(pending)
+			}
+			batch := pending[start:end]
+
+			remote, err := a.remoteRanges(ctx, caller, nodeID, table, cols, batch)
+			if err != nil {
+				return nil, err
+			}
+			for i, local := range batch {
+				report.Intervalos++
+				theirs := remote[i]
+				if local.Count == theirs.Count && local.Hash == theirs.Hash {
+					continue
+				}
+
+				switch size := max64(local.Count, theirs.Count); {
+				case size <= int64(leaf):
+					leaves = append(leaves, local.PKRange)
+				case local.Count > int64(leaf):
+					// Divide pelas PKs deste nó; o último pedaço cobre o que só existe lá
+					sub := int(local.Count) / reconcileSplit
+					if sub < leaf {
+						sub = leaf
+					}
+					parts, err := a.queue.SplitRange(table, cols, local.PKRange, sub)
+					if err != nil {
+						return nil, err
+					}
+					next = append(next, parts...)
+				case theirs.Count <= reconcileMaxLeaf:
+					leaves = append(leaves, local.PKRange)
+				default:
+					// Quase tudo só existe no destino: não dá para dividir por aqui
+					report.NaoResolvidos = append(report.NaoResolvidos, theirs)
+				}
+			}
+		}
+		pending = next
+	}
+
+	for _, r := range leaves {
+		if err := a.compareRows(ctx, caller, report, r); err != nil {
+			return nil, err
+		}
+	}
+	report.Iguais = report.Faltando+report.Sobrando+report.Diferentes == 0 && len(report.NaoResolvidos) == 0
+	return report, nil
+}
+
+func (a *Admin) remoteRanges(ctx context.Context, caller Caller, nodeID, table string, cols []string, batch []db.RangeChecksum) ([]db.RangeChecksum, error) {
+	ranges := make([]db.PKRange, len(batch))
+	for i, r := range batch {
+		ranges[i] = r.PKRange
+	}
+	args, _ := json.Marshal(map[string]interface{}{"table": table, "columns": cols, "ranges": ranges})
+
+	var remote []db.RangeChecksum
+	if err := callDecode(ctx, caller, nodeID, "checksum_ranges", args, &remote); err != nil {
+		return nil, err
+	}
+	if len(remote) != len(batch) {
+		return nil, fmt.Errorf("%s devolveu %d checksums para %d intervalos", nodeID, len(remote), len(batch))
+	}
+	return remote, nil
+}
+
+// compareRows compara linha a linha um intervalo pequeno
+func (a *Admin) compareRows(ctx context.Context, caller Caller, report *reconcileReport, r db.PKRange) error {
+	local, err := a.queue.RowChecksums(report.Tabela, report.Colunas, r, reconcileMaxLeaf)
+	if err != nil {
+		return err
+	}
+	args, _ := json.Marshal(map[string]interface{}{"table": report.Tabela, "columns": report.Colunas, "range": r, "limit": reconcileMaxLeaf})
+	var remote []db.RowChecksum
+	if err := callDecode(ctx, caller, report.NodeID, "checksum_rows", args, &remote); err != nil {
+		return err
+	}
+
+	theirs := make(map[string]db.RowChecksum, len(remote))
+	for _, row := range remote {
+		theirs[pkKey(row.PK)] = row
+	}
+	for _, row := range local {
+		key := pkKey(row.PK)
+		other, ok := theirs[key]
+		switch {
+		case !ok:
+			report.add("faltando_no_destino", row.PK)
+		case other.Hash != row.Hash:
+			report.add("diferentes", row.PK)
+		}
+		delete(theirs, key)
+	}
+	for _, row := range remote {
+		if _, onlyRemote := theirs[pkKey(row.PK)]; onlyRemote {
+			report.add("sobrando_no_destino", row.PK)
+		}
+	}
+	return nil
+}
+
+// callDecode chama o comando no nó e decodifica o resultado preservando os números
+func callDecode(ctx context.Context, caller Caller, nodeID, name string, args json.RawMessage, v interface{}) error {
+	raw, err := caller.Call(ctx, nodeID, name, args)
+	if err != nil {
+		return fmt.Errorf("%s em %s: %w", name, nodeID, err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("resposta inválida de %s para %s: %w", nodeID, name, err)
+	}
+	return nil
+}
+
+// pkKey identifica a PK independente da ordem das chaves (json.Marshal ordena)
+func pkKey(pk map[string]interface{}) string {
+	data, _ := json.Marshal(pk)
+	return string(data)
+}
+
+// rowKey é a chave da linha no formato de db.UnsentRowKeys
+func rowKey(table string, pk map[string]interface{}) string {
+	return db.RowKey("", table, pkKey(pk))
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
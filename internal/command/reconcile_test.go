@@ -0,0 +1,38 @@
+package command
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/atsinformatica/firebird-sync-agent/internal/db"
+)
+
+func TestWithoutRows(t *testing.T) {
+	pk := func(codigo interface{}) map[string]interface{} {
+		return map[string]interface{}{"CODIGO": codigo}
+	}
+	// Chaves no formato de db.UnsentRowKeys, a partir do PK_JSON gravado pela trigger
+	skip := map[string]bool{
+		db.RowKey("", "PRODUTO", `{"CODIGO": 2}`):   true,
+		db.RowKey("", "PRODUTO", `{"CODIGO": "X"}`): true,
+	}
+
+	tests := []struct {
+		name string
+		pks  []map[string]interface{}
+		want []map[string]interface{}
+	}{
+		{"sem linhas em curso", []map[string]interface{}{pk(1), pk(3)}, []map[string]interface{}{pk(1), pk(3)}},
+		{"número vindo do json", []map[string]interface{}{pk(1), pk(json.Number("2"))}, []map[string]interface{}{pk(1)}},
+		{"texto", []map[string]interface{}{pk("X"), pk("Y")}, []map[string]interface{}{pk("Y")}},
+		{"todas em curso", []map[string]interface{}{pk(2)}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := withoutRows("produto", tt.pks, skip); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("withoutRows = %v, esperado %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"fmt"
 	"os"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
@@ -13,6 +14,9 @@ type Config struct {
 		DSN     string `yaml:"dsn"`
 		AppName string `yaml:"app_name"`
 	} `yaml:"firebird"`
+	Capture struct {
+		Mode string `yaml:"mode"` // "triggers" (padrão) ou "trace"
+	} `yaml:"capture"`
 	Trace struct {
 		Enabled       bool   `yaml:"enabled"`
 		FBTraceMgrPos string `yaml:"fbtracemgr_path"`
@@ -23,20 +27,90 @@ type Config struct {
 		ListenAddr string `yaml:"listen_addr"`
 		RemoteURL  string `yaml:"remote_url"`
 		Token      string `yaml:"token"`
+		// Política para colunas recebidas que não existem na tabela local: "reject" (padrão) ou "drop"
+		UnknownColumns string `yaml:"unknown_columns"`
+		// Reenvios aceitos de um evento recusado por constraint (ex: FK cujo pai ainda não
+		// chegou) antes de retê-lo em SYNC_CONFLITOS (padrão 5). Deve ficar abaixo do
+		// integracao.retry_max dos emissores, senão o evento falha lá antes de ser retido.
+		ConstraintRetries int `yaml:"constraint_retries"`
 	} `yaml:"webhook"`
 	Relay struct {
-		Enabled bool   `yaml:"enabled"`
-		HubURL  string `yaml:"hub_url"`
-		Token   string `yaml:"token"`
+		Enabled               bool   `yaml:"enabled"`
+		HubURL                string `yaml:"hub_url"`
+		Token                 string `yaml:"token"`
+		AckTimeoutSeconds     int    `yaml:"ack_timeout_seconds"`     // Prazo para o destino confirmar um "sync"
+		QueuedTimeoutSeconds  int    `yaml:"queued_timeout_seconds"`  // Prazo quando o Hub guardou o "sync" para um nó offline
+		PingIntervalSeconds   int    `yaml:"ping_interval_seconds"`   // Intervalo entre pings ao Hub
+		PongTimeoutSeconds    int    `yaml:"pong_timeout_seconds"`    // Tempo sem receber nada do Hub até reconectar
+		WriteTimeoutSeconds   int    `yaml:"write_timeout_seconds"`   // Prazo para concluir uma escrita
+		ReconnectMaxSeconds   int    `yaml:"reconnect_max_seconds"`   // Teto do backoff de reconexão
+		CommandTimeoutSeconds int    `yaml:"command_timeout_seconds"` // Prazo para a resposta de um comando remoto
 	} `yaml:"relay"`
 	Integracao struct {
 		BatchSize            int `yaml:"batch_size"`
-		RetryMax             int `yaml:"retry_max"`
+		RetryMax             int `yaml:"retry_max"` // Reenvios após a primeira tentativa antes da falha definitiva ('F')
 		RetryIntervalSeconds int `yaml:"retry_interval_seconds"`
+		RetryMaxDelaySeconds int `yaml:"retry_max_delay_seconds"` // Teto do backoff exponencial
 		TimeoutSeconds       int `yaml:"timeout_seconds"`
+		// Tabelas cujas alterações seguidas de uma mesma linha ainda não despachadas são
+		// juntadas no estado final antes do envio ("*" = todas)
+		CoalesceTables []string `yaml:"coalesce_tables"`
 	} `yaml:"integracao"`
+	Retention struct {
+		// Dias que os eventos concluídos (enviados, aplicados, substituídos) ficam na fila;
+		// 0 = nunca apaga. Eventos recebidos apagados deixam de contar para a detecção de
+		// repetidos, então o prazo deve cobrir o maior atraso esperado de um nó.
+		DoneDays   int `yaml:"done_days"`
+		FailedDays int `yaml:"failed_days"` // Dias para as falhas definitivas ('F'); 0 = nunca apaga
+		// Dias sem alteração para esquecer a versão de uma linha (SYNC_VERSOES); 0 = nunca
+		// apaga. Evento que chegar depois disso com a versão esquecida é aplicado sem
+		// verificação de conflito, então o prazo deve cobrir o maior atraso de um nó.
+		VersionDays     int    `yaml:"version_days"`
+		AuditDays       int    `yaml:"audit_days"`       // Dias dos comandos em SYNC_AUDITORIA; 0 = nunca apaga
+		ConflictDays    int    `yaml:"conflict_days"`    // Dias dos conflitos resolvidos (SYNC_CONFLITOS); 0 = nunca apaga
+		ResyncDays      int    `yaml:"resync_days"`      // Dias das cargas concluídas (SYNC_RESYNC); 0 = nunca apaga
+		BatchSize       int    `yaml:"batch_size"`       // Linhas apagadas por transação (padrão 500)
+		IntervalMinutes int    `yaml:"interval_minutes"` // Intervalo entre expurgos (padrão 60)
+		ArchiveDir      string `yaml:"archive_dir"`      // Se informado, grava as linhas apagadas em JSONL gzip
+	} `yaml:"retention"`
+	Commands struct {
+		// Credencial do endpoint /command (cabeçalho X-Admin-Token), separada do token
+		// do webhook que os demais nós usam no /sync. Vazio = /command desabilitado.
+		Token string `yaml:"token"`
+		// Nós autorizados a executar comandos administrativos neste agente via Relay.
+		// Chamadas locais (/command vindo de 127.0.0.1 com commands.token) são sempre
+		// permitidas; chamadas HTTP de outros endereços só executam os de consulta.
+		AdminSources []string `yaml:"admin_sources"`
+	} `yaml:"commands"`
+	Conflicts struct {
+		// Política quando o evento recebido concorre com uma alteração local que o emissor
+		// ainda não tinha visto: "lww" (padrão), "priority", "merge" ou "park"
+		Policy   string            `yaml:"policy"`
+		Tables   map[string]string `yaml:"tables"`   // Política por tabela (sobrepõe policy)
+		Priority []string          `yaml:"priority"` // Nós em ordem de prioridade para "priority" (o primeiro vence)
+	} `yaml:"conflicts"`
 }
 
+// Modos de captura de alterações (CDC)
+const (
+	CaptureTriggers = "triggers"
+	CaptureTrace    = "trace"
+)
+
+// Políticas para colunas desconhecidas recebidas no /sync
+const (
+	UnknownColumnsReject = "reject"
+	UnknownColumnsDrop   = "drop"
+)
+
+// Políticas de conflito na aplicação de eventos recebidos
+const (
+	ConflictLWW      = "lww"      // Vence a alteração mais recente (DT_EVENTO)
+	ConflictPriority = "priority" // Vence o nó mais prioritário (ex: central)
+	ConflictMerge    = "merge"    // Campo a campo: mantém os campos alterados localmente
+	ConflictPark     = "park"     // Não aplica: guarda em SYNC_CONFLITOS para revisão
+)
+
 // Load lê o arquivo de configuração e retorna um objeto Config
 func Load(path string) (*Config, error) {
 	file, err := os.Open(path)
@@ -56,9 +130,139 @@ func Load(path string) (*Config, error) {
 		return nil, fmt.Errorf("firebird.dsn é obrigatório")
 	}
 
+	switch cfg.Capture.Mode {
+	case "", CaptureTriggers, CaptureTrace:
+	default:
+		return nil, fmt.Errorf("capture.mode inválido: %q (use %q ou %q)", cfg.Capture.Mode, CaptureTriggers, CaptureTrace)
+	}
+
+	switch cfg.Webhook.UnknownColumns {
+	case "", UnknownColumnsReject, UnknownColumnsDrop:
+	default:
+		return nil, fmt.Errorf("webhook.unknown_columns inválido: %q (use %q ou %q)", cfg.Webhook.UnknownColumns, UnknownColumnsReject, UnknownColumnsDrop)
+	}
+
+	if !validConflictPolicy(cfg.Conflicts.Policy) {
+		return nil, fmt.Errorf("conflicts.policy inválido: %q (use lww, priority, merge ou park)", cfg.Conflicts.Policy)
+	}
+	for table, policy := range cfg.Conflicts.Tables {
+		if policy == "" || !validConflictPolicy(policy) {
+			return nil, fmt.Errorf("conflicts.tables.%s inválido: %q (use lww, priority, merge ou park)", table, policy)
+		}
+	}
+
+	r := cfg.Retention
+	if r.DoneDays < 0 || r.FailedDays < 0 || r.VersionDays < 0 || r.AuditDays < 0 || r.ConflictDays < 0 || r.ResyncDays < 0 {
+		return nil, fmt.Errorf("os prazos em dias de retention não podem ser negativos")
+	}
+
 	return &cfg, nil
 }
 
+// CaptureMode retorna o modo de captura efetivo.
+// Configs antigas sem capture.mode usam trace apenas se trace.enabled estiver ligado.
+func (c *Config) CaptureMode() string {
+	if c.Capture.Mode != "" {
+		return c.Capture.Mode
+	}
+	if c.Trace.Enabled {
+		return CaptureTrace
+	}
+	return CaptureTriggers
+}
+
+func validConflictPolicy(policy string) bool {
+	switch policy {
+	case "", ConflictLWW, ConflictPriority, ConflictMerge, ConflictPark:
+		return true
+	}
+	return false
+}
+
+// ConflictPolicy retorna a política de conflito da tabela
+func (c *Config) ConflictPolicy(table string) string {
+	for name, policy := range c.Conflicts.Tables {
+		if strings.EqualFold(name, table) {
+			return policy
+		}
+	}
+	if c.Conflicts.Policy != "" {
+		return c.Conflicts.Policy
+	}
+	return ConflictLWW
+}
+
+// NodePriority retorna a posição do nó em conflicts.priority (menor vence);
+// nós fora da lista ficam depois de todos os listados
+func (c *Config) NodePriority(nodeID string) int {
+	for i, n := range c.Conflicts.Priority {
+		if n == nodeID {
+			return i
+		}
+	}
+	return len(c.Conflicts.Priority)
+}
+
+// CoalesceTable indica se a tabela está em integracao.coalesce_tables
+func (c *Config) CoalesceTable(table string) bool {
+	for _, t := range c.Integracao.CoalesceTables {
+		if t == "*" || strings.EqualFold(t, table) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsAdminSource indica se o nó pode executar comandos administrativos neste agente
+func (c *Config) IsAdminSource(nodeID string) bool {
+	if nodeID == c.NodeID {
+		return true
+	}
+	for _, allowed := range c.Commands.AdminSources {
+		if allowed == nodeID {
+			return true
+		}
+	}
+	return false
+}
+
+// ApplyReloadable copia de n as opções que podem mudar com o agente rodando e
+// retorna as alteradas que só valem após reiniciar o serviço. Com o agente rodando,
+// chamar apenas numa cópia (Store.Update), nunca na Config em uso.
+func (c *Config) ApplyReloadable(n *Config) []string {
+	var restart []string
+	if n.NodeID != c.NodeID {
+		restart = append(restart, "node_id")
+	}
+	if n.Firebird != c.Firebird {
+		restart = append(restart, "firebird")
+	}
+	if n.CaptureMode() != c.CaptureMode() || n.Trace != c.Trace {
+		restart = append(restart, "capture/trace")
+	}
+	if n.Webhook.ListenAddr != c.Webhook.ListenAddr {
+		restart = append(restart, "webhook.listen_addr")
+	}
+	if n.Relay.Enabled != c.Relay.Enabled || n.Relay.HubURL != c.Relay.HubURL {
+		restart = append(restart, "relay.enabled/hub_url")
+	}
+
+	c.Webhook.RemoteURL = n.Webhook.RemoteURL
+	c.Webhook.Token = n.Webhook.Token
+	c.Webhook.UnknownColumns = n.Webhook.UnknownColumns
+
+	// Token e prazos do Relay valem a partir da próxima reconexão/envio
+	enabled, hubURL := c.Relay.Enabled, c.Relay.HubURL
+	c.Relay = n.Relay
+	c.Relay.Enabled, c.Relay.HubURL = enabled, hubURL
+
+	c.Integracao = n.Integracao
+	c.Retention = n.Retention
+	c.Commands = n.Commands
+	c.Conflicts = n.Conflicts
+	return restart
+}
+
 // Save grava a configuração no caminho especificado
 func (c *Config) Save(path string) error {
 	data, err := yaml.Marshal(c)
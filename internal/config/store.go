@@ -0,0 +1,41 @@
+package config
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Store guarda a configuração em uso pelo agente. Poller, servidor HTTP, Relay e
+// expurgo leem com Get a cada uso; reload_config e rotate_token publicam uma cópia
+// alterada com Update. Uma Config publicada nunca é modificada, então quem a leu
+// continua com uma versão consistente.
+type Store struct {
+	mu  sync.Mutex // Serializa as atualizações
+	cur atomic.Pointer[Config]
+}
+
+func NewStore(cfg *Config) *Store {
+	s := &Store{}
+	s.cur.Store(cfg)
+	return s
+}
+
+// Get retorna a configuração atual (somente leitura)
+func (s *Store) Get() *Config {
+	return s.cur.Load()
+}
+
+// Update aplica change numa cópia da configuração atual e a publica se não houver
+// erro. Slices e mapas são compartilhados com a versão anterior: change deve
+// substituí-los, nunca alterá-los no lugar.
+func (s *Store) Update(change func(c *Config) error) (*Config, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	next := *s.cur.Load()
+	if err := change(&next); err != nil {
+		return nil, err
+	}
+	s.cur.Store(&next)
+	return &next, nil
+}
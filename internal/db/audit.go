@@ -0,0 +1,19 @@
+package db
+
+import "database/sql"
+
+// Status gravados em SYNC_AUDITORIA
+const (
+	AuditOK     = "S" // Executado com sucesso
+	AuditError  = "E" // Executado com erro
+	AuditDenied = "N" // Recusado: origem não autorizada
+)
+
+// InsertAudit registra a execução (ou recusa) de um comando administrativo
+func InsertAudit(db *sql.DB, origem, comando, args, status, resultado string) error {
+	_, err := db.Exec(`
+		INSERT INTO SYNC_AUDITORIA (ORIGEM, COMANDO, ARGS, STATUS, RESULTADO, DT_EVENTO)
+		VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+	`, origem, comando, args, status, resultado)
+	return err
+}
@@ -0,0 +1,80 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/atsinformatica/firebird-sync-agent/internal/models"
+)
+
+// ReadBlobs lê as colunas BLOB da linha identificada pela PK e devolve os valores
+// já codificados para o payload (models.BlobValue, ou nil para BLOB NULL).
+// Os BLOBs não passam pela trigger: são lidos da linha de origem no momento do envio.
+// Se a linha não existir mais, devolve um mapa vazio (um DELETE posterior virá na fila).
+func (q *QueueManager) ReadBlobs(table string, pk map[string]interface{}) (map[string]interface{}, error) {
+	cols, err := q.schema.Columns(table)
+	if err != nil {
+		return nil, err
+	}
+
+	var blobCols []ColumnInfo
+	for _, c := range cols {
+		if c.IsBlob() && !c.Computed {
+			blobCols = append(blobCols, c)
+		}
+	}
+	result := make(map[string]interface{})
+	if len(blobCols) == 0 || len(pk) == 0 {
+		return result, nil
+	}
+
+	names := make([]string, len(blobCols))
+	for i, c := range blobCols {
+		names[i] = QuoteIdent(c.Name)
+	}
+	whereClauses := []string{}
+	args := []interface{}{}
+	for col, v := range pk {
+		whereClauses = append(whereClauses, fmt.Sprintf("%s = ?", QuoteIdent(col)))
+		args = append(args, v)
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s", strings.Join(names, ", "), QuoteIdent(strings.ToUpper(table)), strings.Join(whereClauses, " AND "))
+	rows, err := q.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao ler BLOBs de %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return result, rows.Err()
+	}
+
+	values := make([]interface{}, len(blobCols))
+	ptrs := make([]interface{}, len(blobCols))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	if err := rows.Scan(ptrs...); err != nil {
+		return nil, err
+	}
+
+	for i, c := range blobCols {
+		result[c.Name] = encodeBlob(c, values[i])
+	}
+	return result, nil
+}
+
+// encodeBlob converte o valor lido do driver para o formato trafegado no payload
+func encodeBlob(c ColumnInfo, v interface{}) interface{} {
+	switch b := v.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return models.NewBlobValue(c.SubType, b)
+	case string:
+		return models.NewBlobValue(c.SubType, []byte(b))
+	default:
+		return models.NewBlobValue(c.SubType, []byte(fmt.Sprint(b)))
+	}
+}
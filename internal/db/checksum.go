@@ -0,0 +1,423 @@
+package db
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// PKRange é um intervalo de linhas na ordem da PK: From (exclusivo) até To (inclusivo).
+// Limite nil significa início/fim da tabela.
+type PKRange struct {
+	From map[string]interface{} `json:"from,omitempty"`
+	To   map[string]interface{} `json:"to,omitempty"`
+}
+
+// RangeChecksum resume as linhas de um intervalo: quantidade e hash (XOR dos hashes
+// das linhas, independente da ordem em que foram lidas)
+type RangeChecksum struct {
+	PKRange
+	Count int64  `json:"count"`
+	Hash  string `json:"hash"`
+}
+
+// RowChecksum é o hash de uma linha, identificada pela PK
+type RowChecksum struct {
+	PK   map[string]interface{} `json:"pk"`
+	Hash string                 `json:"hash"`
+}
+
+// tableMeta reúne a PK e as colunas de uma tabela para leituras por intervalo
+type tableMeta struct {
+	name    string
+	pkCols  []string
+	cols    map[string]ColumnInfo
+	payload []ColumnInfo // Colunas que vão no payload: sem BLOBs e sem COMPUTED BY
+}
+
+func (q *QueueManager) tableMeta(table string) (*tableMeta, error) {
+	table = strings.ToUpper(strings.TrimSpace(table))
+	pkCols, err := GetPKColumns(q.db, table)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao buscar PK de %s: %w", table, err)
+	}
+	if len(pkCols) == 0 {
+		return nil, fmt.Errorf("tabela %s inexistente ou sem PK", table)
+	}
+	colInfos, err := q.schema.Columns(table)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &tableMeta{name: table, pkCols: pkCols, cols: make(map[string]ColumnInfo)}
+	for _, c := range colInfos {
+		m.cols[c.Name] = c
+		if !c.Computed && !c.IsBlob() {
+			m.payload = append(m.payload, c)
+		}
+	}
+	return m, nil
+}
+
+// pkOf extrai da linha os valores da PK
+func (m *tableMeta) pkOf(row map[string]interface{}) map[string]interface{} {
+	pk := make(map[string]interface{}, len(m.pkCols))
+	for _, col := range m.pkCols {
+		pk[col] = row[col]
+	}
+	return pk
+}
+
+// coercePK converte os valores de PK vindos do JSON para os tipos das colunas
+func (m *tableMeta) coercePK(pk map[string]interface{}) ([]interface{}, error) {
+	values := make([]interface{}, len(m.pkCols))
+	for i, col := range m.pkCols {
+		v, ok := lookupKey(pk, col)
+		if !ok {
+			return nil, fmt.Errorf("limite sem a coluna de PK %s", col)
+		}
+		coerced, err := m.cols[col].Coerce(v)
+		if err != nil {
+			return nil, fmt.Errorf("PK %s: %w", col, err)
+		}
+		values[i] = coerced
+	}
+	return values, nil
+}
+
+// pkBound monta a comparação da PK composta com um limite, na ordem do ORDER BY.
+// Com op ">": (A > ?) OR (A = ? AND B > ?); inclusive acrescenta a igualdade completa.
+func pkBound(pkCols []string, values []interface{}, op string, inclusive bool) (string, []interface{}) {
+	var ors []string
+	var args []interface{}
+	for i := range pkCols {
+		var ands []string
+		for j := 0; j < i; j++ {
+			ands = append(ands, QuoteIdent(pkCols[j])+" = ?")
+			args = append(args, values[j])
+		}
+		ands = append(ands, QuoteIdent(pkCols[i])+" "+op+" ?")
+		args = append(args, values[i])
+		ors = append(ors, "("+strings.Join(ands, " AND ")+")")
+	}
+	if inclusive {
+		var ands []string
+		for i, col := range pkCols {
+			ands = append(ands, QuoteIdent(col)+" = ?")
+			args = append(args, values[i])
+		}
+		ors = append(ors, "("+strings.Join(ands, " AND ")+")")
+	}
+	return "(" + strings.Join(ors, " OR ") + ")", args
+}
+
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// scanRange lê as colunas cols (mais a PK) das linhas do intervalo, na ordem da PK,
+// com os valores no formato do payload. limit <= 0 lê o intervalo inteiro.
+func (q *QueueManager) scanRange(qr queryer, m *tableMeta, cols []ColumnInfo, r PKRange, limit int, fn func(row map[string]interface{}) error) error {
+	var where []string
+	var args []interface{}
+	if r.From != nil {
+		values, err := m.coercePK(r.From)
+		if err != nil {
+			return err
+		}
+		cond, condArgs := pkBound(m.pkCols, values, ">", false)
+		where = append(where, cond)
+		args = append(args, condArgs...)
+	}
+	if r.To != nil {
+		values, err := m.coercePK(r.To)
+		if err != nil {
+			return err
+		}
+		cond, condArgs := pkBound(m.pkCols, values, "<", true)
+		where = append(where, cond)
+		args = append(args, condArgs...)
+	}
+	return q.scanWhere(qr, m, cols, where, args, limit, fn)
+}
+
+// scanWhere executa o SELECT das colunas (mais a PK) com as condições dadas, na ordem da PK
+func (q *QueueManager) scanWhere(qr queryer, m *tableMeta, cols []ColumnInfo, where []string, args []interface{}, limit int, fn func(row map[string]interface{}) error) error {
+	selected := make([]ColumnInfo, 0, len(cols)+len(m.pkCols))
+	seen := make(map[string]bool)
+	for _, col := range m.pkCols {
+		selected = append(selected, m.cols[col])
+		seen[col] = true
+	}
+	for _, c := range cols {
+		if !seen[c.Name] {
+			selected = append(selected, c)
+			seen[c.Name] = true
+		}
+	}
+	names := make([]string, len(selected))
+	for i, c := range selected {
+		names[i] = QuoteIdent(c.Name)
+	}
+
+	query := "SELECT "
+	if limit > 0 {
+		query += fmt.Sprintf("FIRST %d ", limit)
+	}
+	query += strings.Join(names, ", ") + " FROM " + QuoteIdent(m.name)
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	orderBy := make([]string, len(m.pkCols))
+	for i, col := range m.pkCols {
+		orderBy[i] = QuoteIdent(col)
+	}
+	query += " ORDER BY " + strings.Join(orderBy, ", ")
+
+	rows, err := qr.Query(query, args...)
+	if err != nil {
+		return fmt.Errorf("erro ao ler %s: %w", m.name, err)
+	}
+	defer rows.Close()
+
+	values := make([]interface{}, len(selected))
+	ptrs := make([]interface{}, len(selected))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return err
+		}
+		row := make(map[string]interface{}, len(selected))
+		for i, c := range selected {
+			row[c.Name] = payloadValue(c, values[i])
+		}
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// ChecksumColumns retorna as colunas comparadas na reconciliação: as do payload,
+// sem os campos técnicos (que divergem entre nós por natureza)
+func (q *QueueManager) ChecksumColumns(table string) ([]string, error) {
+	m, err := q.tableMeta(table)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, c := range m.payload {
+		if !TechnicalFields[c.Name] {
+			names = append(names, c.Name)
+		}
+	}
+	return names, nil
+}
+
+// checksumMeta valida as colunas pedidas (as do nó que conduz a reconciliação)
+func (q *QueueManager) checksumMeta(table string, columns []string) (*tableMeta, []ColumnInfo, error) {
+	m, err := q.tableMeta(table)
+	if err != nil {
+		return nil, nil, err
+	}
+	cols := make([]ColumnInfo, 0, len(columns))
+	for _, name := range columns {
+		c, ok := m.cols[strings.ToUpper(name)]
+		if !ok || c.Computed || c.IsBlob() {
+			return nil, nil, fmt.Errorf("coluna %s.%s inexistente ou não comparável neste nó", m.name, name)
+		}
+		cols = append(cols, c)
+	}
+	return m, cols, nil
+}
+
+// rowHash é o SHA-256 da linha serializada (json.Marshal ordena as chaves)
+func rowHash(row map[string]interface{}) [sha256.Size]byte {
+	data, _ := json.Marshal(row)
+	return sha256.Sum256(data)
+}
+
+func xorInto(dst *[sha256.Size]byte, h [sha256.Size]byte) {
+	for i := range dst {
+		dst[i] ^= h[i]
+	}
+}
+
+// ChecksumRanges calcula quantidade e hash de cada intervalo
+func (q *QueueManager) ChecksumRanges(table string, columns []string, ranges []PKRange) ([]RangeChecksum, error) {
+	m, cols, err := q.checksumMeta(table, columns)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]RangeChecksum, len(ranges))
+	for i, r := range ranges {
+		var acc [sha256.Size]byte
+		var count int64
+		err := q.scanRange(q.db, m, cols, r, 0, func(row map[string]interface{}) error {
+			xorInto(&acc, rowHash(row))
+			count++
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		result[i] = RangeChecksum{PKRange: r, Count: count, Hash: hex.EncodeToString(acc[:])}
+	}
+	return result, nil
+}
+
+// SplitRange divide o intervalo em pedaços de até chunk linhas (pelas PKs locais),
+// já com o checksum local de cada pedaço. O último pedaço vai até o fim de r,
+// para cobrir linhas que só existam no outro nó.
+func (q *QueueManager) SplitRange(table string, columns []string, r PKRange, chunk int) ([]RangeChecksum, error) {
+	m, cols, err := q.checksumMeta(table, columns)
+	if err != nil {
+		return nil, err
+	}
+
+	s := newRangeSplitter(m, r, chunk)
+	if err := q.scanRange(q.db, m, cols, r, 0, s.add); err != nil {
+		return nil, err
+	}
+	return s.finish(), nil
+}
+
+// rangeSplitter acumula as linhas lidas em ordem da PK e fecha um pedaço a cada
+// chunk linhas
+type rangeSplitter struct {
+	m       *tableMeta
+	to      map[string]interface{}
+	chunk   int
+	result  []RangeChecksum
+	current RangeChecksum
+	acc     [sha256.Size]byte
+}
+
+func newRangeSplitter(m *tableMeta, r PKRange, chunk int) *rangeSplitter {
+	return &rangeSplitter{m: m, to: r.To, chunk: chunk, current: RangeChecksum{PKRange: PKRange{From: r.From}}}
+}
+
+func (s *rangeSplitter) add(row map[string]interface{}) error {
+	xorInto(&s.acc, rowHash(row))
+	s.current.Count++
+	if s.current.Count == int64(s.chunk) {
+		s.current.To = s.m.pkOf(row)
+		s.current.Hash = hex.EncodeToString(s.acc[:])
+		s.result = append(s.result, s.current)
+		s.current = RangeChecksum{PKRange: PKRange{From: s.current.To}}
+		s.acc = [sha256.Size]byte{}
+	}
+	return nil
+}
+
+// finish fecha o último pedaço até o fim do intervalo original (mesmo vazio)
+func (s *rangeSplitter) finish() []RangeChecksum {
+	s.current.To = s.to
+	s.current.Hash = hex.EncodeToString(s.acc[:])
+	return append(s.result, s.current)
+}
+
+// RowChecksums retorna o hash de cada linha do intervalo; falha se passar de limit
+func (q *QueueManager) RowChecksums(table string, columns []string, r PKRange, limit int) ([]RowChecksum, error) {
+	m, cols, err := q.checksumMeta(table, columns)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []RowChecksum{}
+	err = q.scanRange(q.db, m, cols, r, limit+1, func(row map[string]interface{}) error {
+		h := rowHash(row)
+		result = append(result, RowChecksum{PK: m.pkOf(row), Hash: hex.EncodeToString(h[:])})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(result) > limit {
+		return nil, fmt.Errorf("intervalo de %s com mais de %d linhas", m.name, limit)
+	}
+	return result, nil
+}
+
+// UnsentRowKeys retorna, na chave de RowKey (sem nó), as linhas da tabela com eventos
+// ainda em curso: pendentes, retidos em conflito ou com algum destino sem confirmação
+// de entrega. O reparo deixa essas linhas de fora, pois o evento levará o estado delas.
+func (q *QueueManager) UnsentRowKeys(table string) (map[string]bool, error) {
+	table = strings.ToUpper(strings.TrimSpace(table))
+	rows, err := q.db.Query(`
+		SELECT f.PK_JSON FROM FILA_INTEGRACAO f
+		WHERE f.TABELA = ?
+		  AND (f.STATUS IN ('P', 'R', 'C')
+		       OR EXISTS (SELECT 1 FROM FILA_DESTINOS d WHERE d.FILA_ID = f.ID AND d.STATUS <> 'E'))
+	`, table)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao consultar eventos em curso de %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	keys := make(map[string]bool)
+	for rows.Next() {
+		var pkJSON string
+		if err := rows.Scan(&pkJSON); err != nil {
+			return nil, err
+		}
+		keys[RowKey("", table, pkJSON)] = true
+	}
+	return keys, rows.Err()
+}
+
+// EnqueueRepair enfileira para o nó o estado local das linhas: upsert das que existem
+// aqui e delete das que só existem lá. Retorna quantos eventos foram gerados.
+func (q *QueueManager) EnqueueRepair(table, nodeID string, upserts, deletes []map[string]interface{}) (int, error) {
+	tx, err := q.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("erro ao iniciar transação: %w", err)
+	}
+	defer tx.Rollback()
+
+	count, err := q.EnqueueRepairTx(tx, table, nodeID, upserts, deletes)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("erro ao comitar reparo: %w", err)
+	}
+	return count, nil
+}
+
+// EnqueueRepairTx é EnqueueRepair dentro de uma transação do chamador
+func (q *QueueManager) EnqueueRepairTx(tx *sql.Tx, table, nodeID string, upserts, deletes []map[string]interface{}) (int, error) {
+	m, err := q.tableMeta(table)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, pk := range upserts {
+		// Relê a linha atual dentro da transação
+		row, err := q.readPayloadRow(tx, m, pk)
+		if err != nil {
+			return 0, err
+		}
+		if row == nil {
+			continue // Apagada depois da comparação: o DELETE virá pela trigger
+		}
+		if err := insertSyntheticEvent(tx, OrigemReconcilia, m.name, "U", nodeID, m.pkOf(row), row); err != nil {
+			return 0, err
+		}
+		count++
+	}
+	for _, pk := range deletes {
+		if err := insertSyntheticEvent(tx, OrigemReconcilia, m.name, "D", nodeID, pk, nil); err != nil {
+			return 0, err
+		}
+		count++
+	}
+	return count, nil
+}
@@ -0,0 +1,153 @@
+package db
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"reflect"
+	"testing"
+)
+
+func TestPKBound(t *testing.T) {
+	tests := []struct {
+		name      string
+		pkCols    []string
+		values    []interface{}
+		op        string
+		inclusive bool
+		want      string
+		wantArgs  []interface{}
+	}{
+		{
+			name:     "pk simples",
+			pkCols:   []string{"CODIGO"},
+			values:   []interface{}{int64(10)},
+			op:       ">",
+			want:     `(("CODIGO" > ?))`,
+			wantArgs: []interface{}{int64(10)},
+		},
+		{
+			name:      "pk simples inclusiva",
+			pkCols:    []string{"CODIGO"},
+			values:    []interface{}{int64(10)},
+			op:        "<",
+			inclusive: true,
+			want:      `(("CODIGO" < ?) OR ("CODIGO" = ?))`,
+			wantArgs:  []interface{}{int64(10), int64(10)},
+		},
+		{
+			name:     "pk composta",
+			pkCols:   []string{"PEDIDO", "ITEM"},
+			values:   []interface{}{int64(5), "B"},
+			op:       ">",
+			want:     `(("PEDIDO" > ?) OR ("PEDIDO" = ? AND "ITEM" > ?))`,
+			wantArgs: []interface{}{int64(5), int64(5), "B"},
+		},
+		{
+			name:      "pk composta inclusiva",
+			pkCols:    []string{"A", "B", "C"},
+			values:    []interface{}{1, 2, 3},
+			op:        "<",
+			inclusive: true,
+			want:      `(("A" < ?) OR ("A" = ? AND "B" < ?) OR ("A" = ? AND "B" = ? AND "C" < ?) OR ("A" = ? AND "B" = ? AND "C" = ?))`,
+			wantArgs:  []interface{}{1, 1, 2, 1, 2, 3, 1, 2, 3},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, args := pkBound(tt.pkCols, tt.values, tt.op, tt.inclusive)
+			if got != tt.want {
+				t.Errorf("pkBound = %s\n esperado %s", got, tt.want)
+			}
+			if !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("argumentos = %v, esperado %v", args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+// rowsHash é o checksum esperado de um intervalo com as linhas dadas
+func rowsHash(rows ...map[string]interface{}) string {
+	var acc [sha256.Size]byte
+	for _, row := range rows {
+		xorInto(&acc, rowHash(row))
+	}
+	return hex.EncodeToString(acc[:])
+}
+
+func TestRangeSplitter(t *testing.T) {
+	m := &tableMeta{name: "PRODUTO", pkCols: []string{"CODIGO"}}
+	row := func(codigo int) map[string]interface{} {
+		return map[string]interface{}{"CODIGO": codigo, "NOME": "P"}
+	}
+	pk := func(codigo int) map[string]interface{} {
+		return map[string]interface{}{"CODIGO": codigo}
+	}
+
+	tests := []struct {
+		name  string
+		r     PKRange
+		chunk int
+		rows  []map[string]interface{}
+		want  []RangeChecksum
+	}{
+		{
+			name:  "tabela vazia gera um intervalo até o fim",
+			chunk: 2,
+			want:  []RangeChecksum{{Hash: rowsHash()}},
+		},
+		{
+			name:  "último pedaço cobre o resto da tabela",
+			chunk: 2,
+			rows:  []map[string]interface{}{row(1), row(2), row(3)},
+			want: []RangeChecksum{
+				{PKRange: PKRange{To: pk(2)}, Count: 2, Hash: rowsHash(row(1), row(2))},
+				{PKRange: PKRange{From: pk(2)}, Count: 1, Hash: rowsHash(row(3))},
+			},
+		},
+		{
+			name:  "pedaço exato deixa um intervalo final vazio",
+			chunk: 2,
+			rows:  []map[string]interface{}{row(1), row(2)},
+			want: []RangeChecksum{
+				{PKRange: PKRange{To: pk(2)}, Count: 2, Hash: rowsHash(row(1), row(2))},
+				{PKRange: PKRange{From: pk(2)}, Hash: rowsHash()},
+			},
+		},
+		{
+			name:  "subintervalo mantém os limites originais",
+			r:     PKRange{From: pk(10), To: pk(20)},
+			chunk: 1,
+			rows:  []map[string]interface{}{row(11), row(15)},
+			want: []RangeChecksum{
+				{PKRange: PKRange{From: pk(10), To: pk(11)}, Count: 1, Hash: rowsHash(row(11))},
+				{PKRange: PKRange{From: pk(11), To: pk(15)}, Count: 1, Hash: rowsHash(row(15))},
+				{PKRange: PKRange{From: pk(15), To: pk(20)}, Hash: rowsHash()},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newRangeSplitter(m, tt.r, tt.chunk)
+			for _, row := range tt.rows {
+				s.add(row)
+			}
+			if got := s.finish(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("intervalos\n got  %#v\n want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRowsHashOrder(t *testing.T) {
+	a := map[string]interface{}{"CODIGO": 1, "NOME": "A"}
+	b := map[string]interface{}{"NOME": "B", "CODIGO": 2}
+	if rowsHash(a, b) != rowsHash(b, a) {
+		t.Error("o checksum do intervalo não pode depender da ordem de leitura das linhas")
+	}
+	if rowsHash(a) == rowsHash(map[string]interface{}{"CODIGO": 1, "NOME": "a"}) {
+		t.Error("linhas diferentes com o mesmo hash")
+	}
+	if rowsHash(a, a) != rowsHash() {
+		t.Error("XOR de linhas iguais deveria se anular")
+	}
+}
@@ -0,0 +1,57 @@
+package db
+
+import "fmt"
+
+// StatusSuperseded marca em FILA_INTEGRACAO um evento absorvido por outro posterior
+// da mesma linha antes do despacho: não é enviado, fica na fila só para auditoria
+const StatusSuperseded = "S"
+
+// SupersedeEvents grava o resultado da coalescência de uma linha: o evento mantido
+// passa a ter a operação informada e os absorvidos ficam com status 'S' apontando
+// para ele. keep nil = a linha nasceu e morreu antes do envio e nada segue.
+func (q *QueueManager) SupersedeEvents(keep *FilaItem, operacao string, superseded []*FilaItem) error {
+	tx, err := q.db.Begin()
+	if err != nil {
+		return fmt.Errorf("erro ao iniciar transação: %w", err)
+	}
+	defer tx.Rollback()
+
+	msg := "Anulado: inserido e excluído antes do envio"
+	if keep != nil {
+		msg = fmt.Sprintf("Substituído pelo evento %d", keep.ID)
+		if operacao != keep.Operacao {
+			if _, err := tx.Exec("UPDATE FILA_INTEGRACAO SET OPERACAO = ? WHERE ID = ?", operacao, keep.ID); err != nil {
+				return fmt.Errorf("erro ao atualizar operação do evento %d: %w", keep.ID, err)
+			}
+		}
+	}
+
+	query := `
+		UPDATE FILA_INTEGRACAO
+		SET STATUS = ?, ERRO_MSG = ?, DT_ULT_ENVIO = CURRENT_TIMESTAMP
+		WHERE ID = ? AND STATUS IN ('P', 'R')
+	`
+	for _, item := range superseded {
+		if _, err := tx.Exec(query, StatusSuperseded, msg, item.ID); err != nil {
+			return fmt.Errorf("erro ao marcar evento %d como substituído: %w", item.ID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("erro ao gravar coalescência: %w", err)
+	}
+	if keep != nil {
+		keep.Operacao = operacao
+	}
+	return nil
+}
+
+// TransactionSize conta os eventos capturados numa transação de origem
+func (q *QueueManager) TransactionSize(transacaoID int64) (int, error) {
+	var n int
+	err := q.db.QueryRow("SELECT COUNT(*) FROM FILA_INTEGRACAO WHERE TRANSACAO_ID = ?", transacaoID).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("erro ao consultar transação %d: %w", transacaoID, err)
+	}
+	return n, nil
+}
@@ -0,0 +1,234 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/atsinformatica/firebird-sync-agent/internal/models"
+)
+
+// Formatos aceitos para DATE/TIME/TIMESTAMP vindos no payload
+var (
+	timestampLayouts = []string{
+		time.RFC3339Nano,
+		"2006-01-02T15:04:05.999999999",
+		"2006-01-02 15:04:05.999999999",
+		"2006-01-02T15:04",
+		"2006-01-02 15:04",
+		"2006-01-02",
+		"02.01.2006 15:04:05",
+		"02.01.2006",
+	}
+	timeLayouts = []string{
+		"15:04:05.999999999",
+		"15:04",
+	}
+)
+
+// Coerce converte um valor decodificado do JSON para o tipo Go adequado à coluna,
+// pronto para ser usado como parâmetro no driver do Firebird
+func (c ColumnInfo) Coerce(v interface{}) (interface{}, error) {
+	if v == nil {
+		if c.NotNull {
+			return nil, fmt.Errorf("valor NULL em coluna NOT NULL")
+		}
+		return nil, nil
+	}
+
+	switch {
+	case c.IsBlob():
+		return c.coerceBlob(v)
+	case c.IsText():
+		return c.coerceText(v)
+	}
+
+	// Para tipos não-texto, string vazia equivale a NULL (payloads antigos)
+	if s, ok := v.(string); ok && strings.TrimSpace(s) == "" {
+		if c.NotNull {
+			return nil, fmt.Errorf("valor vazio em coluna NOT NULL")
+		}
+		return nil, nil
+	}
+
+	switch c.FieldType {
+	case FieldSmallint, FieldInteger, FieldBigint:
+		if c.Scale == 0 {
+			return c.coerceInteger(v)
+		}
+		return coerceDecimal(v)
+	case FieldFloat, FieldDouble:
+		return coerceFloat(v)
+	case FieldDate, FieldTimestamp:
+		return coerceTime(v, timestampLayouts)
+	case FieldTime:
+		return coerceTime(v, append(timeLayouts, timestampLayouts...))
+	case FieldBoolean:
+		return coerceBool(v)
+	}
+	return v, nil
+}
+
+func (c ColumnInfo) coerceText(v interface{}) (interface{}, error) {
+	var s string
+	switch val := v.(type) {
+	case string:
+		s = val
+	case json.Number:
+		s = val.String()
+	case float64:
+		s = strconv.FormatFloat(val, 'f', -1, 64)
+	case bool:
+		s = strconv.FormatBool(val)
+	default:
+		return nil, fmt.Errorf("tipo %T não suportado para texto", v)
+	}
+
+	check := s
+	if c.FieldType == FieldChar {
+		check = strings.TrimRight(s, " ")
+	}
+	if c.Length > 0 && utf8.RuneCountInString(check) > c.Length {
+		return nil, fmt.Errorf("texto com %d caracteres excede o tamanho %d", utf8.RuneCountInString(check), c.Length)
+	}
+	return s, nil
+}
+
+func (c ColumnInfo) coerceBlob(v interface{}) (interface{}, error) {
+	data, subType, isBlob, err := models.DecodeBlob(v)
+	if err != nil {
+		return nil, err
+	}
+	if isBlob {
+		if subType == 1 || c.IsTextBlob() {
+			return string(data), nil
+		}
+		return data, nil
+	}
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	return nil, fmt.Errorf("tipo %T não suportado para BLOB", v)
+}
+
+func (c ColumnInfo) coerceInteger(v interface{}) (interface{}, error) {
+	var n int64
+	switch val := v.(type) {
+	case json.Number:
+		i, err := val.Int64()
+		if err != nil {
+			f, ferr := val.Float64()
+			if ferr != nil || f != math.Trunc(f) {
+				return nil, fmt.Errorf("%q não é um inteiro", val.String())
+			}
+			i = int64(f)
+		}
+		n = i
+	case float64:
+		if val != math.Trunc(val) {
+			return nil, fmt.Errorf("%v não é um inteiro", val)
+		}
+		n = int64(val)
+	case string:
+		i, err := strconv.ParseInt(strings.TrimSpace(val), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q não é um inteiro", val)
+		}
+		n = i
+	case bool:
+		if val {
+			n = 1
+		}
+	default:
+		return nil, fmt.Errorf("tipo %T não suportado para inteiro", v)
+	}
+
+	switch c.FieldType {
+	case FieldSmallint:
+		if n < math.MinInt16 || n > math.MaxInt16 {
+			return nil, fmt.Errorf("%d fora da faixa de SMALLINT", n)
+		}
+	case FieldInteger:
+		if n < math.MinInt32 || n > math.MaxInt32 {
+			return nil, fmt.Errorf("%d fora da faixa de INTEGER", n)
+		}
+	}
+	return n, nil
+}
+
+// coerceDecimal mantém NUMERIC/DECIMAL como texto para não perder precisão em float64
+func coerceDecimal(v interface{}) (interface{}, error) {
+	var s string
+	switch val := v.(type) {
+	case json.Number:
+		s = val.String()
+	case float64:
+		s = strconv.FormatFloat(val, 'f', -1, 64)
+	case string:
+		s = strings.TrimSpace(strings.Replace(val, ",", ".", 1))
+	default:
+		return nil, fmt.Errorf("tipo %T não suportado para NUMERIC", v)
+	}
+	if _, err := strconv.ParseFloat(s, 64); err != nil {
+		return nil, fmt.Errorf("%q não é um número", s)
+	}
+	return s, nil
+}
+
+func coerceFloat(v interface{}) (interface{}, error) {
+	switch val := v.(type) {
+	case json.Number:
+		f, err := val.Float64()
+		if err != nil {
+			return nil, fmt.Errorf("%q não é um número", val.String())
+		}
+		return f, nil
+	case float64:
+		return val, nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(strings.Replace(val, ",", ".", 1)), 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q não é um número", val)
+		}
+		return f, nil
+	}
+	return nil, fmt.Errorf("tipo %T não suportado para ponto flutuante", v)
+}
+
+func coerceTime(v interface{}, layouts []string) (interface{}, error) {
+	s, ok := v.(string)
+	if !ok {
+		return nil, fmt.Errorf("tipo %T não suportado para data/hora", v)
+	}
+	s = strings.TrimSpace(s)
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return nil, fmt.Errorf("%q não é uma data/hora reconhecida", s)
+}
+
+func coerceBool(v interface{}) (interface{}, error) {
+	switch val := v.(type) {
+	case bool:
+		return val, nil
+	case json.Number:
+		return val.String() != "0", nil
+	case float64:
+		return val != 0, nil
+	case string:
+		switch strings.ToUpper(strings.TrimSpace(val)) {
+		case "TRUE", "T", "S", "Y", "1":
+			return true, nil
+		case "FALSE", "F", "N", "0":
+			return false, nil
+		}
+		return nil, fmt.Errorf("%q não é um booleano", val)
+	}
+	return nil, fmt.Errorf("tipo %T não suportado para booleano", v)
+}
@@ -0,0 +1,174 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Situação de um conflito em SYNC_CONFLITOS
+const (
+	ConflictPending  = "P" // Aguardando revisão
+	ConflictResolved = "R" // Resolvido (ver RESOLUCAO)
+)
+
+// Resoluções de um conflito
+const (
+	ResolutionIncoming = "recebido" // Aplicado o evento recebido
+	ResolutionLocal    = "local"    // Mantida a linha local (reenviada à origem)
+	ResolutionEdited   = "editado"  // Aplicado o evento com os dados corrigidos
+)
+
+// Conflict é um evento recebido retido em SYNC_CONFLITOS
+type Conflict struct {
+	EventID  string
+	Tabela   string
+	Operacao string
+	PK       map[string]interface{}
+	Origem   string
+	Evento   interface{} // Evento recebido completo (models.SyncPayload)
+	Local    interface{} // Linha local no momento do conflito (nil se não existir)
+	Motivo   string
+	Erro     string // Erro ao aplicar o evento (vazio para conflito de versão)
+}
+
+// ConflictRecord é um conflito como consultado para revisão
+type ConflictRecord struct {
+	ID           int64           `json:"id"`
+	DTConflito   time.Time       `json:"dt_conflito"`
+	EventID      string          `json:"event_id"`
+	Tabela       string          `json:"tabela"`
+	Operacao     string          `json:"operacao"`
+	PK           json.RawMessage `json:"pk"`
+	Origem       string          `json:"origem"`
+	Evento       json.RawMessage `json:"evento"`
+	Local        json.RawMessage `json:"local"` // null se a linha não existia
+	Motivo       string          `json:"motivo"`
+	Erro         string          `json:"erro,omitempty"`
+	Status       string          `json:"status"`
+	Resolucao    string          `json:"resolucao,omitempty"`
+	ResolvidoPor string          `json:"resolvido_por,omitempty"`
+	DTResolucao  *time.Time      `json:"dt_resolucao,omitempty"`
+}
+
+// InsertConflictTx guarda o evento para revisão manual
+func InsertConflictTx(tx *sql.Tx, c Conflict) error {
+	pkJSON, _ := json.Marshal(c.PK)
+	eventoJSON, _ := json.Marshal(c.Evento)
+	var localJSON interface{}
+	if c.Local != nil {
+		data, _ := json.Marshal(c.Local)
+		localJSON = string(data)
+	}
+
+	_, err := tx.Exec(`
+		INSERT INTO SYNC_CONFLITOS (EVENT_ID, TABELA, OPERACAO, PK_JSON, ORIGEM, EVENTO_JSON, LOCAL_JSON, MOTIVO, ERRO_MSG, STATUS, DT_CONFLITO)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, 'P', CURRENT_TIMESTAMP)
+	`, c.EventID, strings.ToUpper(c.Tabela), c.Operacao, string(pkJSON), c.Origem, string(eventoJSON), localJSON, c.Motivo, nullIfEmpty(c.Erro))
+	if err != nil {
+		return fmt.Errorf("erro ao registrar conflito: %w", err)
+	}
+	return nil
+}
+
+const conflictColumns = `ID, DT_CONFLITO, EVENT_ID, TRIM(TABELA), OPERACAO, PK_JSON, TRIM(ORIGEM), EVENTO_JSON,
+	LOCAL_JSON, MOTIVO, ERRO_MSG, STATUS, RESOLUCAO, RESOLVIDO_POR, DT_RESOLUCAO`
+
+// GetConflicts lista os conflitos, dos mais recentes para os mais antigos.
+// status e tabela vazios não filtram.
+func (q *QueueManager) GetConflicts(status, tabela string, limit int) ([]*ConflictRecord, error) {
+	where := []string{"1 = 1"}
+	args := []interface{}{}
+	if status != "" {
+		where = append(where, "STATUS = ?")
+		args = append(args, status)
+	}
+	if tabela != "" {
+		where = append(where, "TABELA = ?")
+		args = append(args, strings.ToUpper(tabela))
+	}
+	rows, err := q.db.Query(fmt.Sprintf("SELECT %s FROM SYNC_CONFLITOS WHERE %s ORDER BY ID DESC ROWS %d",
+		conflictColumns, strings.Join(where, " AND "), limit), args...)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao consultar conflitos: %w", err)
+	}
+	defer rows.Close()
+
+	list := []*ConflictRecord{}
+	for rows.Next() {
+		c, err := scanConflict(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, c)
+	}
+	return list, rows.Err()
+}
+
+// GetConflict retorna um conflito pelo ID (nil se não existir)
+func (q *QueueManager) GetConflict(id int64) (*ConflictRecord, error) {
+	return getConflict(q.db, "SELECT "+conflictColumns+" FROM SYNC_CONFLITOS WHERE ID = ?", id)
+}
+
+// LockConflictTx lê o conflito travando a linha até o fim da transação, para duas
+// resoluções simultâneas não aplicarem o mesmo evento
+func LockConflictTx(tx *sql.Tx, id int64) (*ConflictRecord, error) {
+	return getConflict(tx, "SELECT "+conflictColumns+" FROM SYNC_CONFLITOS WHERE ID = ? WITH LOCK", id)
+}
+
+// ResolveConflictTx marca o conflito como resolvido
+func ResolveConflictTx(tx *sql.Tx, id int64, resolucao, por string) error {
+	_, err := tx.Exec(`
+		UPDATE SYNC_CONFLITOS SET STATUS = 'R', RESOLUCAO = ?, RESOLVIDO_POR = ?, DT_RESOLUCAO = CURRENT_TIMESTAMP
+		WHERE ID = ?
+	`, resolucao, por, id)
+	if err != nil {
+		return fmt.Errorf("erro ao marcar conflito %d como resolvido: %w", id, err)
+	}
+	return nil
+}
+
+func getConflict(qr queryer, query string, id int64) (*ConflictRecord, error) {
+	rows, err := qr.Query(query, id)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao consultar conflito %d: %w", id, err)
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+	return scanConflict(rows)
+}
+
+func scanConflict(rows *sql.Rows) (*ConflictRecord, error) {
+	c := &ConflictRecord{}
+	var pk, evento, local, motivo, erro, resolucao, por sql.NullString
+	var dtResolucao sql.NullTime
+	if err := rows.Scan(&c.ID, &c.DTConflito, &c.EventID, &c.Tabela, &c.Operacao, &pk, &c.Origem, &evento,
+		&local, &motivo, &erro, &c.Status, &resolucao, &por, &dtResolucao); err != nil {
+		return nil, err
+	}
+	c.EventID = strings.TrimSpace(c.EventID)
+	c.PK = rawJSON(pk)
+	c.Evento = rawJSON(evento)
+	c.Local = rawJSON(local)
+	c.Motivo = motivo.String
+	c.Erro = erro.String
+	c.Resolucao = strings.TrimSpace(resolucao.String)
+	c.ResolvidoPor = strings.TrimSpace(por.String)
+	if dtResolucao.Valid {
+		c.DTResolucao = &dtResolucao.Time
+	}
+	return c, nil
+}
+
+// rawJSON devolve o texto gravado como JSON (null se vazio ou inválido)
+func rawJSON(s sql.NullString) json.RawMessage {
+	text := triggerJSON(s.String)
+	if !s.Valid || !json.Valid([]byte(text)) {
+		return json.RawMessage("null")
+	}
+	return json.RawMessage(text)
+}
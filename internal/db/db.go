@@ -49,3 +49,144 @@ func GetPKColumns(db *sql.DB, tableName string) ([]string, error) {
 	}
 	return pkCols, nil
 }
+
+// QuoteIdent devolve o identificador entre aspas duplas (dialeto 3), escapando aspas internas
+func QuoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// IsTableIntegrated indica se a tabela está ativa em TABELAS_INTEGRADAS
+func IsTableIntegrated(db *sql.DB, table string) bool {
+	var ativo string
+	err := db.QueryRow("SELECT ATIVO FROM TABELAS_INTEGRADAS WHERE NOME_TABELA = ?", strings.ToUpper(table)).Scan(&ativo)
+	return err == nil && ativo == "S"
+}
+
+// GetIntegratedTables lista as tabelas ativas em TABELAS_INTEGRADAS
+func GetIntegratedTables(db *sql.DB) ([]string, error) {
+	rows, err := db.Query("SELECT TRIM(NOME_TABELA) FROM TABELAS_INTEGRADAS WHERE ATIVO = 'S' ORDER BY NOME_TABELA")
+	if err != nil {
+		return nil, fmt.Errorf("erro ao listar tabelas integradas: %w", err)
+	}
+	defer rows.Close()
+
+	var tables []string
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			return nil, err
+		}
+		tables = append(tables, table)
+	}
+	return tables, rows.Err()
+}
+
+// TechnicalFields são campos de controle que o ERP muda e que não representam
+// alteração de negócio: não disparam nova captura e ficam fora da reconciliação
+var TechnicalFields = map[string]bool{
+	"FLAGINTEGRACAO":      true,
+	"SINCRONIZADO":        true,
+	"SYNC_TS":             true,
+	"DATA_ULT_ALTERACAO":  true,
+	"HORA_ULT_ALTERACAO":  true,
+	"DATA_ULT_SINCRONIZA": true,
+	"HORA_ULT_SINCRONIZA": true,
+	"VERSION":             true,
+	"USUARIO_ALT":         true,
+}
+
+// Tipos de campo do Firebird (RDB$FIELDS.RDB$FIELD_TYPE)
+const (
+	FieldSmallint  = 7
+	FieldInteger   = 8
+	FieldFloat     = 10
+	FieldDate      = 12
+	FieldTime      = 13
+	FieldChar      = 14
+	FieldBigint    = 16
+	FieldBoolean   = 23
+	FieldDouble    = 27
+	FieldTimestamp = 35
+	FieldVarchar   = 37
+	FieldBlob      = 261
+)
+
+// ColumnInfo descreve uma coluna de tabela conforme RDB$RELATION_FIELDS/RDB$FIELDS
+type ColumnInfo struct {
+	Name      string
+	FieldType int
+	SubType   int // Em BLOB: 0 = binário, 1 = texto
+	Scale     int // Negativo para NUMERIC/DECIMAL
+	Length    int // Tamanho em caracteres para CHAR/VARCHAR
+	NotNull   bool
+	Computed  bool // COMPUTED BY: não pode ser gravada
+}
+
+// IsInteger indica SMALLINT/INTEGER/BIGINT sem casas decimais
+func (c ColumnInfo) IsInteger() bool {
+	switch c.FieldType {
+	case FieldSmallint, FieldInteger, FieldBigint:
+		return c.Scale == 0
+	}
+	return false
+}
+
+// IsNumeric indica qualquer tipo numérico (inteiros, NUMERIC/DECIMAL e ponto flutuante)
+func (c ColumnInfo) IsNumeric() bool {
+	switch c.FieldType {
+	case FieldSmallint, FieldInteger, FieldBigint, FieldFloat, FieldDouble:
+		return true
+	}
+	return false
+}
+
+// IsText indica CHAR/VARCHAR
+func (c ColumnInfo) IsText() bool {
+	return c.FieldType == FieldChar || c.FieldType == FieldVarchar
+}
+
+// IsBlob indica BLOB de qualquer subtipo
+func (c ColumnInfo) IsBlob() bool {
+	return c.FieldType == FieldBlob
+}
+
+// IsTextBlob indica BLOB SUB_TYPE TEXT
+func (c ColumnInfo) IsTextBlob() bool {
+	return c.FieldType == FieldBlob && c.SubType == 1
+}
+
+// GetColumns retorna as colunas de uma tabela com os metadados de tipo, na ordem física
+func GetColumns(db *sql.DB, tableName string) ([]ColumnInfo, error) {
+	query := `
+		SELECT
+			TRIM(rf.RDB$FIELD_NAME),
+			f.RDB$FIELD_TYPE,
+			COALESCE(f.RDB$FIELD_SUB_TYPE, 0),
+			COALESCE(f.RDB$FIELD_SCALE, 0),
+			COALESCE(f.RDB$CHARACTER_LENGTH, f.RDB$FIELD_LENGTH, 0),
+			COALESCE(rf.RDB$NULL_FLAG, f.RDB$NULL_FLAG, 0),
+			CASE WHEN f.RDB$COMPUTED_BLR IS NULL THEN 0 ELSE 1 END
+		FROM rdb$relation_fields rf
+		JOIN rdb$fields f ON f.rdb$field_name = rf.rdb$field_source
+		WHERE rf.rdb$relation_name = ?
+		ORDER BY rf.rdb$field_position
+	`
+	rows, err := db.Query(query, strings.ToUpper(tableName))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cols []ColumnInfo
+	for rows.Next() {
+		var c ColumnInfo
+		var notNull, computed int
+		if err := rows.Scan(&c.Name, &c.FieldType, &c.SubType, &c.Scale, &c.Length, &notNull, &computed); err != nil {
+			return nil, err
+		}
+		c.NotNull = notNull == 1
+		c.Computed = computed == 1
+		cols = append(cols, c)
+	}
+	return cols, rows.Err()
+}
@@ -0,0 +1,265 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Status que a retenção considera concluídos ou em falha definitiva
+var (
+	PurgeDoneStatuses   = []string{"E", "A", "D", StatusSuperseded} // Enviado, aplicado, despachado, substituído
+	PurgeFailedStatuses = []string{"F"}
+)
+
+// ArchivedEvent é uma linha de FILA_INTEGRACAO apagada pela retenção (arquivo JSONL)
+type ArchivedEvent struct {
+	ID          int64           `json:"id"`
+	EventID     string          `json:"event_id"`
+	Tabela      string          `json:"tabela"`
+	Operacao    string          `json:"operacao"`
+	PK          json.RawMessage `json:"pk"`
+	Payload     json.RawMessage `json:"payload"`
+	Origem      string          `json:"origem"`
+	Status      string          `json:"status"`
+	Tentativas  int             `json:"tentativas"`
+	DTEvento    time.Time       `json:"dt_evento"`
+	DTUltEnvio  *time.Time      `json:"dt_ult_envio,omitempty"`
+	Erro        string          `json:"erro,omitempty"`
+	VersaoBase  string          `json:"versao_base,omitempty"`
+	TransacaoID int64           `json:"transacao_id,omitempty"`
+}
+
+// ArchivedDestino é uma linha de FILA_DESTINOS apagada pela retenção (arquivo JSONL)
+type ArchivedDestino struct {
+	ID             int64      `json:"id"`
+	FilaID         int64      `json:"fila_id"`
+	EventID        string     `json:"event_id,omitempty"`
+	NodeID         string     `json:"node_id"`
+	Status         string     `json:"status"`
+	Tentativas     int        `json:"tentativas"`
+	Erro           string     `json:"erro,omitempty"`
+	DTUltTentativa *time.Time `json:"dt_ult_tentativa,omitempty"`
+}
+
+// ArchivedAudit é uma linha de SYNC_AUDITORIA apagada pela retenção (arquivo JSONL)
+type ArchivedAudit struct {
+	ID        int64     `json:"id"`
+	DTEvento  time.Time `json:"dt_evento"`
+	Origem    string    `json:"origem"`
+	Comando   string    `json:"comando"`
+	Args      string    `json:"args,omitempty"`
+	Status    string    `json:"status"`
+	Resultado string    `json:"resultado,omitempty"`
+}
+
+// PurgeDestinations apaga até limit destinos com um dos status informados cuja última
+// tentativa passou de days dias. archive (opcional) recebe a leva antes do DELETE e,
+// se falhar, nada é apagado. Retorna quantos foram apagados.
+func (q *QueueManager) PurgeDestinations(statuses []string, days, limit int, archive func([]interface{}) error) (int, error) {
+	in, args := statusIn(statuses)
+	args = append([]interface{}{limit}, append(args, -days)...)
+	query := `
+		SELECT FIRST ? d.ID, d.FILA_ID, f.EVENT_ID, d.NODE_ID, d.STATUS, d.TENTATIVAS, d.ERRO_MSG, d.DT_ULT_TENTATIVA
+		FROM FILA_DESTINOS d
+		LEFT JOIN FILA_INTEGRACAO f ON f.ID = d.FILA_ID
+		WHERE d.STATUS IN (` + in + `)
+		  AND d.DT_ULT_TENTATIVA < DATEADD(DAY, ?, CURRENT_TIMESTAMP)
+		ORDER BY d.ID ASC
+	`
+	return q.purge("FILA_DESTINOS", query, args, func(rows *sql.Rows) (int64, interface{}, error) {
+		d := &ArchivedDestino{}
+		var eventID, erro sql.NullString
+		var dt sql.NullTime
+		if err := rows.Scan(&d.ID, &d.FilaID, &eventID, &d.NodeID, &d.Status, &d.Tentativas, &erro, &dt); err != nil {
+			return 0, nil, err
+		}
+		d.EventID = strings.TrimSpace(eventID.String)
+		d.Status = strings.TrimSpace(d.Status)
+		d.Erro = erro.String
+		if dt.Valid {
+			d.DTUltTentativa = &dt.Time
+		}
+		return d.ID, d, nil
+	}, archive)
+}
+
+// PurgeEvents apaga até limit eventos com um dos status informados mais antigos que
+// days dias (último envio ou, sem envio, a captura) e sem nenhum destino restante.
+// Os destinos devem ser expurgados antes (PurgeDestinations).
+func (q *QueueManager) PurgeEvents(statuses []string, days, limit int, archive func([]interface{}) error) (int, error) {
+	in, args := statusIn(statuses)
+	args = append([]interface{}{limit}, append(args, -days)...)
+	query := `
+		SELECT FIRST ? f.ID, f.EVENT_ID, f.TABELA, f.OPERACAO, f.PK_JSON, f.PAYLOAD_JSON, f.ORIGEM, f.STATUS,
+		       f.TENTATIVAS, f.DT_EVENTO, f.DT_ULT_ENVIO, f.ERRO_MSG, f.VERSAO_BASE, f.TRANSACAO_ID
+		FROM FILA_INTEGRACAO f
+		WHERE f.STATUS IN (` + in + `)
+		  AND COALESCE(f.DT_ULT_ENVIO, f.DT_EVENTO) < DATEADD(DAY, ?, CURRENT_TIMESTAMP)
+		  AND NOT EXISTS (SELECT 1 FROM FILA_DESTINOS d WHERE d.FILA_ID = f.ID)
+		ORDER BY f.ID ASC
+	`
+	return q.purge("FILA_INTEGRACAO", query, args, func(rows *sql.Rows) (int64, interface{}, error) {
+		e := &ArchivedEvent{}
+		var pk, payload, origem, erro, versao sql.NullString
+		var dtEnvio sql.NullTime
+		var transacao sql.NullInt64
+		if err := rows.Scan(&e.ID, &e.EventID, &e.Tabela, &e.Operacao, &pk, &payload, &origem, &e.Status,
+			&e.Tentativas, &e.DTEvento, &dtEnvio, &erro, &versao, &transacao); err != nil {
+			return 0, nil, err
+		}
+		e.EventID = strings.TrimSpace(e.EventID)
+		e.Status = strings.TrimSpace(e.Status)
+		e.PK = rawJSON(pk)
+		e.Payload = rawJSON(payload)
+		e.Origem = origem.String
+		e.Erro = erro.String
+		e.VersaoBase = strings.TrimSpace(versao.String)
+		e.TransacaoID = transacao.Int64
+		if dtEnvio.Valid {
+			e.DTUltEnvio = &dtEnvio.Time
+		}
+		return e.ID, e, nil
+	}, archive)
+}
+
+// PurgeAudit apaga até limit registros de SYNC_AUDITORIA com mais de days dias
+func (q *QueueManager) PurgeAudit(days, limit int, archive func([]interface{}) error) (int, error) {
+	query := `
+		SELECT FIRST ? ID, DT_EVENTO, ORIGEM, COMANDO, ARGS, STATUS, RESULTADO
+		FROM SYNC_AUDITORIA
+		WHERE DT_EVENTO < DATEADD(DAY, ?, CURRENT_TIMESTAMP)
+		ORDER BY ID ASC
+	`
+	return q.purge("SYNC_AUDITORIA", query, []interface{}{limit, -days}, func(rows *sql.Rows) (int64, interface{}, error) {
+		a := &ArchivedAudit{}
+		var origem, args, resultado sql.NullString
+		if err := rows.Scan(&a.ID, &a.DTEvento, &origem, &a.Comando, &args, &a.Status, &resultado); err != nil {
+			return 0, nil, err
+		}
+		a.Origem = strings.TrimSpace(origem.String)
+		a.Comando = strings.TrimSpace(a.Comando)
+		a.Args = args.String
+		a.Resultado = resultado.String
+		return a.ID, a, nil
+	}, archive)
+}
+
+// PurgeConflicts apaga até limit conflitos resolvidos há mais de days dias. Os
+// pendentes ficam até alguém decidir.
+func (q *QueueManager) PurgeConflicts(days, limit int, archive func([]interface{}) error) (int, error) {
+	query := `
+		SELECT FIRST ? ` + conflictColumns + `
+		FROM SYNC_CONFLITOS
+		WHERE STATUS = ? AND DT_RESOLUCAO < DATEADD(DAY, ?, CURRENT_TIMESTAMP)
+		ORDER BY ID ASC
+	`
+	return q.purge("SYNC_CONFLITOS", query, []interface{}{limit, ConflictResolved, -days}, func(rows *sql.Rows) (int64, interface{}, error) {
+		c, err := scanConflict(rows)
+		if err != nil {
+			return 0, nil, err
+		}
+		return c.ID, c, nil
+	}, archive)
+}
+
+// PurgeResyncJobs apaga até limit cargas concluídas (ou substituídas) há mais de days
+// dias. As em andamento e as paradas com erro ficam: são retomadas de ULTIMA_PK.
+func (q *QueueManager) PurgeResyncJobs(days, limit int, archive func([]interface{}) error) (int, error) {
+	query := `
+		SELECT FIRST ? ID, TRIM(TABELA), TRIM(NODE_ID), STATUS, ULTIMA_PK, TOTAL, ERRO_MSG, DT_INICIO, DT_ATUALIZACAO
+		FROM SYNC_RESYNC
+		WHERE STATUS = ? AND COALESCE(DT_ATUALIZACAO, DT_INICIO) < DATEADD(DAY, ?, CURRENT_TIMESTAMP)
+		ORDER BY ID ASC
+	`
+	return q.purge("SYNC_RESYNC", query, []interface{}{limit, ResyncDone, -days}, func(rows *sql.Rows) (int64, interface{}, error) {
+		j, err := scanResyncJob(rows)
+		if err != nil {
+			return 0, nil, err
+		}
+		return j.ID, j, nil
+	}, archive)
+}
+
+// PurgeVersions esquece até limit versões de linhas sem alteração há mais de days
+// dias. Não há arquivamento: SYNC_VERSOES só serve à detecção de conflito e a versão
+// volta a ser registrada na próxima alteração da linha.
+func (q *QueueManager) PurgeVersions(days, limit int) (int, error) {
+	res, err := q.db.Exec("DELETE FROM SYNC_VERSOES WHERE DT_EVENTO < DATEADD(DAY, ?, CURRENT_TIMESTAMP) ROWS ?", -days, limit)
+	if err != nil {
+		return 0, fmt.Errorf("erro ao apagar SYNC_VERSOES: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("erro ao contar SYNC_VERSOES apagadas: %w", err)
+	}
+	return int(n), nil
+}
+
+// purge lê uma leva com query, entrega as linhas ao arquivamento e apaga pelo ID,
+// tudo numa transação curta
+func (q *QueueManager) purge(table, query string, args []interface{}, scan func(*sql.Rows) (int64, interface{}, error), archive func([]interface{}) error) (int, error) {
+	tx, err := q.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("erro ao iniciar transação: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("erro ao consultar %s para expurgo: %w", table, err)
+	}
+	var ids []int64
+	var records []interface{}
+	for rows.Next() {
+		id, rec, err := scan(rows)
+		if err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("erro ao ler %s para expurgo: %w", table, err)
+		}
+		ids = append(ids, id)
+		records = append(records, rec)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	if archive != nil {
+		if err := archive(records); err != nil {
+			return 0, fmt.Errorf("erro ao arquivar %s: %w", table, err)
+		}
+	}
+
+	stmt, err := tx.Prepare("DELETE FROM " + table + " WHERE ID = ?")
+	if err != nil {
+		return 0, fmt.Errorf("erro ao preparar expurgo de %s: %w", table, err)
+	}
+	defer stmt.Close()
+	for _, id := range ids {
+		if _, err := stmt.Exec(id); err != nil {
+			return 0, fmt.Errorf("erro ao apagar %s %d: %w", table, id, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("erro ao comitar expurgo de %s: %w", table, err)
+	}
+	return len(ids), nil
+}
+
+// statusIn monta "?, ?" e os argumentos para um filtro STATUS IN
+func statusIn(statuses []string) (string, []interface{}) {
+	marks := make([]string, len(statuses))
+	args := make([]interface{}, len(statuses))
+	for i, s := range statuses {
+		marks[i] = "?"
+		args[i] = s
+	}
+	return strings.Join(marks, ", "), args
+}
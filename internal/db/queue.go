@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -20,6 +21,8 @@ type FilaItem struct {
 	Status      string
 	Tentativas  int
 	DTEvento    time.Time
+	VersaoBase  string // Versão da linha antes deste evento (ver StampLocalVersion)
+	TransacaoID int64  // Transação de origem (CURRENT_TRANSACTION); 0 = evento avulso
 }
 
 type Node struct {
@@ -40,35 +43,63 @@ type FilaDestino struct {
 type QueueManager struct {
 	db     *sql.DB
 	nodeID string
+	schema *SchemaCache
 }
 
 func NewQueueManager(db *sql.DB, nodeID string) *QueueManager {
-	return &QueueManager{db: db, nodeID: nodeID}
+	return &QueueManager{db: db, nodeID: nodeID, schema: NewSchemaCache(db)}
 }
 
 // Insert adiciona um novo evento na fila
 func (q *QueueManager) Insert(tabela, operacao string, pk map[string]interface{}, payload map[string]interface{}) error {
-	eventID := uuid.New().String()
+	return q.InsertTransaction(0, []QueueEvent{{Tabela: tabela, Operacao: operacao, PK: pk, Payload: payload}})
+}
+
+// QueueEvent é um evento capturado fora das triggers (modo trace)
+type QueueEvent struct {
+	Tabela   string
+	Operacao string
+	PK       map[string]interface{}
+	Payload  map[string]interface{}
+}
 
-	pkJSON, _ := json.Marshal(pk)
-	payloadJSON, _ := json.Marshal(payload)
+// InsertTransaction enfileira de uma vez os eventos de uma transação de origem, para
+// o Poller nunca ver só parte dela. transacaoID 0 = sem transação de origem conhecida.
+func (q *QueueManager) InsertTransaction(transacaoID int64, events []QueueEvent) error {
+	var transacao interface{}
+	if transacaoID > 0 {
+		transacao = transacaoID
+	}
+
+	tx, err := q.db.Begin()
+	if err != nil {
+		return fmt.Errorf("erro ao iniciar transação: %w", err)
+	}
+	defer tx.Rollback()
 
 	query := `
-		INSERT INTO FILA_INTEGRACAO (EVENT_ID, TABELA, OPERACAO, PK_JSON, PAYLOAD_JSON, ORIGEM, STATUS, TENTATIVAS, DT_EVENTO)
-		VALUES (?, ?, ?, ?, ?, ?, 'P', 0, CURRENT_TIMESTAMP)
+		INSERT INTO FILA_INTEGRACAO (EVENT_ID, TABELA, OPERACAO, PK_JSON, PAYLOAD_JSON, ORIGEM, STATUS, TENTATIVAS, DT_EVENTO, TRANSACAO_ID)
+		VALUES (?, ?, ?, ?, ?, ?, 'P', 0, CURRENT_TIMESTAMP, ?)
 	`
-	_, err := q.db.Exec(query, eventID, tabela, operacao, string(pkJSON), string(payloadJSON), q.nodeID)
-	if err != nil {
-		return fmt.Errorf("erro ao inserir na fila: %w", err)
+	for _, e := range events {
+		pkJSON, _ := json.Marshal(e.PK)
+		payloadJSON, _ := json.Marshal(e.Payload)
+		if _, err := tx.Exec(query, uuid.New().String(), e.Tabela, e.Operacao, string(pkJSON), string(payloadJSON), q.nodeID, transacao); err != nil {
+			return fmt.Errorf("erro ao inserir na fila: %w", err)
+		}
 	}
 
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("erro ao inserir na fila: %w", err)
+	}
 	return nil
 }
 
 // GetPending retorna itens pendentes ou para reenvio
 func (q *QueueManager) GetPending(limit int) ([]*FilaItem, error) {
 	query := `
-		SELECT FIRST ? ID, EVENT_ID, TABELA, OPERACAO, PK_JSON, PAYLOAD_JSON, ORIGEM, STATUS, TENTATIVAS, DT_EVENTO
+		SELECT FIRST ? ID, EVENT_ID, TABELA, OPERACAO, PK_JSON, PAYLOAD_JSON, ORIGEM, STATUS, TENTATIVAS, DT_EVENTO,
+		       TRANSACAO_ID
 		FROM FILA_INTEGRACAO
 		WHERE STATUS IN ('P', 'R')
 		ORDER BY ID ASC
@@ -82,14 +113,18 @@ func (q *QueueManager) GetPending(limit int) ([]*FilaItem, error) {
 	var items []*FilaItem
 	for rows.Next() {
 		item := &FilaItem{}
+		var transacao sql.NullInt64
 		err := rows.Scan(
 			&item.ID, &item.EventID, &item.Tabela, &item.Operacao,
 			&item.PKJSON, &item.PayloadJSON, &item.Origem, &item.Status,
-			&item.Tentativas, &item.DTEvento,
+			&item.Tentativas, &item.DTEvento, &transacao,
 		)
 		if err != nil {
 			return nil, err
 		}
+		item.TransacaoID = transacao.Int64
+		item.PKJSON = triggerJSON(item.PKJSON)
+		item.PayloadJSON = triggerJSON(item.PayloadJSON)
 		items = append(items, item)
 	}
 	return items, nil
@@ -168,13 +203,117 @@ func (q *QueueManager) RegisterStaticNode(nodeID, remoteURL string) error {
 	return err
 }
 
+// RequeueFailed devolve para 'P' os destinos em falha definitiva ('F'), zerando as
+// tentativas. nodeID e tabela vazios não filtram. Destinos cuja linha já recebeu um
+// evento mais novo no mesmo nó (entregue ou em trânsito) ficam em 'F': reenviá-los
+// sobrescreveria no destino o estado mais recente. Retorna quantos foram
+// reenfileirados e quantos foram ignorados por isso.
+func (q *QueueManager) RequeueFailed(nodeID, tabela string) (int64, int64, error) {
+	filter := ""
+	var args []interface{}
+	if nodeID != "" {
+		filter += " AND d.NODE_ID = ?"
+		args = append(args, nodeID)
+	}
+	if tabela != "" {
+		filter += " AND f.TABELA = ?"
+		args = append(args, strings.ToUpper(tabela))
+	}
+
+	failed, err := q.rowDestinations("d.STATUS = 'F'"+filter, args...)
+	if err != nil {
+		return 0, 0, fmt.Errorf("erro ao consultar destinos em falha: %w", err)
+	}
+	if len(failed) == 0 {
+		return 0, 0, nil
+	}
+
+	// Eventos mais novos já enviados das mesmas linhas (maior ID de destino por linha)
+	newer := make(map[string]int64)
+	sent, err := q.rowDestinations("d.STATUS IN ('E', 'I') AND d.ID > ?"+filter, append([]interface{}{failed[0].id}, args...)...)
+	if err != nil {
+		return 0, 0, fmt.Errorf("erro ao consultar destinos enviados: %w", err)
+	}
+	for _, d := range sent {
+		if d.id > newer[d.key] {
+			newer[d.key] = d.id
+		}
+	}
+
+	tx, err := q.db.Begin()
+	if err != nil {
+		return 0, 0, fmt.Errorf("erro ao iniciar transação: %w", err)
+	}
+	defer tx.Rollback()
+
+	var requeued, skipped int64
+	for _, d := range failed {
+		if newer[d.key] > d.id {
+			skipped++
+			continue
+		}
+		res, err := tx.Exec(`
+			UPDATE FILA_DESTINOS
+			SET STATUS = 'P', TENTATIVAS = 0, ERRO_MSG = NULL, NEXT_ATTEMPT_AT = NULL
+			WHERE ID = ? AND STATUS = 'F'
+		`, d.id)
+		if err != nil {
+			return 0, 0, fmt.Errorf("erro ao reenfileirar destino %d: %w", d.id, err)
+		}
+		n, _ := res.RowsAffected()
+		requeued += n
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, 0, fmt.Errorf("erro ao reenfileirar destinos: %w", err)
+	}
+	return requeued, skipped, nil
+}
+
+// rowDestination é um destino identificado pela linha (RowKey com o nó)
+type rowDestination struct {
+	id  int64
+	key string
+}
+
+// rowDestinations lista, em ordem de ID, os destinos que atendem a where (sobre
+// FILA_DESTINOS d e FILA_INTEGRACAO f)
+func (q *QueueManager) rowDestinations(where string, args ...interface{}) ([]rowDestination, error) {
+	rows, err := q.db.Query(`
+		SELECT d.ID, d.NODE_ID, f.TABELA, f.PK_JSON
+		FROM FILA_DESTINOS d
+		JOIN FILA_INTEGRACAO f ON d.FILA_ID = f.ID
+		WHERE `+where+`
+		ORDER BY d.ID ASC
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []rowDestination
+	for rows.Next() {
+		var d rowDestination
+		var nodeID, tabela, pkJSON string
+		if err := rows.Scan(&d.id, &nodeID, &tabela, &pkJSON); err != nil {
+			return nil, err
+		}
+		d.key = RowKey(strings.TrimSpace(nodeID), strings.TrimSpace(tabela), pkJSON)
+		result = append(result, d)
+	}
+	return result, rows.Err()
+}
+
+const destinoColumns = `d.ID, d.FILA_ID, d.NODE_ID, d.STATUS, d.TENTATIVAS,
+		       f.EVENT_ID, f.TABELA, f.OPERACAO, f.PK_JSON, f.PAYLOAD_JSON, f.ORIGEM, f.DT_EVENTO,
+		       f.VERSAO_BASE, f.TRANSACAO_ID`
+
 func (q *QueueManager) GetPendingDestinations(limit int) ([]*FilaDestino, error) {
 	query := `
-		SELECT FIRST ? d.ID, d.FILA_ID, d.NODE_ID, d.STATUS, d.TENTATIVAS,
-		       f.EVENT_ID, f.TABELA, f.OPERACAO, f.PK_JSON, f.PAYLOAD_JSON, f.ORIGEM, f.DT_EVENTO
+		SELECT FIRST ? ` + destinoColumns + `
 		FROM FILA_DESTINOS d
 		JOIN FILA_INTEGRACAO f ON d.FILA_ID = f.ID
-		WHERE d.STATUS IN ('P', 'R')
+		WHERE d.STATUS IN ('P', 'R', 'I')
+		  AND (d.NEXT_ATTEMPT_AT IS NULL OR d.NEXT_ATTEMPT_AT <= CURRENT_TIMESTAMP)
 		ORDER BY d.ID ASC
 	`
 	rows, err := q.db.Query(query, limit)
@@ -185,22 +324,37 @@ func (q *QueueManager) GetPendingDestinations(limit int) ([]*FilaDestino, error)
 
 	var dests []*FilaDestino
 	for rows.Next() {
-		d := &FilaDestino{Item: &FilaItem{}}
-		err := rows.Scan(
-			&d.ID, &d.FilaID, &d.NodeID, &d.Status, &d.Tentativas,
-			&d.Item.EventID, &d.Item.Tabela, &d.Item.Operacao,
-			&d.Item.PKJSON, &d.Item.PayloadJSON, &d.Item.Origem, &d.Item.DTEvento,
-		)
+		d, err := scanDestino(rows)
 		if err != nil {
 			return nil, err
 		}
-		// Sincroniza o ID do item
-		d.Item.ID = d.FilaID
 		dests = append(dests, d)
 	}
 	return dests, nil
 }
 
+func scanDestino(rows *sql.Rows, extra ...interface{}) (*FilaDestino, error) {
+	d := &FilaDestino{Item: &FilaItem{}}
+	var versaoBase sql.NullString
+	var transacao sql.NullInt64
+	dest := []interface{}{
+		&d.ID, &d.FilaID, &d.NodeID, &d.Status, &d.Tentativas,
+		&d.Item.EventID, &d.Item.Tabela, &d.Item.Operacao,
+		&d.Item.PKJSON, &d.Item.PayloadJSON, &d.Item.Origem, &d.Item.DTEvento,
+		&versaoBase, &transacao,
+	}
+	if err := rows.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	d.Item.VersaoBase = strings.TrimSpace(versaoBase.String)
+	d.Item.TransacaoID = transacao.Int64
+	d.Item.PKJSON = triggerJSON(d.Item.PKJSON)
+	d.Item.PayloadJSON = triggerJSON(d.Item.PayloadJSON)
+	// Sincroniza o ID do item
+	d.Item.ID = d.FilaID
+	return d, nil
+}
+
 func (q *QueueManager) UpdateDestinoStatus(id int64, status string, erroMsg string) error {
 	query := `
 		UPDATE FILA_DESTINOS 
@@ -210,3 +364,118 @@ func (q *QueueManager) UpdateDestinoStatus(id int64, status string, erroMsg stri
 	_, err := q.db.Exec(query, status, erroMsg, id)
 	return err
 }
+
+// ScheduleDestinoRetry marca o destino para reenvio ('R') só depois de delaySeconds
+func (q *QueueManager) ScheduleDestinoRetry(id int64, erroMsg string, delaySeconds int) error {
+	query := `
+		UPDATE FILA_DESTINOS 
+		SET STATUS = 'R', ERRO_MSG = ?, DT_ULT_TENTATIVA = CURRENT_TIMESTAMP, TENTATIVAS = TENTATIVAS + 1,
+		    NEXT_ATTEMPT_AT = DATEADD(SECOND, ?, CURRENT_TIMESTAMP)
+		WHERE ID = ?
+	`
+	_, err := q.db.Exec(query, erroMsg, delaySeconds, id)
+	return err
+}
+
+// RowKey identifica uma linha (nó + tabela + PK) independente da ordem das chaves no PK_JSON
+func RowKey(nodeID, tabela, pkJSON string) string {
+	var pk map[string]interface{}
+	if err := json.Unmarshal([]byte(triggerJSON(pkJSON)), &pk); err == nil {
+		if normalized, err := json.Marshal(pk); err == nil {
+			pkJSON = string(normalized)
+		}
+	}
+	return nodeID + "|" + strings.ToUpper(tabela) + "|" + pkJSON
+}
+
+// triggerJSON escapa os caracteres de controle que a trigger grava crus dentro dos
+// textos do PK_JSON/PAYLOAD_JSON (ela só escapa \, ", tabulação e quebras de linha).
+// Fora dos textos o JSON da trigger não tem caracteres de controle.
+func triggerJSON(s string) string {
+	if strings.IndexFunc(s, func(r rune) bool { return r < 0x20 }) < 0 {
+		return s
+	}
+	var b strings.Builder
+	for _, r := range s {
+		if r < 0x20 {
+			fmt.Fprintf(&b, `\u%04x`, r)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// GetBlockedRows retorna as linhas com destino aguardando reenvio ou confirmação
+// (NEXT_ATTEMPT_AT ainda não vencido), com o menor ID de destino de cada linha.
+// Eventos posteriores dessas linhas devem esperar para não serem aplicados fora de ordem.
+func (q *QueueManager) GetBlockedRows() (map[string]int64, error) {
+	query := `
+		SELECT d.ID, d.NODE_ID, f.TABELA, f.PK_JSON
+		FROM FILA_DESTINOS d
+		JOIN FILA_INTEGRACAO f ON d.FILA_ID = f.ID
+		WHERE d.STATUS IN ('P', 'R', 'I') AND d.NEXT_ATTEMPT_AT > CURRENT_TIMESTAMP
+	`
+	rows, err := q.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	blocked := make(map[string]int64)
+	for rows.Next() {
+		var id int64
+		var nodeID, tabela, pkJSON string
+		if err := rows.Scan(&id, &nodeID, &tabela, &pkJSON); err != nil {
+			return nil, err
+		}
+		key := RowKey(strings.TrimSpace(nodeID), strings.TrimSpace(tabela), pkJSON)
+		if first, ok := blocked[key]; !ok || id < first {
+			blocked[key] = id
+		}
+	}
+	return blocked, rows.Err()
+}
+
+// MarkDestinoInFlight marca o destino como em trânsito ('I') aguardando confirmação.
+// Se o "ack" não chegar até o prazo, o destino volta a ser retornado como pendente.
+func (q *QueueManager) MarkDestinoInFlight(id int64, timeoutSeconds int) error {
+	query := `
+		UPDATE FILA_DESTINOS 
+		SET STATUS = 'I', DT_ULT_TENTATIVA = CURRENT_TIMESTAMP,
+		    NEXT_ATTEMPT_AT = DATEADD(SECOND, ?, CURRENT_TIMESTAMP)
+		WHERE ID = ?
+	`
+	_, err := q.db.Exec(query, timeoutSeconds, id)
+	return err
+}
+
+// PostponeDestino adia o envio sem contar tentativa (ex.: destino offline no Hub)
+func (q *QueueManager) PostponeDestino(id int64, erroMsg string, delaySeconds int) error {
+	query := `
+		UPDATE FILA_DESTINOS 
+		SET STATUS = 'P', ERRO_MSG = ?, NEXT_ATTEMPT_AT = DATEADD(SECOND, ?, CURRENT_TIMESTAMP)
+		WHERE ID = ?
+	`
+	_, err := q.db.Exec(query, erroMsg, delaySeconds, id)
+	return err
+}
+
+// GetInFlightDestino localiza o destino em trânsito de um evento para um nó (usado no "ack")
+func (q *QueueManager) GetInFlightDestino(eventID, nodeID string) (*FilaDestino, error) {
+	query := `
+		SELECT d.ID, d.FILA_ID, d.NODE_ID, d.STATUS, d.TENTATIVAS
+		FROM FILA_DESTINOS d
+		JOIN FILA_INTEGRACAO f ON d.FILA_ID = f.ID
+		WHERE f.EVENT_ID = ? AND d.NODE_ID = ? AND d.STATUS = 'I'
+	`
+	d := &FilaDestino{}
+	err := q.db.QueryRow(query, eventID, nodeID).Scan(&d.ID, &d.FilaID, &d.NodeID, &d.Status, &d.Tentativas)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
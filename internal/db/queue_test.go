@@ -0,0 +1,37 @@
+package db
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestTriggerJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want string
+	}{
+		{"sem caracteres de controle", `{"NOME":"JOAO \"ZE\"\n"}`, `{"NOME":"JOAO \"ZE\"\n"}`},
+		{"controle cru no texto", "{\"OBS\":\"A\x01B\x0cC\x00\"}", `{"OBS":"A\u0001B\u000cC\u0000"}`},
+		{"acentos preservados", "{\"NOME\":\"AÇÃO\x1f\"}", `{"NOME":"AÇÃO\u001f"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := triggerJSON(tt.raw)
+			if got != tt.want {
+				t.Errorf("triggerJSON = %q, esperado %q", got, tt.want)
+			}
+			if !json.Valid([]byte(got)) {
+				t.Errorf("JSON inválido: %q", got)
+			}
+		})
+	}
+}
+
+func TestRowKeyControlChars(t *testing.T) {
+	raw := RowKey("B", "produto", "{\"CODIGO\":\"A\x01\"}")
+	escaped := RowKey("B", "PRODUTO", `{"CODIGO": "A\u0001"}`)
+	if raw != escaped {
+		t.Errorf("RowKey = %q, esperado %q", raw, escaped)
+	}
+}
@@ -3,6 +3,7 @@ package db
 import (
 	"database/sql"
 	"fmt"
+	"log"
 	"strings"
 
 	"github.com/atsinformatica/firebird-sync-agent/internal/trace"
@@ -17,100 +18,127 @@ func NewDataResolver(db *sql.DB, queue *QueueManager) *DataResolver {
 	return &DataResolver{db: db, queue: queue}
 }
 
-// Resolve toma uma lista de eventos de uma transação commitada e os processa
+// Resolve toma uma lista de eventos de uma transação commitada e os processa.
+// Os eventos resolvidos entram juntos na fila, marcados com a transação de origem.
 func (r *DataResolver) Resolve(events []*trace.TraceEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	var queued []QueueEvent
 	for _, event := range events {
 		// 1. Verifica se a tabela deve ser integrada
-		if !r.isTableIntegrated(event.Table) {
+		if !IsTableIntegrated(r.db, event.Table) {
 			continue
 		}
 
 		// 2. Identifica as colunas de PK
 		pkCols, err := GetPKColumns(r.db, event.Table)
 		if err != nil || len(pkCols) == 0 {
-			fmt.Printf("Aviso: Tabela %s sem PK definida ou erro na busca. Pulando.\n", event.Table)
+			log.Printf("[TRACE] Aviso: tabela %s sem PK definida ou erro na busca (%v). Evento da transação %s NÃO enfileirado.", event.Table, err, event.TransID)
 			continue
 		}
 
-		// 3. Extrai valores de PK do SQL (Aproximação)
-		// No FB 2.5, extrair valores do SQL do Trace é complexo.
-		// Para fins deste agente, vamos assumir que extraímos os valores da PK
-		// ou que o Trace nos deu contexto suficiente.
-		pkValues := r.extractPKValuesFromSQL(event.SQL, pkCols)
+		// 3. Extrai valores de PK do SQL (literais ou parâmetros impressos pelo Trace).
+		// UPDATE/DELETE de várias linhas (WHERE sem a PK inteira) não pode ser resolvido
+		// pelo trace: a alteração não é replicada e precisa de resync/reconciliação.
+		pkValues, err := r.extractPKValuesFromSQL(event, pkCols)
+		if err != nil {
+			log.Printf("[TRACE] Aviso: %s em %s da transação %s NÃO enfileirado, PK não identificada: %v. Use resync ou reconcile na tabela.", event.Type, event.Table, event.TransID, err)
+			continue
+		}
 
 		if event.Type == trace.EventDelete {
 			// Para Delete, enviamos apenas a PK
-			r.queue.Insert(event.Table, "D", pkValues, nil)
+			queued = append(queued, QueueEvent{Tabela: event.Table, Operacao: "D", PK: pkValues})
 			continue
 		}
 
 		// 4. Faz snapshot para Insert/Update
 		snapshot, err := r.fetchSnapshot(event.Table, pkCols, pkValues)
 		if err != nil {
-			fmt.Printf("Erro ao buscar snapshot de %s: %v\n", event.Table, err)
+			log.Printf("[TRACE] Erro ao buscar snapshot de %s %v (transação %s): %v", event.Table, pkValues, event.TransID, err)
 			continue
 		}
 
-		r.queue.Insert(event.Table, string(event.Type)[0:1], pkValues, snapshot)
+		queued = append(queued, QueueEvent{Tabela: event.Table, Operacao: string(event.Type)[0:1], PK: pkValues, Payload: snapshot})
 	}
-	return nil
-}
 
-func (r *DataResolver) isTableIntegrated(table string) bool {
-	var ativo string
-	err := r.db.QueryRow("SELECT ATIVO FROM TABELAS_INTEGRADAS WHERE NOME_TABELA = ?", strings.ToUpper(table)).Scan(&ativo)
-	return err == nil && ativo == "S"
+	if len(queued) == 0 {
+		return nil
+	}
+	return r.queue.InsertTransaction(ParseTransactionID(events[0].TransID), queued)
 }
 
 func (r *DataResolver) fetchSnapshot(table string, pkCols []string, pkValues map[string]interface{}) (map[string]interface{}, error) {
-	whereClauses := []string{}
-	args := []interface{}{}
-	for _, col := range pkCols {
-		whereClauses = append(whereClauses, fmt.Sprintf("%s = ?", col))
-		args = append(args, pkValues[col])
-	}
-
-	query := fmt.Sprintf("SELECT * FROM %s WHERE %s", table, strings.Join(whereClauses, " AND "))
-
-	rows, err := r.db.Query(query, args...)
+	row, err := r.queue.fetchRow(table, pkCols, pkValues)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-
-	if !rows.Next() {
+	if row == nil {
 		return nil, fmt.Errorf("registro não encontrado")
 	}
+	return row, nil
+}
 
-	cols, _ := rows.Columns()
-	values := make([]interface{}, len(cols))
-	valuePtrs := make([]interface{}, len(cols))
-	for i := range values {
-		valuePtrs[i] = &values[i]
+// extractPKValuesFromSQL identifica os valores da PK afetada pelo statement:
+// INSERT usa a lista de colunas/valores, UPDATE/DELETE usam as igualdades do WHERE
+// e, num UPDATE que altera a própria PK, o valor novo do SET prevalece.
+func (r *DataResolver) extractPKValuesFromSQL(event *trace.TraceEvent, pkCols []string) (map[string]interface{}, error) {
+	stmt, err := trace.ParseDML(event.SQL, event.Params)
+	if err != nil {
+		return nil, err
 	}
 
-	if err := rows.Scan(valuePtrs...); err != nil {
-		return nil, err
+	values := make(map[string]interface{})
+	for col, v := range stmt.Where {
+		values[col] = v
 	}
 
-	result := make(map[string]interface{})
+	cols := stmt.Columns
+	if len(cols) == 0 && len(stmt.Values) > 0 {
+		// INSERT INTO T VALUES (...) sem lista de colunas: usa a ordem física da tabela
+		if cols, err = r.getColumnOrder(event.Table); err != nil {
+			return nil, err
+		}
+	}
 	for i, col := range cols {
-		val := values[i]
-		if b, ok := val.([]byte); ok {
-			result[col] = string(b)
-		} else {
-			result[col] = val
+		if i >= len(stmt.Values) {
+			break
 		}
+		values[col] = stmt.Values[i]
 	}
 
-	return result, nil
+	res := make(map[string]interface{})
+	for _, col := range pkCols {
+		v, ok := values[col]
+		if !ok {
+			return nil, fmt.Errorf("coluna de PK %s não encontrada no SQL", col)
+		}
+		if expr, isExpr := v.(trace.Expr); isExpr {
+			return nil, fmt.Errorf("coluna de PK %s recebe expressão não literal (%s)", col, string(expr))
+		}
+		if v == nil {
+			return nil, fmt.Errorf("coluna de PK %s sem valor (parâmetro não impresso pelo Trace?)", col)
+		}
+		res[col] = v
+	}
+	return res, nil
 }
 
-func (r *DataResolver) extractPKValuesFromSQL(sqlText string, pkCols []string) map[string]interface{} {
-	// Implementação simplificada: em um cenário real, precisaríamos de um parser de SQL
-	// ou capturar os parâmetros do Trace se estivessem ativos.
-	// Por enquanto, retornamos um mapa vazio que precisará ser populado via lógica de parsing do SQL.
-	res := make(map[string]interface{})
-	// ... (Lógica de parser de SQL viria aqui)
-	return res
+func (r *DataResolver) getColumnOrder(table string) ([]string, error) {
+	rows, err := r.db.Query(`SELECT TRIM(RDB$FIELD_NAME) FROM RDB$RELATION_FIELDS WHERE RDB$RELATION_NAME = ? ORDER BY RDB$FIELD_POSITION`, strings.ToUpper(table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cols []string
+	for rows.Next() {
+		var c string
+		if err := rows.Scan(&c); err != nil {
+			return nil, err
+		}
+		cols = append(cols, c)
+	}
+	return cols, rows.Err()
 }
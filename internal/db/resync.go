@@ -0,0 +1,325 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Status gravados em SYNC_RESYNC
+const (
+	ResyncRunning = "A" // Em andamento (ou interrompida: retoma de ULTIMA_PK)
+	ResyncDone    = "C" // Concluída
+	ResyncError   = "E" // Parou com erro (retoma de ULTIMA_PK)
+)
+
+// ResyncAllNodes é o NODE_ID gravado quando a carga vai para todos os destinos
+const ResyncAllNodes = "*"
+
+// ResyncJob é o progresso de uma carga inicial/ressincronização de tabela
+type ResyncJob struct {
+	ID            int64     `json:"id"`
+	Tabela        string    `json:"tabela"`
+	NodeID        string    `json:"node_id"`
+	Status        string    `json:"status"`
+	UltimaPK      string    `json:"ultima_pk,omitempty"`
+	Total         int64     `json:"total"`
+	ErroMsg       string    `json:"erro,omitempty"`
+	DTInicio      time.Time `json:"dt_inicio"`
+	DTAtualizacao time.Time `json:"dt_atualizacao"`
+}
+
+// StartResync prepara a carga da tabela para um nó (ou ResyncAllNodes). Se já existe
+// uma carga não concluída para o mesmo par, ela é retomada de onde parou, a menos
+// que restart seja informado.
+func (q *QueueManager) StartResync(tabela, nodeID string, restart bool) (*ResyncJob, error) {
+	tabela = strings.ToUpper(strings.TrimSpace(tabela))
+	if nodeID == "" {
+		nodeID = ResyncAllNodes
+	}
+	if nodeID == q.nodeID {
+		return nil, fmt.Errorf("o destino não pode ser o próprio nó")
+	}
+	if !IsTableIntegrated(q.db, tabela) {
+		return nil, fmt.Errorf("tabela %s não está integrada", tabela)
+	}
+	pkCols, err := GetPKColumns(q.db, tabela)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao buscar PK de %s: %w", tabela, err)
+	}
+	if len(pkCols) == 0 {
+		return nil, fmt.Errorf("tabela %s sem PK: não é possível paginar", tabela)
+	}
+
+	var id int64
+	err = q.db.QueryRow(`
+		SELECT FIRST 1 ID FROM SYNC_RESYNC
+		WHERE TABELA = ? AND NODE_ID = ? AND STATUS <> 'C'
+		ORDER BY ID DESC
+	`, tabela, nodeID).Scan(&id)
+	switch {
+	case err == sql.ErrNoRows:
+		id = 0
+	case err != nil:
+		return nil, fmt.Errorf("erro ao consultar cargas: %w", err)
+	}
+
+	if id != 0 && restart {
+		if _, err := q.db.Exec("UPDATE SYNC_RESYNC SET STATUS = 'C', ERRO_MSG = 'Substituída por nova carga' WHERE ID = ?", id); err != nil {
+			return nil, fmt.Errorf("erro ao encerrar carga anterior: %w", err)
+		}
+		id = 0
+	}
+
+	if id == 0 {
+		if err := q.db.QueryRow("SELECT GEN_ID(GEN_SYNC_RESYNC_ID, 1) FROM RDB$DATABASE").Scan(&id); err != nil {
+			return nil, fmt.Errorf("erro ao gerar ID da carga: %w", err)
+		}
+		_, err = q.db.Exec(`
+			INSERT INTO SYNC_RESYNC (ID, TABELA, NODE_ID, STATUS, TOTAL, DT_INICIO, DT_ATUALIZACAO)
+			VALUES (?, ?, ?, 'A', 0, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		`, id, tabela, nodeID)
+		if err != nil {
+			return nil, fmt.Errorf("erro ao registrar carga: %w", err)
+		}
+	} else {
+		if _, err := q.db.Exec("UPDATE SYNC_RESYNC SET STATUS = 'A', ERRO_MSG = NULL WHERE ID = ?", id); err != nil {
+			return nil, fmt.Errorf("erro ao retomar carga: %w", err)
+		}
+	}
+	return q.GetResyncJob(id)
+}
+
+// RunResync enfileira a tabela página a página até o fim (ou até ctx ser cancelado).
+// Cada página é gravada junto com o progresso na mesma transação: uma carga
+// interrompida retoma sem duplicar nem pular linhas.
+func (q *QueueManager) RunResync(ctx context.Context, jobID int64, pageSize int, progress func(*ResyncJob)) (*ResyncJob, error) {
+	if pageSize <= 0 {
+		pageSize = 500
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return q.GetResyncJob(jobID)
+		}
+
+		done, err := q.resyncPage(jobID, pageSize)
+		if err != nil {
+			q.db.Exec("UPDATE SYNC_RESYNC SET STATUS = 'E', ERRO_MSG = ?, DT_ATUALIZACAO = CURRENT_TIMESTAMP WHERE ID = ?", err.Error(), jobID)
+			job, _ := q.GetResyncJob(jobID)
+			return job, err
+		}
+
+		job, err := q.GetResyncJob(jobID)
+		if err != nil {
+			return nil, err
+		}
+		if progress != nil {
+			progress(job)
+		}
+		if done {
+			return job, nil
+		}
+	}
+}
+
+// resyncPage lê a próxima página da tabela (após ULTIMA_PK, na ordem da PK) e
+// grava um evento 'U' sintético por linha. Retorna true quando a tabela acabou.
+// O ID do evento sai do gerador na leitura, e uma transação do ERP ainda aberta
+// pode já ter um evento de ID menor com valores mais novos; por isso o payload
+// gravado aqui não é enviado: o Poller relê a linha no envio (buildPayload).
+func (q *QueueManager) resyncPage(jobID int64, pageSize int) (bool, error) {
+	tx, err := q.db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("erro ao iniciar transação: %w", err)
+	}
+	defer tx.Rollback()
+
+	// WITH LOCK impede que duas execuções da mesma carga gravem a mesma página
+	var tabela, nodeID, status string
+	var ultimaPK sql.NullString
+	err = tx.QueryRow("SELECT TRIM(TABELA), TRIM(NODE_ID), STATUS, ULTIMA_PK FROM SYNC_RESYNC WHERE ID = ? WITH LOCK", jobID).
+		Scan(&tabela, &nodeID, &status, &ultimaPK)
+	if err != nil {
+		return false, fmt.Errorf("erro ao ler progresso da carga %d: %w", jobID, err)
+	}
+	if status == ResyncDone {
+		return true, nil
+	}
+
+	m, err := q.tableMeta(tabela)
+	if err != nil {
+		return false, err
+	}
+	var r PKRange
+	if ultimaPK.Valid && ultimaPK.String != "" {
+		decoder := json.NewDecoder(strings.NewReader(ultimaPK.String))
+		decoder.UseNumber()
+		if err := decoder.Decode(&r.From); err != nil {
+			return false, fmt.Errorf("ULTIMA_PK inválida: %w", err)
+		}
+	}
+
+	// BLOBs ficam de fora, como na trigger: o Poller os lê da linha no envio
+	var page []map[string]interface{}
+	err = q.scanRange(tx, m, m.payload, r, pageSize, func(row map[string]interface{}) error {
+		page = append(page, row)
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	status = ResyncRunning
+	if len(page) < pageSize {
+		status = ResyncDone
+	}
+	if len(page) == 0 {
+		_, err = tx.Exec("UPDATE SYNC_RESYNC SET STATUS = ?, DT_ATUALIZACAO = CURRENT_TIMESTAMP WHERE ID = ?", status, jobID)
+		if err != nil {
+			return false, err
+		}
+		return true, tx.Commit()
+	}
+
+	for _, row := range page {
+		if err := insertSyntheticEvent(tx, OrigemResync, tabela, "U", nodeID, m.pkOf(row), row); err != nil {
+			return false, err
+		}
+	}
+	lastJSON, _ := json.Marshal(m.pkOf(page[len(page)-1]))
+
+	_, err = tx.Exec(`
+		UPDATE SYNC_RESYNC
+		SET ULTIMA_PK = ?, TOTAL = TOTAL + ?, STATUS = ?, ERRO_MSG = NULL, DT_ATUALIZACAO = CURRENT_TIMESTAMP
+		WHERE ID = ?
+	`, string(lastJSON), len(page), status, jobID)
+	if err != nil {
+		return false, fmt.Errorf("erro ao gravar progresso da carga: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("erro ao comitar página da carga: %w", err)
+	}
+	return status == ResyncDone, nil
+}
+
+// insertSyntheticEvent grava um evento gerado pelo agente (carga ou reparo), não pela
+// trigger. Para todos os destinos ele entra como pendente ('P') e o Poller despacha;
+// para um nó só, já entra despachado com o destino criado.
+func insertSyntheticEvent(tx *sql.Tx, origem, tabela, operacao, nodeID string, pk, payload map[string]interface{}) error {
+	eventID := uuid.New().String()
+	pkJSON, _ := json.Marshal(pk)
+	var payloadJSON interface{}
+	if payload != nil {
+		data, _ := json.Marshal(payload)
+		payloadJSON = string(data)
+	}
+
+	status := "P"
+	if nodeID != ResyncAllNodes {
+		status = "D"
+	}
+	_, err := tx.Exec(`
+		INSERT INTO FILA_INTEGRACAO (EVENT_ID, TABELA, OPERACAO, PK_JSON, PAYLOAD_JSON, ORIGEM, STATUS, TENTATIVAS, DT_EVENTO)
+		VALUES (?, ?, ?, ?, ?, ?, ?, 0, CURRENT_TIMESTAMP)
+	`, eventID, tabela, operacao, string(pkJSON), payloadJSON, origem, status)
+	if err != nil {
+		return fmt.Errorf("erro ao inserir na fila: %w", err)
+	}
+	if nodeID == ResyncAllNodes {
+		return nil
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO FILA_DESTINOS (FILA_ID, NODE_ID, STATUS)
+		SELECT ID, ?, 'P' FROM FILA_INTEGRACAO WHERE EVENT_ID = ?
+	`, nodeID, eventID)
+	if err != nil {
+		return fmt.Errorf("erro ao criar destino: %w", err)
+	}
+	return nil
+}
+
+// GetResyncJob retorna o progresso de uma carga
+func (q *QueueManager) GetResyncJob(id int64) (*ResyncJob, error) {
+	jobs, err := q.queryResyncJobs("WHERE ID = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	if len(jobs) == 0 {
+		return nil, fmt.Errorf("carga %d não encontrada", id)
+	}
+	return jobs[0], nil
+}
+
+// GetResyncJobs lista as cargas mais recentes
+func (q *QueueManager) GetResyncJobs(limit int) ([]*ResyncJob, error) {
+	return q.queryResyncJobs(fmt.Sprintf("ORDER BY ID DESC ROWS %d", limit))
+}
+
+func (q *QueueManager) queryResyncJobs(clause string, args ...interface{}) ([]*ResyncJob, error) {
+	rows, err := q.db.Query(`
+		SELECT ID, TRIM(TABELA), TRIM(NODE_ID), STATUS, ULTIMA_PK, TOTAL, ERRO_MSG, DT_INICIO, DT_ATUALIZACAO
+		FROM SYNC_RESYNC `+clause, args...)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao consultar cargas: %w", err)
+	}
+	defer rows.Close()
+
+	jobs := []*ResyncJob{}
+	for rows.Next() {
+		j, err := scanResyncJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, rows.Err()
+}
+
+// scanResyncJob lê uma linha com as colunas de queryResyncJobs
+func scanResyncJob(rows *sql.Rows) (*ResyncJob, error) {
+	j := &ResyncJob{}
+	var ultimaPK, erroMsg sql.NullString
+	if err := rows.Scan(&j.ID, &j.Tabela, &j.NodeID, &j.Status, &ultimaPK, &j.Total, &erroMsg, &j.DTInicio, &j.DTAtualizacao); err != nil {
+		return nil, err
+	}
+	j.UltimaPK = ultimaPK.String
+	j.ErroMsg = erroMsg.String
+	return j, nil
+}
+
+// payloadValue deixa o valor lido do banco no mesmo formato gerado pela trigger
+func payloadValue(c ColumnInfo, v interface{}) interface{} {
+	switch val := v.(type) {
+	case nil:
+		return nil
+	case []byte:
+		v = string(val)
+	case time.Time:
+		switch c.FieldType {
+		case FieldDate:
+			return val.Format("2006-01-02")
+		case FieldTime:
+			return val.Format("15:04:05.0000")
+		default:
+			return val.Format("2006-01-02T15:04:05.0000")
+		}
+	}
+
+	if s, ok := v.(string); ok {
+		if c.FieldType == FieldChar {
+			return strings.TrimRight(s, " ")
+		}
+		return s
+	}
+	// NUMERIC/DECIMAL chegam como decimal do driver: mantém a precisão como número JSON
+	if c.Scale != 0 && (c.FieldType == FieldSmallint || c.FieldType == FieldInteger || c.FieldType == FieldBigint) {
+		return json.Number(fmt.Sprint(v))
+	}
+	return v
+}
@@ -0,0 +1,150 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// LookupRow lê uma linha pela PK, com os valores convertidos para o tipo de cada
+// coluna. Retorna nil se a linha não existir.
+func (q *QueueManager) LookupRow(table string, pk map[string]interface{}) (map[string]interface{}, error) {
+	table = strings.ToUpper(table)
+	pkCols, err := GetPKColumns(q.db, table)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao buscar PK de %s: %w", table, err)
+	}
+	if len(pkCols) == 0 {
+		return nil, fmt.Errorf("tabela %s inexistente ou sem PK", table)
+	}
+
+	colInfos, err := q.schema.Columns(table)
+	if err != nil {
+		return nil, err
+	}
+	colMap := make(map[string]ColumnInfo)
+	for _, c := range colInfos {
+		colMap[c.Name] = c
+	}
+
+	pkValues := make(map[string]interface{})
+	for _, col := range pkCols {
+		v, ok := lookupKey(pk, col)
+		if !ok {
+			return nil, fmt.Errorf("valor da PK %s não informado", col)
+		}
+		coerced, err := colMap[col].Coerce(v)
+		if err != nil {
+			return nil, fmt.Errorf("PK %s: %w", col, err)
+		}
+		pkValues[col] = coerced
+	}
+
+	return q.fetchRow(table, pkCols, pkValues)
+}
+
+// fetchRow executa o SELECT da linha pela PK, com BLOBs no formato do payload
+func (q *QueueManager) fetchRow(table string, pkCols []string, pkValues map[string]interface{}) (map[string]interface{}, error) {
+	whereClauses := []string{}
+	args := []interface{}{}
+	for _, col := range pkCols {
+		whereClauses = append(whereClauses, fmt.Sprintf("%s = ?", QuoteIdent(col)))
+		args = append(args, pkValues[col])
+	}
+
+	query := fmt.Sprintf("SELECT * FROM %s WHERE %s", QuoteIdent(strings.ToUpper(table)), strings.Join(whereClauses, " AND "))
+
+	rows, err := q.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+
+	cols, _ := rows.Columns()
+	values := make([]interface{}, len(cols))
+	valuePtrs := make([]interface{}, len(cols))
+	for i := range values {
+		valuePtrs[i] = &values[i]
+	}
+
+	if err := rows.Scan(valuePtrs...); err != nil {
+		return nil, err
+	}
+
+	blobCols := make(map[string]ColumnInfo)
+	if colInfos, err := q.schema.Columns(table); err == nil {
+		for _, c := range colInfos {
+			if c.IsBlob() {
+				blobCols[c.Name] = c
+			}
+		}
+	}
+
+	result := make(map[string]interface{})
+	for i, col := range cols {
+		val := values[i]
+		if info, isBlob := blobCols[col]; isBlob {
+			result[col] = encodeBlob(info, val)
+		} else if b, ok := val.([]byte); ok {
+			result[col] = string(b)
+		} else {
+			result[col] = val
+		}
+	}
+
+	return result, nil
+}
+
+// lookupKey busca a chave ignorando maiúsculas/minúsculas (payloads manuais)
+func lookupKey(m map[string]interface{}, key string) (interface{}, bool) {
+	if v, ok := m[key]; ok {
+		return v, true
+	}
+	for k, v := range m {
+		if strings.EqualFold(k, key) {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// PayloadRow lê a linha pela PK no formato do payload das triggers (sem BLOBs).
+// Retorna nil se a linha não existir.
+func (q *QueueManager) PayloadRow(table string, pk map[string]interface{}) (map[string]interface{}, error) {
+	m, err := q.tableMeta(table)
+	if err != nil {
+		return nil, err
+	}
+	return q.readPayloadRow(q.db, m, pk)
+}
+
+// PayloadRowTx é PayloadRow lendo dentro da transação (o estado que ela enxerga)
+func (q *QueueManager) PayloadRowTx(tx *sql.Tx, table string, pk map[string]interface{}) (map[string]interface{}, error) {
+	m, err := q.tableMeta(table)
+	if err != nil {
+		return nil, err
+	}
+	return q.readPayloadRow(tx, m, pk)
+}
+
+func (q *QueueManager) readPayloadRow(qr queryer, m *tableMeta, pk map[string]interface{}) (map[string]interface{}, error) {
+	values, err := m.coercePK(pk)
+	if err != nil {
+		return nil, err
+	}
+	where := make([]string, len(m.pkCols))
+	for i, col := range m.pkCols {
+		where[i] = QuoteIdent(col) + " = ?"
+	}
+
+	var row map[string]interface{}
+	err = q.scanWhere(qr, m, m.payload, where, values, 1, func(r map[string]interface{}) error {
+		row = r
+		return nil
+	})
+	return row, err
+}
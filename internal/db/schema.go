@@ -0,0 +1,55 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// SchemaCache mantém em memória os metadados de colunas por tabela,
+// evitando consultar o catálogo a cada evento
+type SchemaCache struct {
+	db      *sql.DB
+	mu      sync.RWMutex
+	columns map[string][]ColumnInfo
+}
+
+func NewSchemaCache(db *sql.DB) *SchemaCache {
+	return &SchemaCache{
+		db:      db,
+		columns: make(map[string][]ColumnInfo),
+	}
+}
+
+// Columns retorna as colunas da tabela (carregando do catálogo na primeira chamada)
+func (s *SchemaCache) Columns(table string) ([]ColumnInfo, error) {
+	key := strings.ToUpper(table)
+
+	s.mu.RLock()
+	cols, ok := s.columns[key]
+	s.mu.RUnlock()
+	if ok {
+		return cols, nil
+	}
+
+	cols, err := GetColumns(s.db, key)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao ler metadados de %s: %w", key, err)
+	}
+	if len(cols) == 0 {
+		return nil, fmt.Errorf("tabela %s não encontrada no catálogo", key)
+	}
+
+	s.mu.Lock()
+	s.columns[key] = cols
+	s.mu.Unlock()
+	return cols, nil
+}
+
+// Invalidate descarta os metadados em cache (ex.: após ALTER TABLE)
+func (s *SchemaCache) Invalidate(table string) {
+	s.mu.Lock()
+	delete(s.columns, strings.ToUpper(table))
+	s.mu.Unlock()
+}
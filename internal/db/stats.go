@@ -0,0 +1,120 @@
+package db
+
+// QueueStatus resume a fila local: eventos por status e destinos por nó/status
+type QueueStatus struct {
+	Eventos  map[string]int            `json:"eventos"`  // FILA_INTEGRACAO: STATUS -> quantidade
+	Destinos map[string]map[string]int `json:"destinos"` // FILA_DESTINOS: NODE_ID -> STATUS -> quantidade
+}
+
+// TablePending é o volume pendente de uma tabela
+type TablePending struct {
+	Tabela             string `json:"tabela"`
+	AguardandoDespacho int    `json:"aguardando_despacho"` // Eventos ainda sem destinos criados (P/R)
+	AguardandoEnvio    int    `json:"aguardando_envio"`    // Destinos ainda não confirmados (P/R/I)
+	Falhas             int    `json:"falhas"`              // Destinos em falha definitiva (F)
+}
+
+func (q *QueueManager) GetQueueStatus() (*QueueStatus, error) {
+	status := &QueueStatus{
+		Eventos:  make(map[string]int),
+		Destinos: make(map[string]map[string]int),
+	}
+
+	rows, err := q.db.Query("SELECT STATUS, COUNT(*) FROM FILA_INTEGRACAO GROUP BY STATUS")
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var st string
+		var n int
+		if err := rows.Scan(&st, &n); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		status.Eventos[st] = n
+	}
+	rows.Close()
+
+	rows, err = q.db.Query("SELECT NODE_ID, STATUS, COUNT(*) FROM FILA_DESTINOS GROUP BY NODE_ID, STATUS")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var nodeID, st string
+		var n int
+		if err := rows.Scan(&nodeID, &st, &n); err != nil {
+			return nil, err
+		}
+		if status.Destinos[nodeID] == nil {
+			status.Destinos[nodeID] = make(map[string]int)
+		}
+		status.Destinos[nodeID][st] = n
+	}
+	return status, rows.Err()
+}
+
+// GetPendingByTable conta, por tabela, o que ainda não chegou aos destinos
+func (q *QueueManager) GetPendingByTable() ([]TablePending, error) {
+	byTable := make(map[string]*TablePending)
+	var order []string
+	get := func(tabela string) *TablePending {
+		if t, ok := byTable[tabela]; ok {
+			return t
+		}
+		t := &TablePending{Tabela: tabela}
+		byTable[tabela] = t
+		order = append(order, tabela)
+		return t
+	}
+
+	rows, err := q.db.Query(`
+		SELECT TABELA, COUNT(*) FROM FILA_INTEGRACAO
+		WHERE STATUS IN ('P', 'R')
+		GROUP BY TABELA`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var tabela string
+		var n int
+		if err := rows.Scan(&tabela, &n); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		get(tabela).AguardandoDespacho = n
+	}
+	rows.Close()
+
+	rows, err = q.db.Query(`
+		SELECT f.TABELA, d.STATUS, COUNT(*)
+		FROM FILA_DESTINOS d
+		JOIN FILA_INTEGRACAO f ON d.FILA_ID = f.ID
+		WHERE d.STATUS IN ('P', 'R', 'I', 'F')
+		GROUP BY f.TABELA, d.STATUS`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var tabela, st string
+		var n int
+		if err := rows.Scan(&tabela, &st, &n); err != nil {
+			return nil, err
+		}
+		if st == "F" {
+			get(tabela).Falhas += n
+		} else {
+			get(tabela).AguardandoEnvio += n
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	result := make([]TablePending, 0, len(order))
+	for _, tabela := range order {
+		result = append(result, *byTable[tabela])
+	}
+	return result, nil
+}
@@ -0,0 +1,114 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ParseTransactionID converte o TransID do trace ("123") no número da transação
+// (0 se não for numérico)
+func ParseTransactionID(transID string) int64 {
+	id, err := strconv.ParseInt(strings.TrimSpace(transID), 10, 64)
+	if err != nil || id < 0 {
+		return 0
+	}
+	return id
+}
+
+// transactionChunk limita os IDs por consulta (o Firebird aceita até 1500 itens num IN)
+const transactionChunk = 500
+
+// CompleteTransactions ajusta uma leva de envio às transações de origem: traz os
+// destinos das mesmas transações que ficaram fora do limite da leva e adia, por nó,
+// as transações que ainda não estão inteiras (evento não despachado ou destino
+// aguardando reenvio). Assim o destino nunca recebe só parte de uma transação.
+func (q *QueueManager) CompleteTransactions(dests []*FilaDestino) ([]*FilaDestino, error) {
+	result := make([]*FilaDestino, 0, len(dests))
+	var ids []int64
+	seen := make(map[int64]bool)
+	for _, d := range dests {
+		t := d.Item.TransacaoID
+		if t == 0 {
+			result = append(result, d)
+			continue
+		}
+		if !seen[t] {
+			seen[t] = true
+			ids = append(ids, t)
+		}
+	}
+
+	for start := 0; start < len(ids); start += transactionChunk {
+		end := start + transactionChunk
+		if end > len(ids) {
+			end = len(ids)
+		}
+		group, err := q.transactionDestinations(ids[start:end])
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, group...)
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result, nil
+}
+
+// transactionDestinations retorna numa só consulta os destinos pendentes das
+// transações de origem, omitindo as que o Poller ainda não despachou por inteiro e,
+// por nó, as que têm algum evento aguardando reenvio
+func (q *QueueManager) transactionDestinations(transacaoIDs []int64) ([]*FilaDestino, error) {
+	marks := make([]string, len(transacaoIDs))
+	args := make([]interface{}, len(transacaoIDs))
+	for i, id := range transacaoIDs {
+		marks[i] = "?"
+		args[i] = id
+	}
+
+	rows, err := q.db.Query(`
+		SELECT `+destinoColumns+`,
+		       CASE WHEN d.NEXT_ATTEMPT_AT > CURRENT_TIMESTAMP THEN 1 ELSE 0 END
+		FROM FILA_DESTINOS d
+		JOIN FILA_INTEGRACAO f ON d.FILA_ID = f.ID
+		WHERE f.TRANSACAO_ID IN (`+strings.Join(marks, ", ")+`) AND d.STATUS IN ('P', 'R', 'I')
+		  AND NOT EXISTS (
+		      SELECT 1 FROM FILA_INTEGRACAO u
+		      WHERE u.TRANSACAO_ID = f.TRANSACAO_ID AND u.STATUS IN ('P', 'R'))
+		ORDER BY d.ID ASC
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao consultar destinos de %d transações: %w", len(transacaoIDs), err)
+	}
+	defer rows.Close()
+
+	type nodeTransaction struct {
+		transacaoID int64
+		nodeID      string
+	}
+	var all []*FilaDestino
+	waiting := make(map[nodeTransaction]bool) // Transações com algum evento aguardando reenvio no nó
+	for rows.Next() {
+		var wait int
+		d, err := scanDestino(rows, &wait)
+		if err != nil {
+			return nil, err
+		}
+		if wait == 1 {
+			waiting[nodeTransaction{d.Item.TransacaoID, d.NodeID}] = true
+		}
+		all = append(all, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	group := all[:0]
+	for _, d := range all {
+		if !waiting[nodeTransaction{d.Item.TransacaoID, d.NodeID}] {
+			group = append(group, d)
+		}
+	}
+	return group, nil
+}
@@ -0,0 +1,210 @@
+package db
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Origens gravadas em FILA_INTEGRACAO.ORIGEM para eventos gerados neste nó
+const (
+	OrigemTrigger    = "TRIGGER"    // Capturado pela trigger
+	OrigemResync     = "RESYNC"     // Carga inicial (resync)
+	OrigemReconcilia = "RECONCILIA" // Reparo da reconciliação por checksum
+)
+
+// IsSyntheticOrigin indica eventos gerados pelo agente para impor o estado local
+// (carga e reparo): não disputam conflito no destino
+func IsSyntheticOrigin(origem string) bool {
+	return origem == OrigemResync || origem == OrigemReconcilia
+}
+
+// RowVersion é a última alteração conhecida de uma linha: o evento que a produziu
+// (local ou recebido), o nó de origem e quando aconteceu
+type RowVersion struct {
+	EventID  string
+	Origem   string
+	DTEvento time.Time
+}
+
+// rowVersionKey é a chave da linha em SYNC_VERSOES: a PK serializada com as chaves em
+// ordem, ou o hash dela quando não cabe na coluna
+func rowVersionKey(pk map[string]interface{}) string {
+	data, _ := json.Marshal(pk)
+	if len(data) > 250 {
+		sum := sha256.Sum256(data)
+		return "#" + hex.EncodeToString(sum[:])
+	}
+	return string(data)
+}
+
+// GetRowVersionTx retorna a versão atual da linha (nil se desconhecida). Eventos locais
+// ainda não despachados pelo Poller também contam, para não perder uma alteração
+// feita segundos antes de o evento concorrente chegar.
+func (q *QueueManager) GetRowVersionTx(tx *sql.Tx, tabela string, pk map[string]interface{}) (*RowVersion, error) {
+	tabela = strings.ToUpper(tabela)
+
+	// PK_JSON é texto livre (trigger, trace ou agente): o filtro por valor só reduz os
+	// candidatos, e a comparação exata da PK é feita abaixo
+	filter, args := pkContaining(pk)
+	rows, err := tx.Query(`
+		SELECT EVENT_ID, PK_JSON, DT_EVENTO FROM FILA_INTEGRACAO
+		WHERE STATUS = 'P' AND TABELA = ? AND ORIGEM NOT IN (?, ?)`+filter+`
+		ORDER BY ID DESC
+	`, append([]interface{}{tabela, OrigemResync, OrigemReconcilia}, args...)...)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao consultar eventos locais pendentes: %w", err)
+	}
+	key := RowKey("", tabela, rowVersionKey(pk))
+	for rows.Next() {
+		var eventID, pkJSON string
+		var dt time.Time
+		if err := rows.Scan(&eventID, &pkJSON, &dt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if RowKey("", tabela, pkJSON) == key {
+			rows.Close()
+			return &RowVersion{EventID: strings.TrimSpace(eventID), Origem: q.nodeID, DTEvento: dt}, nil
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	v := &RowVersion{}
+	err = tx.QueryRow("SELECT EVENT_ID, ORIGEM, DT_EVENTO FROM SYNC_VERSOES WHERE TABELA = ? AND PK_KEY = ?", tabela, rowVersionKey(pk)).
+		Scan(&v.EventID, &v.Origem, &v.DTEvento)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("erro ao consultar versão da linha: %w", err)
+	}
+	v.EventID = strings.TrimSpace(v.EventID)
+	v.Origem = strings.TrimSpace(v.Origem)
+	return v, nil
+}
+
+// pkContaining monta condições "PK_JSON CONTAINING ?" com os valores da PK que
+// aparecem iguais em qualquer serialização: inteiros e textos sem caracteres escapados
+// no JSON. Os demais (decimais, datas) ficam sem filtro.
+func pkContaining(pk map[string]interface{}) (string, []interface{}) {
+	cols := make([]string, 0, len(pk))
+	for col := range pk {
+		cols = append(cols, col)
+	}
+	sort.Strings(cols)
+
+	var sqlPart strings.Builder
+	var args []interface{}
+	for _, col := range cols {
+		var text string
+		switch val := pk[col].(type) {
+		case json.Number:
+			if _, err := val.Int64(); err != nil {
+				continue
+			}
+			text = val.String()
+		case int, int32, int64:
+			text = fmt.Sprint(val)
+		case float64:
+			if val != math.Trunc(val) || math.Abs(val) > 1<<53 {
+				continue
+			}
+			text = strconv.FormatInt(int64(val), 10)
+		case string:
+			text = strings.TrimSpace(val)
+			if text == "" || strings.ContainsAny(text, "\"\\") || strings.IndexFunc(text, func(r rune) bool { return r < 0x20 }) >= 0 {
+				continue
+			}
+		default:
+			continue
+		}
+		sqlPart.WriteString(" AND PK_JSON CONTAINING ?")
+		args = append(args, text)
+	}
+	return sqlPart.String(), args
+}
+
+// SetRowVersionTx registra o evento como versão atual da linha
+func SetRowVersionTx(tx *sql.Tx, tabela string, pk map[string]interface{}, v RowVersion) error {
+	_, err := tx.Exec(`
+		UPDATE OR INSERT INTO SYNC_VERSOES (TABELA, PK_KEY, EVENT_ID, ORIGEM, DT_EVENTO)
+		VALUES (?, ?, ?, ?, ?)
+		MATCHING (TABELA, PK_KEY)
+	`, strings.ToUpper(tabela), rowVersionKey(pk), v.EventID, v.Origem, v.DTEvento)
+	if err != nil {
+		return fmt.Errorf("erro ao registrar versão da linha: %w", err)
+	}
+	return nil
+}
+
+// StampLocalVersion registra um evento local como nova versão da linha e grava em
+// VERSAO_BASE a versão anterior, que segue com o evento para o destino detectar
+// se alterou a linha nesse meio tempo. Chamado no despacho pelo Poller.
+func (q *QueueManager) StampLocalVersion(item *FilaItem) error {
+	if IsSyntheticOrigin(item.Origem) {
+		return nil
+	}
+	var pk map[string]interface{}
+	decoder := json.NewDecoder(strings.NewReader(item.PKJSON))
+	decoder.UseNumber()
+	if err := decoder.Decode(&pk); err != nil {
+		return fmt.Errorf("PK_JSON inválido: %w", err)
+	}
+
+	tx, err := q.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var base string
+	err = tx.QueryRow("SELECT EVENT_ID FROM SYNC_VERSOES WHERE TABELA = ? AND PK_KEY = ?", strings.ToUpper(item.Tabela), rowVersionKey(pk)).Scan(&base)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("erro ao consultar versão da linha: %w", err)
+	}
+	if err := SetRowVersionTx(tx, item.Tabela, pk, RowVersion{EventID: item.EventID, Origem: q.nodeID, DTEvento: item.DTEvento}); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE FILA_INTEGRACAO SET VERSAO_BASE = ? WHERE ID = ?", nullIfEmpty(strings.TrimSpace(base)), item.ID); err != nil {
+		return fmt.Errorf("erro ao gravar versão base: %w", err)
+	}
+	item.VersaoBase = strings.TrimSpace(base)
+	return tx.Commit()
+}
+
+// EventPayloadTx retorna o PAYLOAD_JSON de um evento registrado neste nó (nil se não existir)
+func EventPayloadTx(tx *sql.Tx, eventID string) (map[string]interface{}, error) {
+	var raw sql.NullString
+	err := tx.QueryRow("SELECT PAYLOAD_JSON FROM FILA_INTEGRACAO WHERE EVENT_ID = ?", eventID).Scan(&raw)
+	if err == sql.ErrNoRows || (err == nil && !raw.Valid) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var payload map[string]interface{}
+	decoder := json.NewDecoder(strings.NewReader(triggerJSON(raw.String)))
+	decoder.UseNumber()
+	if err := decoder.Decode(&payload); err != nil {
+		return nil, fmt.Errorf("PAYLOAD_JSON inválido no evento %s: %w", eventID, err)
+	}
+	return payload, nil
+}
+
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,47 @@
+package db
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestPKContaining(t *testing.T) {
+	tests := []struct {
+		name     string
+		pk       map[string]interface{}
+		want     string
+		wantArgs []interface{}
+	}{
+		{
+			name:     "inteiro vindo do json",
+			pk:       map[string]interface{}{"CODIGO": json.Number("42")},
+			want:     " AND PK_JSON CONTAINING ?",
+			wantArgs: []interface{}{"42"},
+		},
+		{
+			name:     "pk composta em ordem de coluna",
+			pk:       map[string]interface{}{"ITEM": "A1 ", "PEDIDO": float64(7)},
+			want:     " AND PK_JSON CONTAINING ? AND PK_JSON CONTAINING ?",
+			wantArgs: []interface{}{"A1", "7"},
+		},
+		{
+			name:     "decimal e texto escapado ficam sem filtro",
+			pk:       map[string]interface{}{"A": json.Number("1.50"), "B": `D"AVILA`, "C": int64(3)},
+			want:     " AND PK_JSON CONTAINING ?",
+			wantArgs: []interface{}{"3"},
+		},
+		{
+			name: "sem valores filtráveis",
+			pk:   map[string]interface{}{"DATA": nil, "NOME": ""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, args := pkContaining(tt.pk)
+			if got != tt.want || !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("pkContaining = %q %v, esperado %q %v", got, args, tt.want, tt.wantArgs)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+// BlobType é o marcador de tipo usado para colunas BLOB no payload
+const BlobType = "blob"
+
+// BlobValue representa uma coluna BLOB trafegada em base64 dentro do JSON
+type BlobValue struct {
+	Type    string `json:"$type"`
+	SubType int    `json:"subtype"` // 0 = binário, 1 = texto
+	Base64  string `json:"base64"`
+}
+
+// NewBlobValue codifica o conteúdo de um BLOB para o payload
+func NewBlobValue(subType int, data []byte) BlobValue {
+	return BlobValue{
+		Type:    BlobType,
+		SubType: subType,
+		Base64:  base64.StdEncoding.EncodeToString(data),
+	}
+}
+
+// DecodeBlob verifica se o valor (já decodificado do JSON) é um BlobValue e
+// devolve o conteúdo original. ok é false para valores que não são BLOB.
+func DecodeBlob(v interface{}) (data []byte, subType int, ok bool, err error) {
+	m, isMap := v.(map[string]interface{})
+	if !isMap || m["$type"] != BlobType {
+		return nil, 0, false, nil
+	}
+
+	switch st := m["subtype"].(type) {
+	case float64:
+		subType = int(st)
+	case json.Number:
+		n, _ := st.Int64()
+		subType = int(n)
+	}
+	encoded, _ := m["base64"].(string)
+	data, err = base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, subType, true, fmt.Errorf("base64 inválido: %w", err)
+	}
+	return data, subType, true, nil
+}
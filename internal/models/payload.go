@@ -11,5 +11,8 @@ type SyncPayload struct {
 	PayloadJSON map[string]interface{} `json:"data"`
 	Origem      string                 `json:"source_node"`
 	Timestamp   time.Time              `json:"timestamp"`
+	BaseVersion string                 `json:"base_version,omitempty"`   // Versão da linha que o emissor conhecia antes da alteração
+	Transaction int64                  `json:"transaction_id,omitempty"` // Transação de origem: eventos com o mesmo valor são aplicados juntos
+	Attempt     int                    `json:"attempt,omitempty"`        // Tentativas anteriores deste envio (0 = primeira)
 	RemoteAddr  string                 `json:"-"`
 }
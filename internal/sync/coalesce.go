@@ -0,0 +1,120 @@
+package sync
+
+import (
+	"log"
+
+	"github.com/atsinformatica/firebird-sync-agent/internal/db"
+)
+
+// coalesce junta, nas tabelas de integracao.coalesce_tables, os eventos da leva que
+// alteram a mesma linha: só o último (que já traz a linha inteira) segue, com a
+// operação ajustada, e os anteriores ficam com status 'S' para auditoria. Inserção
+// seguida de exclusão não envia nada. Um evento só é absorvido se isso não quebrar
+// sua transação de origem (mesma transação do mantido ou evento sozinho nela).
+// Retorna a leva sem os eventos absorvidos.
+func (p *Poller) coalesce(items []*db.FilaItem) []*db.FilaItem {
+	plans := planCoalesce(items, p.cfg().CoalesceTable, p.queue.TransactionSize)
+
+	dropped := make(map[int64]bool)
+	for _, plan := range plans {
+		first := plan.absorbed[0]
+		if err := p.queue.SupersedeEvents(plan.keep, plan.operacao, plan.absorbed); err != nil {
+			log.Printf("[POLLER] Erro ao juntar eventos de %s %s: %v", first.Tabela, first.PKJSON, err)
+			continue
+		}
+		for _, item := range plan.absorbed {
+			dropped[item.ID] = true
+		}
+		if plan.keep == nil {
+			log.Printf("[POLLER] %d evento(s) de %s %s anulados: inserção e exclusão antes do envio", len(plan.absorbed), first.Tabela, first.PKJSON)
+		} else {
+			log.Printf("[POLLER] %d evento(s) de %s %s substituídos pelo ID %d", len(plan.absorbed), plan.keep.Tabela, plan.keep.PKJSON, plan.keep.ID)
+		}
+	}
+
+	if len(dropped) == 0 {
+		return items
+	}
+	kept := make([]*db.FilaItem, 0, len(items)-len(dropped))
+	for _, item := range items {
+		if !dropped[item.ID] {
+			kept = append(kept, item)
+		}
+	}
+	return kept
+}
+
+// coalescePlan é a junção de uma linha: keep segue com a operação operacao e os
+// absorbed ficam substituídos (keep nil = todos anulados)
+type coalescePlan struct {
+	keep     *db.FilaItem
+	operacao string
+	absorbed []*db.FilaItem
+}
+
+// planCoalesce decide a junção de cada linha da leva, na ordem do primeiro evento
+// dela, sem gravar nada. transactionSize conta os eventos de uma transação de origem.
+func planCoalesce(items []*db.FilaItem, coalesceTable func(string) bool, transactionSize func(int64) (int, error)) []coalescePlan {
+	var keys []string
+	runs := make(map[string][]*db.FilaItem)
+	for _, item := range items {
+		if item.Status != "P" || db.IsSyntheticOrigin(item.Origem) || !coalesceTable(item.Tabela) {
+			continue
+		}
+		key := db.RowKey("", item.Tabela, item.PKJSON)
+		if _, ok := runs[key]; !ok {
+			keys = append(keys, key)
+		}
+		runs[key] = append(runs[key], item)
+	}
+
+	var plans []coalescePlan
+	sizes := make(map[int64]int) // Eventos por transação de origem
+	for _, key := range keys {
+		run := runs[key]
+		if len(run) < 2 {
+			continue
+		}
+
+		keep := run[len(run)-1]
+		start := len(run) - 1
+		for start > 0 && absorbable(run[start-1], keep, sizes, transactionSize) {
+			start--
+		}
+		absorbed := run[start : len(run)-1]
+		if len(absorbed) == 0 {
+			continue
+		}
+
+		operacao := keep.Operacao
+		if run[start].Operacao == "I" {
+			switch keep.Operacao {
+			case "D":
+				keep, absorbed = nil, run[start:] // A linha nunca chegou ao destino
+				operacao = ""
+			case "U":
+				operacao = "I"
+			}
+		}
+		plans = append(plans, coalescePlan{keep: keep, operacao: operacao, absorbed: absorbed})
+	}
+	return plans
+}
+
+// absorbable indica se o evento pode ser absorvido pelo mantido sem deixar sua
+// transação de origem incompleta no destino
+func absorbable(item, keep *db.FilaItem, sizes map[int64]int, transactionSize func(int64) (int, error)) bool {
+	if item.TransacaoID == 0 || item.TransacaoID == keep.TransacaoID {
+		return true
+	}
+	n, ok := sizes[item.TransacaoID]
+	if !ok {
+		var err error
+		if n, err = transactionSize(item.TransacaoID); err != nil {
+			log.Printf("[POLLER] %v", err)
+			return false
+		}
+		sizes[item.TransacaoID] = n
+	}
+	return n == 1
+}
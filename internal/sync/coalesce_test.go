@@ -0,0 +1,127 @@
+package sync
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/atsinformatica/firebird-sync-agent/internal/db"
+)
+
+func TestPlanCoalesce(t *testing.T) {
+	ev := func(id int64, pk, operacao string, transacaoID int64) *db.FilaItem {
+		return &db.FilaItem{ID: id, Tabela: "PRODUTO", PKJSON: pk, Operacao: operacao, Status: "P", Origem: db.OrigemTrigger, TransacaoID: transacaoID}
+	}
+	// Eventos por transação de origem; 99 não pode ser consultada
+	sizes := map[int64]int{1: 1, 2: 2, 3: 1}
+	transactionSize := func(id int64) (int, error) {
+		if n, ok := sizes[id]; ok {
+			return n, nil
+		}
+		return 0, errors.New("transação não encontrada")
+	}
+
+	type plan struct {
+		keep     int64 // 0 = nada segue
+		operacao string
+		absorbed []int64
+	}
+	tests := []struct {
+		name  string
+		items []*db.FilaItem
+		want  []plan
+	}{
+		{
+			name:  "inserção seguida de alteração vira inserção",
+			items: []*db.FilaItem{ev(1, "A", "I", 0), ev(2, "A", "U", 0)},
+			want:  []plan{{2, "I", []int64{1}}},
+		},
+		{
+			name:  "alterações seguidas ficam na última",
+			items: []*db.FilaItem{ev(1, "A", "U", 0), ev(2, "A", "U", 0), ev(3, "A", "U", 0)},
+			want:  []plan{{3, "U", []int64{1, 2}}},
+		},
+		{
+			name:  "inserção até exclusão não envia nada",
+			items: []*db.FilaItem{ev(1, "A", "I", 0), ev(2, "A", "U", 0), ev(3, "A", "D", 0)},
+			want:  []plan{{0, "", []int64{1, 2, 3}}},
+		},
+		{
+			name:  "alteração seguida de exclusão envia a exclusão",
+			items: []*db.FilaItem{ev(1, "A", "U", 0), ev(2, "A", "D", 0)},
+			want:  []plan{{2, "D", []int64{1}}},
+		},
+		{
+			name:  "linhas diferentes não se juntam",
+			items: []*db.FilaItem{ev(1, "A", "U", 0), ev(2, "B", "U", 0), ev(3, "B", "U", 0)},
+			want:  []plan{{3, "U", []int64{2}}},
+		},
+		{
+			name:  "mesma transação do mantido",
+			items: []*db.FilaItem{ev(1, "A", "I", 2), ev(2, "A", "U", 2)},
+			want:  []plan{{2, "I", []int64{1}}},
+		},
+		{
+			name:  "evento sozinho na transação é absorvido",
+			items: []*db.FilaItem{ev(1, "A", "U", 1), ev(2, "A", "U", 3)},
+			want:  []plan{{2, "U", []int64{1}}},
+		},
+		{
+			name:  "não quebra transação com outros eventos",
+			items: []*db.FilaItem{ev(1, "A", "I", 2), ev(2, "A", "U", 3)},
+		},
+		{
+			name:  "para no primeiro evento que não pode ser absorvido",
+			items: []*db.FilaItem{ev(1, "A", "I", 2), ev(2, "A", "U", 1), ev(3, "A", "U", 0)},
+			want:  []plan{{3, "U", []int64{2}}}, // A inserção fica: a operação não vira I
+		},
+		{
+			name:  "erro ao contar a transação não absorve",
+			items: []*db.FilaItem{ev(1, "A", "U", 99), ev(2, "A", "U", 0)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []plan
+			for _, p := range planCoalesce(tt.items, func(string) bool { return true }, transactionSize) {
+				var pl plan
+				if p.keep != nil {
+					pl.keep = p.keep.ID
+				}
+				pl.operacao = p.operacao
+				for _, item := range p.absorbed {
+					pl.absorbed = append(pl.absorbed, item.ID)
+				}
+				got = append(got, pl)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("planCoalesce = %+v, esperado %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlanCoalesceIgnored(t *testing.T) {
+	item := func(id int64, tabela, status, origem string) *db.FilaItem {
+		return &db.FilaItem{ID: id, Tabela: tabela, PKJSON: "A", Operacao: "U", Status: status, Origem: origem}
+	}
+	onlyProduto := func(tabela string) bool { return tabela == "PRODUTO" }
+	noTransaction := func(int64) (int, error) { return 0, errors.New("não deveria consultar") }
+
+	tests := []struct {
+		name  string
+		items []*db.FilaItem
+	}{
+		{"tabela fora de coalesce_tables", []*db.FilaItem{item(1, "CLIENTE", "P", db.OrigemTrigger), item(2, "CLIENTE", "P", db.OrigemTrigger)}},
+		{"evento em reenvio", []*db.FilaItem{item(1, "PRODUTO", "R", db.OrigemTrigger), item(2, "PRODUTO", "P", db.OrigemTrigger)}},
+		{"evento de resync", []*db.FilaItem{item(1, "PRODUTO", "P", db.OrigemResync), item(2, "PRODUTO", "P", db.OrigemTrigger)}},
+		{"evento de reparo", []*db.FilaItem{item(1, "PRODUTO", "P", db.OrigemTrigger), item(2, "PRODUTO", "P", db.OrigemReconcilia)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if plans := planCoalesce(tt.items, onlyProduto, noTransaction); len(plans) != 0 {
+				t.Errorf("planCoalesce = %+v, esperado nenhuma junção", plans)
+			}
+		})
+	}
+}
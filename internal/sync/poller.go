@@ -4,10 +4,13 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math/rand"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/atsinformatica/firebird-sync-agent/internal/config"
@@ -22,30 +25,50 @@ type WebhookSender interface {
 }
 
 type Poller struct {
-	cfg    *config.Config
+	conf   *config.Store
 	queue  *db.QueueManager
 	sender WebhookSender
 	relay  *webhook.RelayClient
 	ctx    context.Context
 	cancel context.CancelFunc
+
+	relayDown   bool            // Sem conexão com o Hub (só para logar a mudança de estado)
+	batchWarned int             // batch_size acima do lote máximo já avisado no log
+	noBatch     map[string]bool // Nós sem /sync/batch (agente antigo): envio evento a evento
+	unreachable map[string]*nodeRetry
+}
+
+// nodeRetry é o backoff de um nó inacessível. Falha de transporte não é culpa do
+// evento e não conta tentativa nele, mas o nó só volta a ser tentado após o prazo.
+type nodeRetry struct {
+	failures int
+	next     time.Time
 }
 
-func NewPoller(cfg *config.Config, queue *db.QueueManager, sender WebhookSender, relay *webhook.RelayClient) *Poller {
-	return &Poller{
-		cfg:    cfg,
+func NewPoller(conf *config.Store, queue *db.QueueManager, sender WebhookSender, relay *webhook.RelayClient) *Poller {
+	p := &Poller{
+		conf:   conf,
 		queue:  queue,
 		sender: sender,
 		relay:  relay,
+
+		noBatch:     make(map[string]bool),
+		unreachable: make(map[string]*nodeRetry),
 	}
+	if relay != nil {
+		relay.SetAckHandler(p.handleAck)
+	}
+	return p
+}
+
+func (p *Poller) cfg() *config.Config {
+	return p.conf.Get()
 }
 
 func (p *Poller) Start(ctx context.Context) {
 	p.ctx, p.cancel = context.WithCancel(ctx)
 
-	interval := p.cfg.Integracao.RetryIntervalSeconds
-	if interval <= 0 {
-		interval = 5
-	}
+	interval := p.retryInterval()
 	ticker := time.NewTicker(time.Duration(interval) * time.Second)
 	defer ticker.Stop()
 
@@ -62,47 +85,78 @@ func (p *Poller) Start(ctx context.Context) {
 	}
 }
 
+// processQueue despacha e envia. Com atraso acumulado (ex: loja que passou a noite
+// offline) repete as levas sem esperar o próximo tick.
 func (p *Poller) processQueue() {
-	p.dispatchEvents()
-	p.sendEvents()
+	for p.dispatchEvents() && p.ctx.Err() == nil {
+	}
+	for p.sendEvents() && p.ctx.Err() == nil {
+	}
 }
 
-func (p *Poller) dispatchEvents() {
-	items, err := p.queue.GetPending(p.cfg.Integracao.BatchSize)
+// dispatchEvents cria os destinos de uma leva de eventos capturados. Retorna true
+// quando a leva veio cheia (há mais eventos esperando) e andou: se nenhum evento
+// saiu de 'P' (erro no banco), a próxima leva seria a mesma.
+func (p *Poller) dispatchEvents() bool {
+	items, err := p.queue.GetPending(p.batchSize())
 	if err != nil {
 		log.Printf("[POLLER] Erro ao buscar pendências para despacho: %v", err)
-		return
+		return false
 	}
 
 	if len(items) == 0 {
-		return
+		return false
 	}
 
 	nodes, err := p.queue.GetActiveNodes()
 	if err != nil {
 		log.Printf("[POLLER] Erro ao buscar nós ativos: %v", err)
-		return
+		return false
 	}
 
+	fetched := len(items)
+	items = p.coalesce(items)
+	progressed := len(items) < fetched // Absorvidos pela junção já saíram de 'P'
+
 	for _, item := range items {
+		// A versão anterior da linha segue com o evento para o destino detectar conflitos
+		if err := p.queue.StampLocalVersion(item); err != nil {
+			log.Printf("[POLLER] Erro ao registrar versão do ID %d: %v", item.ID, err)
+			continue
+		}
+
 		err := p.queue.CreateDestinations(item.ID, nodes, item.Origem)
 		if err != nil {
 			log.Printf("[POLLER] Erro ao criar destinos para ID %d: %v", item.ID, err)
 			continue
 		}
-		p.queue.UpdateStatus(item.ID, "D", "Evento despachado para nós ativos")
+		if err := p.queue.UpdateStatus(item.ID, "D", "Evento despachado para nós ativos"); err != nil {
+			log.Printf("[POLLER] Erro ao marcar ID %d como despachado: %v", item.ID, err)
+			continue
+		}
+		progressed = true
 	}
+	return progressed && fetched >= p.batchSize()
 }
 
-func (p *Poller) sendEvents() {
-	dests, err := p.queue.GetPendingDestinations(p.cfg.Integracao.BatchSize)
+// sendEvents envia uma leva de destinos pendentes. Retorna true quando a leva veio
+// cheia e andou, sinal de que há atraso a recuperar.
+func (p *Poller) sendEvents() bool {
+	dests, err := p.queue.GetPendingDestinations(p.batchSize())
 	if err != nil {
 		log.Printf("[POLLER] Erro ao buscar tarefas de envio: %v", err)
-		return
+		return false
 	}
 
 	if len(dests) == 0 {
-		return
+		return false
+	}
+
+	// Completa as transações de origem cortadas pelo limite da leva
+	fetched := len(dests)
+	if dests, err = p.queue.CompleteTransactions(dests); err != nil {
+		log.Printf("[POLLER] Erro ao agrupar transações de origem: %v", err)
+		return false
 	}
 
 	nodeTasks := make(map[string][]*db.FilaDestino)
@@ -116,38 +170,58 @@ func (p *Poller) sendEvents() {
 		nodeURLs[n.NodeID] = n.RemoteURL
 	}
 
+	// Ordem garantida por linha (tabela + PK): um evento só segue se nenhum evento
+	// anterior da mesma linha para o mesmo nó estiver aguardando reenvio
+	blocked, err := p.queue.GetBlockedRows()
+	if err != nil {
+		log.Printf("[POLLER] Erro ao buscar linhas bloqueadas: %v", err)
+		return false
+	}
+
+	// Sem conexão com o Hub não há para onde enviar. Um nó apenas offline no Hub
+	// continua recebendo: o Hub guarda a mensagem e responde "queued".
+	if p.cfg().Relay.Enabled && p.relay != nil {
+		if !p.relay.Connected() {
+			if !p.relayDown {
+				log.Printf("[POLLER] Sem conexão com o Relay. Envios pausados")
+				p.relayDown = true
+			}
+			return false
+		}
+		if p.relayDown {
+			log.Printf("[POLLER] Conexão com o Relay restabelecida. Retomando envios")
+			p.relayDown = false
+		}
+	}
+
+	sent := 0
 	for nodeID, tasks := range nodeTasks {
 		remoteURL := nodeURLs[nodeID]
-		if remoteURL == "" && !p.cfg.Relay.Enabled {
+		if remoteURL == "" && !p.cfg().Relay.Enabled {
 			continue
 		}
 
-		for _, task := range tasks {
-			item := task.Item
-
-			var pkMap map[string]interface{}
-			var payloadMap map[string]interface{}
+		if r := p.unreachable[nodeID]; r != nil && time.Now().Before(r.next) {
+			continue
+		}
 
-			json.Unmarshal([]byte(item.PKJSON), &pkMap)
-			if len(item.PayloadJSON) > 0 {
-				json.Unmarshal([]byte(item.PayloadJSON), &payloadMap)
+		// Eventos de uma mesma transação de origem seguem juntos, ou nenhum segue
+		var batch []*sendUnit
+		for _, unit := range groupUnits(tasks) {
+			if unitBlocked(nodeID, unit, blocked) {
+				blockUnit(nodeID, unit, blocked)
+				continue
 			}
-
-			webhookPayload := models.SyncPayload{
-				EventID:     item.EventID,
-				Table:       item.Tabela,
-				Operation:   item.Operacao,
-				PKJSON:      pkMap,
-				PayloadJSON: payloadMap,
-				Timestamp:   item.DTEvento,
-				Origem:      p.cfg.NodeID,
+			if err := p.buildUnit(unit); err != nil {
+				blockUnit(nodeID, unit, blocked)
+				continue
 			}
 
-			// Se o Relay estiver ligado e for um nó remoto, tentamos enviar via Relay primeiro
-			if p.cfg.Relay.Enabled && p.relay != nil {
-				log.Printf("[POLLER] Enviando para %s via RELAY...", nodeID)
-				p.relay.SendSync(nodeID, webhookPayload)
-				p.queue.UpdateDestinoStatus(task.ID, "E", "")
+			// Se o Relay estiver ligado e for um nó remoto, tentamos enviar via Relay primeiro.
+			// O destino fica em trânsito ('I') até o "ack" do nó de destino.
+			if p.cfg().Relay.Enabled && p.relay != nil {
+				p.sendUnitRelay(nodeID, unit)
+				blockUnit(nodeID, unit, blocked)
 				continue
 			}
 
@@ -155,22 +229,507 @@ func (p *Poller) sendEvents() {
 				continue
 			}
 
-			sender := &webhookSenderWithURL{
-				p:   p,
-				url: remoteURL,
+			// Destino com /sync/batch: acumula e envia em lotes depois do laço
+			if !p.noBatch[nodeID] {
+				batch = append(batch, unit)
+				continue
 			}
 
-			err := sender.Send(p.ctx, webhookPayload)
-			if err != nil {
-				log.Printf("[POLLER] Falha ao enviar para %s (ID %d): %v", nodeID, task.ID, err)
-				p.queue.UpdateDestinoStatus(task.ID, "R", err.Error())
+			n, ok := p.sendUnitSingle(nodeID, remoteURL, unit, blocked)
+			sent += n
+			if !ok {
+				// Falha de transporte: o nó está inacessível, não é culpa da linha.
+				// Interrompe o envio para este nó sem contar tentativa e aguarda o backoff do nó.
 				break
-			} else {
-				log.Printf("[POLLER] Sucesso para %s (ID %d)", nodeID, task.ID)
-				p.queue.UpdateDestinoStatus(task.ID, "E", "")
 			}
 		}
+
+		sent += p.sendBatches(nodeID, remoteURL, batch, blocked)
+	}
+	return fetched >= p.batchSize() && sent > 0
+}
+
+// sendUnit é o que segue junto para um nó: um evento avulso ou todos os eventos de
+// uma transação de origem
+type sendUnit struct {
+	tasks    []*db.FilaDestino
+	payloads []models.SyncPayload
+}
+
+// groupUnits agrupa os destinos do nó por transação de origem, na ordem do primeiro
+// evento de cada grupo
+func groupUnits(tasks []*db.FilaDestino) []*sendUnit {
+	var units []*sendUnit
+	byTransaction := make(map[int64]*sendUnit)
+	for _, task := range tasks {
+		t := task.Item.TransacaoID
+		if unit, ok := byTransaction[t]; ok && t > 0 {
+			unit.tasks = append(unit.tasks, task)
+			continue
+		}
+		unit := &sendUnit{tasks: []*db.FilaDestino{task}}
+		if t > 0 {
+			byTransaction[t] = unit
+		}
+		units = append(units, unit)
+	}
+	return units
+}
+
+// unitBlocked indica se alguma linha do grupo tem evento anterior aguardando reenvio
+func unitBlocked(nodeID string, unit *sendUnit, blocked map[string]int64) bool {
+	for _, task := range unit.tasks {
+		rowKey := db.RowKey(nodeID, task.Item.Tabela, task.Item.PKJSON)
+		if firstID, isBlocked := blocked[rowKey]; isBlocked && task.ID > firstID {
+			return true
+		}
+	}
+	return false
+}
+
+// blockUnit segura neste ciclo os eventos seguintes das linhas do grupo
+func blockUnit(nodeID string, unit *sendUnit, blocked map[string]int64) {
+	for _, task := range unit.tasks {
+		rowKey := db.RowKey(nodeID, task.Item.Tabela, task.Item.PKJSON)
+		if firstID, isBlocked := blocked[rowKey]; !isBlocked || task.ID < firstID {
+			blocked[rowKey] = task.ID
+		}
+	}
+}
+
+// buildUnit monta os eventos do grupo; se um falhar, o grupo inteiro espera
+func (p *Poller) buildUnit(unit *sendUnit) error {
+	unit.payloads = make([]models.SyncPayload, 0, len(unit.tasks))
+	for _, task := range unit.tasks {
+		payload, err := p.buildPayload(task.Item)
+		if err != nil {
+			log.Printf("[POLLER] Erro ao ler a linha de %s (ID %d): %v", task.Item.Tabela, task.ID, err)
+			p.markFailure(task, err)
+			return err
+		}
+		payload.Attempt = task.Tentativas
+		unit.payloads = append(unit.payloads, payload)
+	}
+	return nil
+}
+
+// sendUnitRelay envia o grupo pelo Relay e marca os destinos em trânsito
+func (p *Poller) sendUnitRelay(nodeID string, unit *sendUnit) {
+	expired := false
+	for _, task := range unit.tasks {
+		if task.Status == "I" {
+			p.markFailure(task, fmt.Errorf("sem confirmação de %s em %ds", nodeID, p.ackTimeout()))
+			expired = true
+		}
+	}
+	if expired {
+		return // O grupo volta inteiro quando o reenvio do evento expirado vencer
+	}
+
+	log.Printf("[POLLER] Enviando %d evento(s) para %s via RELAY...", len(unit.tasks), nodeID)
+	for _, task := range unit.tasks {
+		if err := p.queue.MarkDestinoInFlight(task.ID, p.ackTimeout()); err != nil {
+			log.Printf("[POLLER] Erro ao marcar ID %d em trânsito: %v", task.ID, err)
+			return
+		}
+	}
+	for _, part := range unitParts(unit, webhook.MaxBatchEvents) {
+		if len(part.payloads) == 1 {
+			p.relay.SendSync(nodeID, part.payloads[0])
+		} else {
+			p.relay.SendSyncBatch(nodeID, part.payloads)
+		}
+	}
+}
+
+// sendUnitSingle envia o grupo evento a evento (destino sem /sync/batch). Retorna
+// quantos foram confirmados e false numa falha de transporte.
+func (p *Poller) sendUnitSingle(nodeID, remoteURL string, unit *sendUnit, blocked map[string]int64) (int, bool) {
+	sender := &webhookSenderWithURL{
+		p:   p,
+		url: remoteURL,
+	}
+
+	sent := 0
+	for i, task := range unit.tasks {
+		rowKey := db.RowKey(nodeID, task.Item.Tabela, task.Item.PKJSON)
+		if firstID, isBlocked := blocked[rowKey]; isBlocked && task.ID > firstID {
+			continue
+		}
+
+		err := sender.Send(p.ctx, unit.payloads[i])
+		if err != nil {
+			log.Printf("[POLLER] Falha ao enviar para %s (ID %d): %v", nodeID, task.ID, err)
+
+			var rejected *remoteError
+			if !errors.As(err, &rejected) {
+				p.nodeUnreachable(nodeID, err)
+				return sent, false
+			}
+			p.nodeReachable(nodeID)
+
+			// O remoto recusou o evento: segura apenas os eventos seguintes desta linha
+			p.markFailure(task, err)
+			blocked[rowKey] = task.ID
+			continue
+		}
+		log.Printf("[POLLER] Sucesso para %s (ID %d)", nodeID, task.ID)
+		p.nodeReachable(nodeID)
+		p.queue.UpdateDestinoStatus(task.ID, "E", "")
+		sent++
+	}
+	return sent, true
+}
+
+// sendBatches envia os grupos em lotes de até batch_size eventos, sem partir uma
+// transação de origem entre lotes (só as maiores que o lote máximo do destino, ver
+// unitParts)
+func (p *Poller) sendBatches(nodeID, remoteURL string, units []*sendUnit, blocked map[string]int64) int {
+	sent := 0
+	var tasks []*db.FilaDestino
+	var payloads []models.SyncPayload
+	flush := func() bool {
+		if len(tasks) == 0 {
+			return true
+		}
+		n, ok := p.sendBatch(nodeID, remoteURL, tasks, payloads, blocked)
+		sent += n
+		tasks, payloads = nil, nil
+		return ok
+	}
+
+	for _, unit := range units {
+		for _, part := range unitParts(unit, webhook.MaxBatchEvents) {
+			if len(tasks) > 0 && len(tasks)+len(part.tasks) > p.batchSize() {
+				if !flush() {
+					return sent
+				}
+			}
+			// Uma recusa num lote anterior segura os eventos seguintes da mesma linha
+			if unitBlocked(nodeID, part, blocked) {
+				blockUnit(nodeID, part, blocked)
+				continue
+			}
+			tasks = append(tasks, part.tasks...)
+			payloads = append(payloads, part.payloads...)
+		}
+	}
+	flush()
+	return sent
+}
+
+// unitParts parte um grupo com mais de max eventos. Uma transação de origem desse
+// tamanho não cabe num lote do destino: cada parte é aplicada em separado e a
+// transação perde a atomicidade no destino, por isso o aviso no log.
+func unitParts(unit *sendUnit, max int) []*sendUnit {
+	if len(unit.tasks) <= max {
+		return []*sendUnit{unit}
+	}
+	log.Printf("[POLLER] AVISO: transação de origem %d com %d eventos excede o lote máximo (%d): será aplicada no destino em partes, sem atomicidade",
+		unit.tasks[0].Item.TransacaoID, len(unit.tasks), max)
+
+	var parts []*sendUnit
+	for start := 0; start < len(unit.tasks); start += max {
+		end := start + max
+		if end > len(unit.tasks) {
+			end = len(unit.tasks)
+		}
+		parts = append(parts, &sendUnit{tasks: unit.tasks[start:end], payloads: unit.payloads[start:end]})
+	}
+	return parts
+}
+
+// buildPayload monta o evento a enviar. BLOBs não vão na trigger: são lidos da
+// linha de origem no momento do envio.
+func (p *Poller) buildPayload(item *db.FilaItem) (models.SyncPayload, error) {
+	var pkMap map[string]interface{}
+	var payloadMap map[string]interface{}
+
+	json.Unmarshal([]byte(item.PKJSON), &pkMap)
+	if len(item.PayloadJSON) > 0 {
+		json.Unmarshal([]byte(item.PayloadJSON), &payloadMap)
+	}
+
+	operacao := item.Operacao
+	if db.IsSyntheticOrigin(item.Origem) {
+		// Carga e reparo levam a linha como está agora, não como estava na leitura:
+		// uma transação do ERP que ainda não tinha comitado na leitura pode ter
+		// gerado um evento de ID menor, já enviado, com valores mais novos
+		row, err := p.queue.PayloadRow(item.Tabela, pkMap)
+		if err != nil {
+			return models.SyncPayload{}, err
+		}
+		operacao, payloadMap = "U", row
+		if row == nil {
+			operacao = "D"
+		}
+	}
+
+	if operacao != "D" && payloadMap != nil {
+		blobs, err := p.queue.ReadBlobs(item.Tabela, pkMap)
+		if err != nil {
+			return models.SyncPayload{}, err
+		}
+		for col, v := range blobs {
+			if _, captured := payloadMap[col]; !captured {
+				payloadMap[col] = v
+			}
+		}
+	}
+
+	return models.SyncPayload{
+		EventID:     item.EventID,
+		Table:       item.Tabela,
+		Operation:   operacao,
+		PKJSON:      pkMap,
+		PayloadJSON: payloadMap,
+		Timestamp:   item.DTEvento,
+		Origem:      p.cfg().NodeID,
+		BaseVersion: item.VersaoBase,
+		Transaction: item.TransacaoID,
+	}, nil
+}
+
+// sendBatch envia um lote ao /sync/batch do nó e trata o resultado de cada evento.
+// Retorna quantos foram confirmados e false só numa falha de transporte ou do lote
+// inteiro: um evento recusado segura apenas a sua linha (blocked), e os lotes
+// seguintes do nó continuam.
+func (p *Poller) sendBatch(nodeID, remoteURL string, tasks []*db.FilaDestino, payloads []models.SyncPayload, blocked map[string]int64) (int, bool) {
+	results, err := p.postBatch(batchURL(remoteURL), payloads)
+	if err != nil {
+		var rejected *remoteError
+		if errors.As(err, &rejected) && rejected.status == http.StatusNotFound {
+			// Agente antigo no destino: volta ao envio evento a evento
+			log.Printf("[POLLER] %s não aceita envio em lote. Enviando evento a evento", nodeID)
+			p.noBatch[nodeID] = true
+			return 0, false
+		}
+		log.Printf("[POLLER] Falha ao enviar lote de %d evento(s) para %s: %v", len(tasks), nodeID, err)
+		if rejected == nil {
+			// Transporte: o nó está inacessível, não é culpa de um evento
+			p.nodeUnreachable(nodeID, err)
+			return 0, false
+		}
+		// O nó recusou o lote inteiro: conta a tentativa no primeiro evento, para
+		// retry_max e o backoff valerem também para um destino que sempre recusa
+		p.nodeReachable(nodeID)
+		p.rejectBatch(nodeID, tasks, err, blocked)
+		return 0, false
+	}
+	p.nodeReachable(nodeID)
+	if len(results) != len(tasks) {
+		err := fmt.Errorf("%s devolveu %d resultado(s) para %d evento(s)", nodeID, len(results), len(tasks))
+		log.Printf("[POLLER] %v", err)
+		p.rejectBatch(nodeID, tasks, err, blocked)
+		return 0, false
+	}
+
+	sent, failed := 0, 0
+	for i, task := range tasks {
+		rowKey := db.RowKey(nodeID, task.Item.Tabela, task.Item.PKJSON)
+		switch r := results[i]; r.Status {
+		case webhook.BatchApplied, webhook.BatchDuplicate, webhook.BatchParked:
+			p.queue.UpdateDestinoStatus(task.ID, "E", "")
+			sent++
+		case webhook.BatchSkipped:
+			// Não processado: sai no próximo ciclo, na mesma ordem
+			blocked[rowKey] = task.ID
+		default:
+			p.markFailure(task, fmt.Errorf("recusado por %s: %s", nodeID, r.Error))
+			blocked[rowKey] = task.ID
+			failed++
+		}
 	}
+	log.Printf("[POLLER] Lote para %s: %d confirmado(s), %d com falha, %d pendente(s)", nodeID, sent, failed, len(tasks)-sent-failed)
+	return sent, true
+}
+
+// rejectBatch conta a recusa de um lote inteiro no seu primeiro evento e segura as
+// linhas do lote neste ciclo
+func (p *Poller) rejectBatch(nodeID string, tasks []*db.FilaDestino, err error, blocked map[string]int64) {
+	p.markFailure(tasks[0], err)
+	for _, task := range tasks {
+		rowKey := db.RowKey(nodeID, task.Item.Tabela, task.Item.PKJSON)
+		if firstID, isBlocked := blocked[rowKey]; !isBlocked || task.ID < firstID {
+			blocked[rowKey] = task.ID
+		}
+	}
+}
+
+// nodeUnreachable adia o próximo envio ao nó com o mesmo backoff dos eventos
+func (p *Poller) nodeUnreachable(nodeID string, err error) {
+	r := p.unreachable[nodeID]
+	if r == nil {
+		r = &nodeRetry{}
+		p.unreachable[nodeID] = r
+	}
+	r.failures++
+	delay := p.backoffDelay(r.failures)
+	r.next = time.Now().Add(delay)
+	log.Printf("[POLLER] %s inacessível (%d falha(s) seguidas): %v. Próxima tentativa em %s", nodeID, r.failures, err, delay)
+}
+
+// nodeReachable encerra o backoff do nó assim que ele responde
+func (p *Poller) nodeReachable(nodeID string) {
+	if r := p.unreachable[nodeID]; r != nil {
+		log.Printf("[POLLER] %s respondeu novamente após %d falha(s)", nodeID, r.failures)
+		delete(p.unreachable, nodeID)
+	}
+}
+
+func (p *Poller) postBatch(url string, payloads []models.SyncPayload) ([]webhook.BatchResult, error) {
+	body, _ := json.Marshal(payloads)
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	token := p.cfg().Webhook.Token
+	if token == "" {
+		token = "ATS_SYNC_DEFAULT"
+	}
+	req.Header.Set("X-Sync-Token", token)
+
+	client := &http.Client{Timeout: 2 * time.Minute}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	raw, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return nil, &remoteError{status: resp.StatusCode, body: string(raw)}
+	}
+	var results []webhook.BatchResult
+	if err := json.Unmarshal(raw, &results); err != nil {
+		return nil, fmt.Errorf("resposta inválida do lote: %w", err)
+	}
+	return results, nil
+}
+
+// batchURL troca o caminho /sync do endereço do nó por /sync/batch
+func batchURL(syncURL string) string {
+	return strings.TrimSuffix(strings.TrimRight(syncURL, "/"), "/sync") + "/sync/batch"
+}
+
+// batchSize é o máximo de eventos lidos por ciclo e enviados por lote
+// (integracao.batch_size), limitado ao lote máximo aceito pelo destino
+func (p *Poller) batchSize() int {
+	size := p.cfg().Integracao.BatchSize
+	if size <= 0 {
+		return 100
+	}
+	if size > webhook.MaxBatchEvents {
+		if p.batchWarned != size {
+			log.Printf("[POLLER] AVISO: integracao.batch_size %d acima do lote máximo do destino; usando %d", size, webhook.MaxBatchEvents)
+			p.batchWarned = size
+		}
+		return webhook.MaxBatchEvents
+	}
+	return size
+}
+
+// handleAck trata a confirmação do destino para um evento enviado via Relay
+func (p *Poller) handleAck(sourceNode string, ack webhook.RelayAck) {
+	task, err := p.queue.GetInFlightDestino(ack.EventID, sourceNode)
+	if err != nil {
+		log.Printf("[POLLER] Erro ao localizar destino do ack %s (%s): %v", ack.EventID, sourceNode, err)
+		return
+	}
+	if task == nil {
+		// Ack tardio (já expirou e foi reagendado) ou duplicado
+		return
+	}
+
+	switch ack.Status {
+	case webhook.AckOK:
+		log.Printf("[POLLER] Confirmado por %s (ID %d)", sourceNode, task.ID)
+		p.queue.UpdateDestinoStatus(task.ID, "E", "")
+	case webhook.AckQueued:
+		// O Hub entrega quando o destino reconectar; aguarda o ack real por mais tempo
+		log.Printf("[POLLER] %s offline: ID %d guardado no Hub", sourceNode, task.ID)
+		p.queue.MarkDestinoInFlight(task.ID, p.queuedTimeout())
+	case webhook.AckOffline:
+		log.Printf("[POLLER] %s offline no Hub. Adiando ID %d", sourceNode, task.ID)
+		p.queue.PostponeDestino(task.ID, ack.Error, p.retryInterval())
+	default:
+		log.Printf("[POLLER] %s recusou ID %d: %s", sourceNode, task.ID, ack.Error)
+		p.markFailure(task, errors.New(ack.Error))
+	}
+}
+
+func (p *Poller) ackTimeout() int {
+	if p.cfg().Relay.AckTimeoutSeconds > 0 {
+		return p.cfg().Relay.AckTimeoutSeconds
+	}
+	return 60
+}
+
+// queuedTimeout é o prazo de confirmação de um evento guardado no Hub para um nó offline
+func (p *Poller) queuedTimeout() int {
+	if p.cfg().Relay.QueuedTimeoutSeconds > 0 {
+		return p.cfg().Relay.QueuedTimeoutSeconds
+	}
+	return 72 * 3600
+}
+
+func (p *Poller) retryInterval() int {
+	if p.cfg().Integracao.RetryIntervalSeconds > 0 {
+		return p.cfg().Integracao.RetryIntervalSeconds
+	}
+	return 5
+}
+
+// markFailure agenda o reenvio com backoff exponencial + jitter, ou move o destino
+// para 'F' (falha definitiva) quando as tentativas passam de integracao.retry_max
+// (a primeira tentativa mais retry_max reenvios)
+func (p *Poller) markFailure(task *db.FilaDestino, sendErr error) {
+	attempts := task.Tentativas + 1
+
+	retryMax := p.cfg().Integracao.RetryMax
+	if retryMax <= 0 {
+		retryMax = 10
+	}
+	if attempts > retryMax {
+		log.Printf("[POLLER] Destino ID %d (%s) falhou em %d tentativas (retry_max %d). Movendo para falha (F).", task.ID, task.NodeID, attempts, retryMax)
+		p.queue.UpdateDestinoStatus(task.ID, "F", sendErr.Error())
+		return
+	}
+
+	delay := p.backoffDelay(attempts)
+	log.Printf("[POLLER] Destino ID %d (%s): tentativa %d/%d falhou. Próxima em %s", task.ID, task.NodeID, attempts, retryMax+1, delay)
+	if err := p.queue.ScheduleDestinoRetry(task.ID, sendErr.Error(), int(delay.Seconds())); err != nil {
+		log.Printf("[POLLER] Erro ao agendar reenvio do ID %d: %v", task.ID, err)
+	}
+}
+
+// backoffDelay calcula base * 2^(tentativa-1), limitado ao teto, com jitter de ±20%
+func (p *Poller) backoffDelay(attempt int) time.Duration {
+	base := time.Duration(p.cfg().Integracao.RetryIntervalSeconds) * time.Second
+	if base <= 0 {
+		base = 5 * time.Second
+	}
+	maxDelay := time.Duration(p.cfg().Integracao.RetryMaxDelaySeconds) * time.Second
+	if maxDelay <= 0 {
+		maxDelay = time.Hour
+	}
+
+	delay := base
+	for i := 1; i < attempt && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+
+	jitter := time.Duration((rand.Float64()*0.4 - 0.2) * float64(delay))
+	delay += jitter
+	if delay < time.Second {
+		delay = time.Second
+	}
+	return delay
 }
 
 type webhookSenderWithURL struct {
@@ -186,7 +745,7 @@ func (s *webhookSenderWithURL) Send(ctx context.Context, payload interface{}) er
 	}
 	req.Header.Set("Content-Type", "application/json")
 
-	token := s.p.cfg.Webhook.Token
+	token := s.p.cfg().Webhook.Token
 	if token == "" {
 		token = "ATS_SYNC_DEFAULT"
 	}
@@ -201,7 +760,17 @@ func (s *webhookSenderWithURL) Send(ctx context.Context, payload interface{}) er
 
 	if resp.StatusCode != http.StatusOK {
 		bodyErr, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("status %d: %s", resp.StatusCode, string(bodyErr))
+		return &remoteError{status: resp.StatusCode, body: string(bodyErr)}
 	}
 	return nil
 }
+
+// remoteError indica que o remoto respondeu, mas recusou o evento
+type remoteError struct {
+	status int
+	body   string
+}
+
+func (e *remoteError) Error() string {
+	return fmt.Sprintf("status %d: %s", e.status, e.body)
+}
@@ -0,0 +1,190 @@
+package sync
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	gosync "sync"
+	"testing"
+
+	"github.com/atsinformatica/firebird-sync-agent/internal/config"
+	"github.com/atsinformatica/firebird-sync-agent/internal/db"
+	"github.com/atsinformatica/firebird-sync-agent/internal/models"
+	"github.com/atsinformatica/firebird-sync-agent/internal/webhook"
+)
+
+func destino(id, transacaoID int64) *db.FilaDestino {
+	return &db.FilaDestino{ID: id, Item: &db.FilaItem{TransacaoID: transacaoID}}
+}
+
+// unitIDs resume os grupos pelos IDs dos destinos
+func unitIDs(units []*sendUnit) [][]int64 {
+	var got [][]int64
+	for _, unit := range units {
+		var ids []int64
+		for _, task := range unit.tasks {
+			ids = append(ids, task.ID)
+		}
+		got = append(got, ids)
+	}
+	return got
+}
+
+func TestGroupUnits(t *testing.T) {
+	tests := []struct {
+		name  string
+		tasks []*db.FilaDestino
+		want  [][]int64
+	}{
+		{"eventos avulsos seguem sozinhos", []*db.FilaDestino{destino(1, 0), destino(2, 0)}, [][]int64{{1}, {2}}},
+		{"mesma transação vira um grupo", []*db.FilaDestino{destino(1, 7), destino(2, 7), destino(3, 7)}, [][]int64{{1, 2, 3}}},
+		{
+			name:  "transações intercaladas na ordem do primeiro evento",
+			tasks: []*db.FilaDestino{destino(1, 7), destino(2, 8), destino(3, 0), destino(4, 7), destino(5, 8)},
+			want:  [][]int64{{1, 4}, {2, 5}, {3}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := unitIDs(groupUnits(tt.tasks)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("groupUnits = %v, esperado %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnitParts(t *testing.T) {
+	unit := &sendUnit{}
+	for id := int64(1); id <= 5; id++ {
+		unit.tasks = append(unit.tasks, destino(id, 9))
+		unit.payloads = append(unit.payloads, models.SyncPayload{Transaction: 9})
+	}
+
+	tests := []struct {
+		name string
+		max  int
+		want [][]int64
+	}{
+		{"cabe no lote", 5, [][]int64{{1, 2, 3, 4, 5}}},
+		{"partes do tamanho do lote e o resto", 2, [][]int64{{1, 2}, {3, 4}, {5}}},
+		{"partes exatas", 1, [][]int64{{1}, {2}, {3}, {4}, {5}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parts := unitParts(unit, tt.max)
+			if got := unitIDs(parts); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("unitParts = %v, esperado %v", got, tt.want)
+			}
+			for _, part := range parts {
+				if len(part.payloads) != len(part.tasks) {
+					t.Errorf("parte com %d destinos e %d eventos", len(part.tasks), len(part.payloads))
+				}
+			}
+		})
+	}
+}
+
+// statusDB é um driver mínimo que só registra o status gravado em cada destino
+// (UpdateDestinoStatus e ScheduleDestinoRetry), para testar o envio sem Firebird
+type statusDB struct {
+	mu     gosync.Mutex
+	status map[int64]string
+}
+
+func (d *statusDB) Open(string) (driver.Conn, error) { return statusConn{d}, nil }
+
+type statusConn struct{ d *statusDB }
+
+func (c statusConn) Prepare(query string) (driver.Stmt, error) {
+	return statusStmt{c.d, query}, nil
+}
+func (c statusConn) Close() error              { return nil }
+func (c statusConn) Begin() (driver.Tx, error) { return nil, errors.New("sem transações") }
+
+type statusStmt struct {
+	d     *statusDB
+	query string
+}
+
+func (s statusStmt) Close() error  { return nil }
+func (s statusStmt) NumInput() int { return -1 }
+func (s statusStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	id := args[len(args)-1].(int64)
+	switch {
+	case strings.Contains(s.query, "STATUS = 'R'"):
+		s.d.status[id] = "R"
+	case strings.Contains(s.query, "SET STATUS = ?"):
+		s.d.status[id] = args[0].(string)
+	}
+	return driver.RowsAffected(1), nil
+}
+func (s statusStmt) Query([]driver.Value) (driver.Rows, error) {
+	return nil, errors.New("consulta não suportada")
+}
+
+var statusDriver = &statusDB{status: make(map[int64]string)}
+
+func init() {
+	sql.Register("sync_status_test", statusDriver)
+}
+
+func TestSendBatchesAfterRejection(t *testing.T) {
+	var mu gosync.Mutex
+	var batches [][]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payloads []models.SyncPayload
+		json.NewDecoder(r.Body).Decode(&payloads)
+		var ids []string
+		results := make([]webhook.BatchResult, len(payloads))
+		for i, p := range payloads {
+			ids = append(ids, p.EventID)
+			results[i] = webhook.BatchResult{EventID: p.EventID, Status: webhook.BatchApplied}
+			if p.EventID == "recusado" {
+				results[i].Status, results[i].Error = webhook.BatchError, "violação de constraint"
+			}
+		}
+		mu.Lock()
+		batches = append(batches, ids)
+		mu.Unlock()
+		json.NewEncoder(w).Encode(results)
+	}))
+	defer srv.Close()
+
+	conn, err := sql.Open("sync_status_test", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	cfg := &config.Config{}
+	cfg.Integracao.BatchSize = 2
+	p := NewPoller(config.NewStore(cfg), db.NewQueueManager(conn, "A"), nil, nil)
+
+	unit := func(id int64, eventID, pk string) *sendUnit {
+		task := &db.FilaDestino{ID: id, NodeID: "B", Item: &db.FilaItem{EventID: eventID, Tabela: "PRODUTO", PKJSON: pk}}
+		return &sendUnit{tasks: []*db.FilaDestino{task}, payloads: []models.SyncPayload{{EventID: eventID}}}
+	}
+	units := []*sendUnit{
+		unit(1, "a", `{"CODIGO": 1}`),
+		unit(2, "recusado", `{"CODIGO": 2}`),
+		unit(3, "c", `{"CODIGO": 3}`),
+		unit(4, "seguinte", `{"CODIGO": 2}`), // Mesma linha do recusado: espera
+	}
+
+	sent := p.sendBatches("B", srv.URL+"/sync", units, make(map[string]int64))
+	if sent != 2 {
+		t.Errorf("confirmados = %d, esperado 2", sent)
+	}
+	if want := [][]string{{"a", "recusado"}, {"c"}}; !reflect.DeepEqual(batches, want) {
+		t.Errorf("lotes enviados = %v, esperado %v", batches, want)
+	}
+	if want := map[int64]string{1: "E", 2: "R", 3: "E"}; !reflect.DeepEqual(statusDriver.status, want) {
+		t.Errorf("status dos destinos = %v, esperado %v", statusDriver.status, want)
+	}
+}
@@ -0,0 +1,213 @@
+package sync
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/atsinformatica/firebird-sync-agent/internal/config"
+	"github.com/atsinformatica/firebird-sync-agent/internal/db"
+)
+
+// Purger apaga da fila (FILA_DESTINOS, FILA_INTEGRACAO) e das tabelas de controle
+// (SYNC_VERSOES, SYNC_AUDITORIA, SYNC_CONFLITOS, SYNC_RESYNC) o que passou do prazo
+// de retenção, em levas pequenas para não segurar transações longas no banco do ERP
+type Purger struct {
+	conf  *config.Store
+	queue *db.QueueManager
+}
+
+func NewPurger(conf *config.Store, queue *db.QueueManager) *Purger {
+	return &Purger{conf: conf, queue: queue}
+}
+
+func (p *Purger) cfg() *config.Config {
+	return p.conf.Get()
+}
+
+// Start roda o expurgo logo após a partida e depois a cada retention.interval_minutes
+func (p *Purger) Start(ctx context.Context) {
+	wait := time.Minute // Deixa o agente subir antes do primeiro expurgo
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		p.run(ctx)
+		wait = p.interval()
+	}
+}
+
+func (p *Purger) interval() time.Duration {
+	if p.cfg().Retention.IntervalMinutes > 0 {
+		return time.Duration(p.cfg().Retention.IntervalMinutes) * time.Minute
+	}
+	return time.Hour
+}
+
+func (p *Purger) batchSize() int {
+	if p.cfg().Retention.BatchSize > 0 {
+		return p.cfg().Retention.BatchSize
+	}
+	return 500
+}
+
+// run executa um ciclo de expurgo: destinos primeiro, pois um evento só sai da fila
+// quando não resta nenhum destino dele
+func (p *Purger) run(ctx context.Context) {
+	r := p.cfg().Retention
+
+	type step struct {
+		name  string // Tabela (e nome do arquivo de arquivamento)
+		desc  string // Quais linhas, para o log
+		days  int
+		purge func(limit int, archive func([]interface{}) error) (int, error)
+	}
+	var steps []step
+	statusStep := func(name string, statuses []string, days int, purge func(statuses []string, days, limit int, archive func([]interface{}) error) (int, error)) {
+		if days > 0 {
+			steps = append(steps, step{name, fmt.Sprintf("com status %v", statuses), days, func(limit int, archive func([]interface{}) error) (int, error) {
+				return purge(statuses, days, limit, archive)
+			}})
+		}
+	}
+	daysStep := func(name, desc string, days int, purge func(days, limit int, archive func([]interface{}) error) (int, error)) {
+		if days > 0 {
+			steps = append(steps, step{name, desc, days, func(limit int, archive func([]interface{}) error) (int, error) {
+				return purge(days, limit, archive)
+			}})
+		}
+	}
+	statusStep("FILA_DESTINOS", db.PurgeFailedStatuses, r.FailedDays, p.queue.PurgeDestinations)
+	statusStep("FILA_DESTINOS", []string{"E"}, r.DoneDays, p.queue.PurgeDestinations)
+	statusStep("FILA_INTEGRACAO", db.PurgeFailedStatuses, r.FailedDays, p.queue.PurgeEvents)
+	statusStep("FILA_INTEGRACAO", db.PurgeDoneStatuses, r.DoneDays, p.queue.PurgeEvents)
+	daysStep("SYNC_VERSOES", "sem alteração", r.VersionDays, func(days, limit int, _ func([]interface{}) error) (int, error) {
+		return p.queue.PurgeVersions(days, limit) // Sem arquivamento
+	})
+	daysStep("SYNC_AUDITORIA", "de comandos executados", r.AuditDays, p.queue.PurgeAudit)
+	daysStep("SYNC_CONFLITOS", "resolvidas", r.ConflictDays, p.queue.PurgeConflicts)
+	daysStep("SYNC_RESYNC", "de cargas concluídas", r.ResyncDays, p.queue.PurgeResyncJobs)
+	if len(steps) == 0 {
+		return
+	}
+
+	var archive *purgeArchive
+	if r.ArchiveDir != "" {
+		archive = newPurgeArchive(r.ArchiveDir)
+		defer func() {
+			if err := archive.Close(); err != nil {
+				log.Printf("[PURGE] Erro ao fechar arquivo de expurgo: %v", err)
+			}
+		}()
+	}
+
+	limit := p.batchSize()
+	for _, s := range steps {
+		var write func([]interface{}) error
+		if archive != nil {
+			write = archive.writer(s.name)
+		}
+
+		total := 0
+		for ctx.Err() == nil {
+			n, err := s.purge(limit, write)
+			if err != nil {
+				log.Printf("[PURGE] Erro no expurgo de %s: %v", s.name, err)
+				return
+			}
+			total += n
+			if n < limit {
+				break
+			}
+			// Pausa curta entre as levas para não disputar o banco com o ERP
+			select {
+			case <-ctx.Done():
+			case <-time.After(200 * time.Millisecond):
+			}
+		}
+		if total > 0 {
+			log.Printf("[PURGE] %d linha(s) de %s %s apagadas (mais de %d dias)", total, s.name, s.desc, s.days)
+		}
+	}
+}
+
+// purgeArchive grava as linhas expurgadas de um ciclo em um arquivo JSONL gzip por
+// tabela (<dir>/<tabela>-<data>.jsonl.gz)
+type purgeArchive struct {
+	dir   string
+	stamp string
+	files map[string]*archiveFile
+}
+
+type archiveFile struct {
+	f   *os.File
+	gz  *gzip.Writer
+	enc *json.Encoder
+}
+
+func newPurgeArchive(dir string) *purgeArchive {
+	return &purgeArchive{
+		dir:   dir,
+		stamp: time.Now().Format("20060102-150405"),
+		files: make(map[string]*archiveFile),
+	}
+}
+
+// writer retorna a função de arquivamento da tabela. Cada leva é descarregada no disco
+// antes do DELETE, para nada ser apagado sem estar gravado.
+func (a *purgeArchive) writer(table string) func([]interface{}) error {
+	return func(records []interface{}) error {
+		af, err := a.open(table)
+		if err != nil {
+			return err
+		}
+		for _, rec := range records {
+			if err := af.enc.Encode(rec); err != nil {
+				return err
+			}
+		}
+		if err := af.gz.Flush(); err != nil {
+			return err
+		}
+		return af.f.Sync()
+	}
+}
+
+func (a *purgeArchive) open(table string) (*archiveFile, error) {
+	if af, ok := a.files[table]; ok {
+		return af, nil
+	}
+	if err := os.MkdirAll(a.dir, 0755); err != nil {
+		return nil, fmt.Errorf("erro ao criar diretório de arquivamento: %w", err)
+	}
+	path := filepath.Join(a.dir, fmt.Sprintf("%s-%s.jsonl.gz", table, a.stamp))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao abrir arquivo de arquivamento: %w", err)
+	}
+	gz := gzip.NewWriter(f)
+	af := &archiveFile{f: f, gz: gz, enc: json.NewEncoder(gz)}
+	a.files[table] = af
+	return af, nil
+}
+
+// Close finaliza os arquivos abertos no ciclo
+func (a *purgeArchive) Close() error {
+	var first error
+	for _, af := range a.files {
+		if err := af.gz.Close(); err != nil && first == nil {
+			first = err
+		}
+		if err := af.f.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
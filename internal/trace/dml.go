@@ -0,0 +1,573 @@
+package trace
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Expr representa um valor do SQL que não é literal (função, expressão, coluna...)
+// e que portanto não pode ser usado como valor de PK sem consultar o banco
+type Expr string
+
+// DMLStatement é o resultado da análise de um INSERT/UPDATE/DELETE capturado pelo Trace
+type DMLStatement struct {
+	Type    EventType
+	Table   string
+	Columns []string               // INSERT/UPDATE OR INSERT: lista de colunas; UPDATE: colunas do SET
+	Values  []interface{}          // Valores na mesma ordem de Columns (ou da tabela, se Columns vier vazia)
+	Where   map[string]interface{} // Igualdades "COLUNA = valor" ligadas por AND no WHERE
+}
+
+type tokenKind int
+
+const (
+	tokIdent tokenKind = iota
+	tokQuotedIdent
+	tokString
+	tokNumber
+	tokParam
+	tokNamedParam
+	tokPunct
+)
+
+type token struct {
+	kind  tokenKind
+	text  string // Identificadores em maiúsculas; strings já sem aspas
+	param int    // Índice posicional do "?" no statement
+}
+
+// ParseDML analisa um statement DML do Firebird e resolve os placeholders "?"
+// com os valores de params (na ordem em que o trace os imprime)
+func ParseDML(sqlText string, params []interface{}) (*DMLStatement, error) {
+	toks, err := tokenize(sqlText)
+	if err != nil {
+		return nil, err
+	}
+	d := &dmlParser{toks: toks, params: params}
+	return d.parse()
+}
+
+func tokenize(s string) ([]token, error) {
+	var toks []token
+	paramIdx := 0
+	i := 0
+	for i < len(s) {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+		case c == '-' && i+1 < len(s) && s[i+1] == '-':
+			for i < len(s) && s[i] != '\n' {
+				i++
+			}
+		case c == '/' && i+1 < len(s) && s[i+1] == '*':
+			end := strings.Index(s[i+2:], "*/")
+			if end == -1 {
+				return nil, fmt.Errorf("comentário não terminado")
+			}
+			i += end + 4
+		case c == '\'':
+			var sb strings.Builder
+			i++
+			for {
+				if i >= len(s) {
+					return nil, fmt.Errorf("string literal não terminada")
+				}
+				if s[i] == '\'' {
+					if i+1 < len(s) && s[i+1] == '\'' {
+						sb.WriteByte('\'')
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				sb.WriteByte(s[i])
+				i++
+			}
+			toks = append(toks, token{kind: tokString, text: sb.String()})
+		case c == '"':
+			end := strings.IndexByte(s[i+1:], '"')
+			if end == -1 {
+				return nil, fmt.Errorf("identificador entre aspas não terminado")
+			}
+			toks = append(toks, token{kind: tokQuotedIdent, text: s[i+1 : i+1+end]})
+			i += end + 2
+		case c == '?':
+			toks = append(toks, token{kind: tokParam, text: "?", param: paramIdx})
+			paramIdx++
+			i++
+		case c == ':' && i+1 < len(s) && isIdentChar(s[i+1]):
+			j := i + 1
+			for j < len(s) && isIdentChar(s[j]) {
+				j++
+			}
+			toks = append(toks, token{kind: tokNamedParam, text: strings.ToUpper(s[i+1 : j])})
+			i = j
+		case c >= '0' && c <= '9' || c == '.' && i+1 < len(s) && s[i+1] >= '0' && s[i+1] <= '9':
+			j := i
+			for j < len(s) && (s[j] >= '0' && s[j] <= '9' || s[j] == '.' || s[j] == 'e' || s[j] == 'E' ||
+				(s[j] == '-' || s[j] == '+') && j > i && (s[j-1] == 'e' || s[j-1] == 'E')) {
+				j++
+			}
+			toks = append(toks, token{kind: tokNumber, text: s[i:j]})
+			i = j
+		case isIdentChar(c):
+			j := i
+			for j < len(s) && isIdentChar(s[j]) {
+				j++
+			}
+			toks = append(toks, token{kind: tokIdent, text: strings.ToUpper(s[i:j])})
+			i = j
+		default:
+			// Operadores de dois caracteres (||, <>, !=, >=, <=)
+			if i+1 < len(s) {
+				two := s[i : i+2]
+				if two == "||" || two == "<>" || two == "!=" || two == ">=" || two == "<=" || two == "^=" {
+					toks = append(toks, token{kind: tokPunct, text: two})
+					i += 2
+					continue
+				}
+			}
+			toks = append(toks, token{kind: tokPunct, text: string(c)})
+			i++
+		}
+	}
+	return toks, nil
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c == '$' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9'
+}
+
+type dmlParser struct {
+	toks   []token
+	pos    int
+	params []interface{}
+}
+
+func (d *dmlParser) peek() *token {
+	if d.pos < len(d.toks) {
+		return &d.toks[d.pos]
+	}
+	return nil
+}
+
+func (d *dmlParser) isKeyword(kw string) bool {
+	t := d.peek()
+	return t != nil && t.kind == tokIdent && t.text == kw
+}
+
+func (d *dmlParser) isPunct(p string) bool {
+	t := d.peek()
+	return t != nil && t.kind == tokPunct && t.text == p
+}
+
+func (d *dmlParser) expectKeyword(kw string) error {
+	if !d.isKeyword(kw) {
+		return fmt.Errorf("esperado %s na posição %d", kw, d.pos)
+	}
+	d.pos++
+	return nil
+}
+
+func (d *dmlParser) expectPunct(p string) error {
+	if !d.isPunct(p) {
+		return fmt.Errorf("esperado '%s' na posição %d", p, d.pos)
+	}
+	d.pos++
+	return nil
+}
+
+// identifier lê um nome (com ou sem aspas), descartando o qualificador "ALIAS."
+func (d *dmlParser) identifier() (string, error) {
+	t := d.peek()
+	if t == nil || (t.kind != tokIdent && t.kind != tokQuotedIdent) {
+		return "", fmt.Errorf("identificador esperado na posição %d", d.pos)
+	}
+	d.pos++
+	name := t.text
+	if d.isPunct(".") {
+		d.pos++
+		return d.identifier()
+	}
+	return name, nil
+}
+
+// skipAlias ignora um alias opcional após o nome da tabela
+func (d *dmlParser) skipAlias() {
+	if d.isKeyword("AS") {
+		d.pos++
+	}
+	t := d.peek()
+	if t == nil {
+		return
+	}
+	if t.kind == tokQuotedIdent || t.kind == tokIdent && !isReservedAfterTable(t.text) {
+		d.pos++
+	}
+}
+
+func isReservedAfterTable(word string) bool {
+	switch word {
+	case "SET", "WHERE", "VALUES", "RETURNING", "MATCHING", "DEFAULT", "SELECT", "PLAN", "ORDER", "ROWS":
+		return true
+	}
+	return false
+}
+
+func (d *dmlParser) parse() (*DMLStatement, error) {
+	stmt := &DMLStatement{Where: make(map[string]interface{})}
+	var err error
+
+	switch {
+	case d.isKeyword("INSERT"):
+		d.pos++
+		stmt.Type = EventInsert
+		if err = d.expectKeyword("INTO"); err != nil {
+			return nil, err
+		}
+		err = d.parseInsertBody(stmt)
+
+	case d.isKeyword("UPDATE"):
+		d.pos++
+		stmt.Type = EventUpdate
+		if d.isKeyword("OR") {
+			// UPDATE OR INSERT INTO T (...) VALUES (...) [MATCHING (...)]
+			d.pos++
+			if err = d.expectKeyword("INSERT"); err != nil {
+				return nil, err
+			}
+			if err = d.expectKeyword("INTO"); err != nil {
+				return nil, err
+			}
+			err = d.parseInsertBody(stmt)
+			break
+		}
+		if stmt.Table, err = d.identifier(); err != nil {
+			return nil, err
+		}
+		d.skipAlias()
+		if err = d.expectKeyword("SET"); err != nil {
+			return nil, err
+		}
+		for {
+			col, err := d.identifier()
+			if err != nil {
+				return nil, err
+			}
+			if err := d.expectPunct("="); err != nil {
+				return nil, err
+			}
+			stmt.Columns = append(stmt.Columns, col)
+			stmt.Values = append(stmt.Values, d.expression())
+			if !d.isPunct(",") {
+				break
+			}
+			d.pos++
+		}
+		err = d.parseWhere(stmt)
+
+	case d.isKeyword("DELETE"):
+		d.pos++
+		stmt.Type = EventDelete
+		if err = d.expectKeyword("FROM"); err != nil {
+			return nil, err
+		}
+		if stmt.Table, err = d.identifier(); err != nil {
+			return nil, err
+		}
+		d.skipAlias()
+		err = d.parseWhere(stmt)
+
+	default:
+		return nil, fmt.Errorf("statement não é INSERT/UPDATE/DELETE")
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return stmt, nil
+}
+
+func (d *dmlParser) parseInsertBody(stmt *DMLStatement) error {
+	var err error
+	if stmt.Table, err = d.identifier(); err != nil {
+		return err
+	}
+	d.skipAlias()
+
+	if d.isPunct("(") {
+		d.pos++
+		for {
+			col, err := d.identifier()
+			if err != nil {
+				return err
+			}
+			stmt.Columns = append(stmt.Columns, col)
+			if d.isPunct(",") {
+				d.pos++
+				continue
+			}
+			if err := d.expectPunct(")"); err != nil {
+				return err
+			}
+			break
+		}
+	}
+
+	if d.isKeyword("DEFAULT") {
+		// INSERT ... DEFAULT VALUES: nenhum valor explícito
+		return nil
+	}
+	if err := d.expectKeyword("VALUES"); err != nil {
+		return fmt.Errorf("INSERT sem VALUES não suportado: %w", err)
+	}
+	if err := d.expectPunct("("); err != nil {
+		return err
+	}
+	for {
+		stmt.Values = append(stmt.Values, d.expression())
+		if d.isPunct(",") {
+			d.pos++
+			continue
+		}
+		if err := d.expectPunct(")"); err != nil {
+			return err
+		}
+		break
+	}
+
+	if len(stmt.Columns) > 0 && len(stmt.Columns) != len(stmt.Values) {
+		return fmt.Errorf("INSERT com %d colunas e %d valores", len(stmt.Columns), len(stmt.Values))
+	}
+	return nil
+}
+
+// parseWhere extrai as igualdades simples de um WHERE composto apenas por AND.
+// Se houver OR no nível superior nenhuma igualdade é considerada confiável.
+func (d *dmlParser) parseWhere(stmt *DMLStatement) error {
+	if !d.isKeyword("WHERE") {
+		return nil
+	}
+	d.pos++
+
+	end := len(d.toks)
+	for i := d.pos; i < len(d.toks); i++ {
+		if t := d.toks[i]; t.kind == tokIdent && (t.text == "RETURNING" || t.text == "PLAN" || t.text == "ORDER" || t.text == "ROWS") {
+			end = i
+			break
+		}
+	}
+	cond := d.toks[d.pos:end]
+	d.pos = end
+
+	if !hasTopLevelOr(cond) {
+		d.collectEqualities(stmt, cond)
+	}
+	return nil
+}
+
+// collectEqualities percorre os termos ligados por AND, descendo em parênteses
+func (d *dmlParser) collectEqualities(stmt *DMLStatement, cond []token) {
+	for _, term := range splitTopLevelAnd(cond) {
+		inner := stripParens(term)
+		if hasTopLevelOr(inner) {
+			continue
+		}
+		if len(inner) < len(term) {
+			d.collectEqualities(stmt, inner)
+			continue
+		}
+		d.whereEquality(stmt, term)
+	}
+}
+
+func (d *dmlParser) whereEquality(stmt *DMLStatement, term []token) {
+	eq := -1
+	for i, t := range term {
+		if t.kind == tokPunct && t.text == "=" {
+			eq = i
+			break
+		}
+	}
+	if eq == -1 {
+		return
+	}
+	left, right := term[:eq], term[eq+1:]
+
+	if col, ok := columnRef(left); ok {
+		if v, ok := d.literal(right); ok {
+			stmt.Where[col] = v
+		}
+		return
+	}
+	if col, ok := columnRef(right); ok {
+		if v, ok := d.literal(left); ok {
+			stmt.Where[col] = v
+		}
+	}
+}
+
+// expression consome uma expressão até a próxima vírgula ou parêntese de fechamento
+// do mesmo nível e devolve o valor literal, ou Expr com o texto original
+func (d *dmlParser) expression() interface{} {
+	start := d.pos
+	depth := 0
+	for d.pos < len(d.toks) {
+		t := d.toks[d.pos]
+		if t.kind == tokPunct {
+			if t.text == "(" {
+				depth++
+			} else if t.text == ")" {
+				if depth == 0 {
+					break
+				}
+				depth--
+			} else if t.text == "," && depth == 0 {
+				break
+			}
+		}
+		if depth == 0 && t.kind == tokIdent && (t.text == "WHERE" || t.text == "RETURNING" || t.text == "MATCHING") {
+			break
+		}
+		d.pos++
+	}
+	expr := d.toks[start:d.pos]
+	if v, ok := d.literal(expr); ok {
+		return v
+	}
+	return Expr(tokensText(expr))
+}
+
+// literal resolve expressões formadas por um único literal, parâmetro ou NULL
+func (d *dmlParser) literal(expr []token) (interface{}, bool) {
+	expr = stripParens(expr)
+	sign := ""
+	if len(expr) == 2 && expr[0].kind == tokPunct && (expr[0].text == "-" || expr[0].text == "+") && expr[1].kind == tokNumber {
+		sign = expr[0].text
+		expr = expr[1:]
+	}
+	if len(expr) != 1 {
+		return nil, false
+	}
+
+	t := expr[0]
+	switch t.kind {
+	case tokString:
+		return t.text, true
+	case tokNumber:
+		text := strings.TrimPrefix(sign, "+") + t.text
+		if n, err := strconv.ParseInt(text, 10, 64); err == nil {
+			return n, true
+		}
+		return text, true
+	case tokParam:
+		if t.param < len(d.params) {
+			return d.params[t.param], true
+		}
+		return nil, false
+	case tokIdent:
+		if t.text == "NULL" {
+			return nil, true
+		}
+	}
+	return nil, false
+}
+
+func columnRef(expr []token) (string, bool) {
+	expr = stripParens(expr)
+	switch {
+	case len(expr) == 1 && (expr[0].kind == tokIdent && expr[0].text != "NULL" || expr[0].kind == tokQuotedIdent):
+		return expr[0].text, true
+	case len(expr) == 3 && expr[1].kind == tokPunct && expr[1].text == "." &&
+		(expr[2].kind == tokIdent || expr[2].kind == tokQuotedIdent):
+		return expr[2].text, true
+	}
+	return "", false
+}
+
+func stripParens(expr []token) []token {
+	for len(expr) >= 2 && expr[0].kind == tokPunct && expr[0].text == "(" &&
+		expr[len(expr)-1].kind == tokPunct && expr[len(expr)-1].text == ")" && closesAtEnd(expr) {
+		expr = expr[1 : len(expr)-1]
+	}
+	return expr
+}
+
+// closesAtEnd confirma que o parêntese inicial fecha no último token (e não antes)
+func closesAtEnd(expr []token) bool {
+	depth := 0
+	for i, t := range expr {
+		if t.kind != tokPunct {
+			continue
+		}
+		if t.text == "(" {
+			depth++
+		} else if t.text == ")" {
+			depth--
+			if depth == 0 && i != len(expr)-1 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func hasTopLevelOr(expr []token) bool {
+	depth := 0
+	for _, t := range expr {
+		switch {
+		case t.kind == tokPunct && t.text == "(":
+			depth++
+		case t.kind == tokPunct && t.text == ")":
+			depth--
+		case depth == 0 && t.kind == tokIdent && t.text == "OR":
+			return true
+		}
+	}
+	return false
+}
+
+func splitTopLevelAnd(expr []token) [][]token {
+	var parts [][]token
+	depth, start := 0, 0
+	inBetween := false
+	for i, t := range expr {
+		switch {
+		case t.kind == tokPunct && t.text == "(":
+			depth++
+		case t.kind == tokPunct && t.text == ")":
+			depth--
+		case depth == 0 && t.kind == tokIdent && t.text == "BETWEEN":
+			inBetween = true
+		case depth == 0 && t.kind == tokIdent && t.text == "AND":
+			// O primeiro AND após um BETWEEN pertence ao intervalo
+			if inBetween {
+				inBetween = false
+				continue
+			}
+			parts = append(parts, expr[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, expr[start:])
+}
+
+func tokensText(expr []token) string {
+	parts := make([]string, 0, len(expr))
+	for _, t := range expr {
+		switch t.kind {
+		case tokString:
+			parts = append(parts, "'"+strings.ReplaceAll(t.text, "'", "''")+"'")
+		case tokQuotedIdent:
+			parts = append(parts, `"`+t.text+`"`)
+		case tokNamedParam:
+			parts = append(parts, ":"+t.text)
+		default:
+			parts = append(parts, t.text)
+		}
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,190 @@
+package trace
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseDML(t *testing.T) {
+	tests := []struct {
+		name   string
+		sql    string
+		params []interface{}
+		want   *DMLStatement
+	}{
+		{
+			name: "insert com colunas e literais",
+			sql:  "INSERT INTO CLIENTE (CODIGO, NOME, SALDO) VALUES (10, 'D''AVILA', -1.5)",
+			want: &DMLStatement{
+				Type:    EventInsert,
+				Table:   "CLIENTE",
+				Columns: []string{"CODIGO", "NOME", "SALDO"},
+				Values:  []interface{}{int64(10), "D'AVILA", "-1.5"},
+				Where:   map[string]interface{}{},
+			},
+		},
+		{
+			name:   "insert com parâmetros e expressão",
+			sql:    "insert into produto (codigo, dt_cadastro, preco) values (?, current_timestamp, ?)",
+			params: []interface{}{int64(7), "9.90"},
+			want: &DMLStatement{
+				Type:    EventInsert,
+				Table:   "PRODUTO",
+				Columns: []string{"CODIGO", "DT_CADASTRO", "PRECO"},
+				Values:  []interface{}{int64(7), Expr("CURRENT_TIMESTAMP"), "9.90"},
+				Where:   map[string]interface{}{},
+			},
+		},
+		{
+			name: "insert sem lista de colunas",
+			sql:  "INSERT INTO PRODUTO VALUES (1, NULL)",
+			want: &DMLStatement{
+				Type:   EventInsert,
+				Table:  "PRODUTO",
+				Values: []interface{}{int64(1), nil},
+				Where:  map[string]interface{}{},
+			},
+		},
+		{
+			name:   "update com alias e parâmetros",
+			sql:    "UPDATE PRODUTO P SET P.PRECO = P.PRECO * 1.1, DESCRICAO = ? WHERE P.CODIGO = ?",
+			params: []interface{}{"CANETA", int64(42)},
+			want: &DMLStatement{
+				Type:    EventUpdate,
+				Table:   "PRODUTO",
+				Columns: []string{"PRECO", "DESCRICAO"},
+				Values:  []interface{}{Expr("P . PRECO * 1.1"), "CANETA"},
+				Where:   map[string]interface{}{"CODIGO": int64(42)},
+			},
+		},
+		{
+			name: "update or insert com matching",
+			sql:  "UPDATE OR INSERT INTO ESTOQUE (PRODUTO, LOJA, QTD) VALUES (5, 'L1', 3) MATCHING (PRODUTO, LOJA)",
+			want: &DMLStatement{
+				Type:    EventUpdate,
+				Table:   "ESTOQUE",
+				Columns: []string{"PRODUTO", "LOJA", "QTD"},
+				Values:  []interface{}{int64(5), "L1", int64(3)},
+				Where:   map[string]interface{}{},
+			},
+		},
+		{
+			name: "delete com aspas, comentários e pk composta",
+			sql:  "DELETE /* limpeza */ FROM \"ITENS\" WHERE (\"PEDIDO\" = 10) AND 'A' = ITEM -- fim",
+			want: &DMLStatement{
+				Type:  EventDelete,
+				Table: "ITENS",
+				Where: map[string]interface{}{"PEDIDO": int64(10), "ITEM": "A"},
+			},
+		},
+		{
+			name: "or no nível superior invalida as igualdades",
+			sql:  "DELETE FROM PRODUTO WHERE CODIGO = 1 OR CODIGO = 2",
+			want: &DMLStatement{
+				Type:  EventDelete,
+				Table: "PRODUTO",
+				Where: map[string]interface{}{},
+			},
+		},
+		{
+			name: "or entre parênteses só descarta aquele termo",
+			sql:  "DELETE FROM PRODUTO WHERE CODIGO = 1 AND (ATIVO = 'S' OR ATIVO IS NULL)",
+			want: &DMLStatement{
+				Type:  EventDelete,
+				Table: "PRODUTO",
+				Where: map[string]interface{}{"CODIGO": int64(1)},
+			},
+		},
+		{
+			name: "between não quebra o and seguinte",
+			sql:  "UPDATE VENDA SET STATUS = 'F' WHERE DATA BETWEEN '2024-01-01' AND '2024-01-31' AND ID = 9",
+			want: &DMLStatement{
+				Type:    EventUpdate,
+				Table:   "VENDA",
+				Columns: []string{"STATUS"},
+				Values:  []interface{}{"F"},
+				Where:   map[string]interface{}{"ID": int64(9)},
+			},
+		},
+		{
+			name:   "parâmetro não impresso pelo trace",
+			sql:    "DELETE FROM PRODUTO WHERE CODIGO = ? RETURNING CODIGO",
+			params: nil,
+			want: &DMLStatement{
+				Type:  EventDelete,
+				Table: "PRODUTO",
+				Where: map[string]interface{}{},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseDML(tt.sql, tt.params)
+			if err != nil {
+				t.Fatalf("ParseDML: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseDML(%q)\n got  %#v\n want %#v", tt.sql, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDMLErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  string
+	}{
+		{"select", "SELECT * FROM PRODUTO"},
+		{"string não terminada", "DELETE FROM PRODUTO WHERE NOME = 'ABC"},
+		{"comentário não terminado", "DELETE FROM PRODUTO /* WHERE CODIGO = 1"},
+		{"aspas não terminadas", "DELETE FROM \"PRODUTO WHERE CODIGO = 1"},
+		{"colunas e valores diferentes", "INSERT INTO PRODUTO (CODIGO, NOME) VALUES (1)"},
+		{"insert com select", "INSERT INTO PRODUTO (CODIGO) SELECT CODIGO FROM TMP"},
+		{"update sem set", "UPDATE PRODUTO WHERE CODIGO = 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseDML(tt.sql, nil); err == nil {
+				t.Errorf("ParseDML(%q) deveria falhar", tt.sql)
+			}
+		})
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want []token
+	}{
+		{
+			sql: "a.b<>:x||?",
+			want: []token{
+				{kind: tokIdent, text: "A"}, {kind: tokPunct, text: "."}, {kind: tokIdent, text: "B"},
+				{kind: tokPunct, text: "<>"}, {kind: tokNamedParam, text: "X"}, {kind: tokPunct, text: "||"},
+				{kind: tokParam, text: "?", param: 0},
+			},
+		},
+		{
+			sql: "1.5e-3 .5 ? ?",
+			want: []token{
+				{kind: tokNumber, text: "1.5e-3"}, {kind: tokNumber, text: ".5"},
+				{kind: tokParam, text: "?", param: 0}, {kind: tokParam, text: "?", param: 1},
+			},
+		},
+		{
+			sql:  "'it''s' \"Mixed Case\"",
+			want: []token{{kind: tokString, text: "it's"}, {kind: tokQuotedIdent, text: "Mixed Case"}},
+		},
+	}
+	for _, tt := range tests {
+		got, err := tokenize(tt.sql)
+		if err != nil {
+			t.Fatalf("tokenize(%q): %v", tt.sql, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("tokenize(%q)\n got  %#v\n want %#v", tt.sql, got, tt.want)
+		}
+	}
+}
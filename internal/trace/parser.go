@@ -1,8 +1,9 @@
 package trace
 
 import (
-	"fmt"
+	"log"
 	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 )
@@ -20,6 +21,7 @@ type TraceEvent struct {
 	Table   string
 	SQL     string
 	TransID string
+	Params  []interface{} // Valores dos parâmetros (param0, param1...) quando o trace os imprime
 }
 
 type Parser struct {
@@ -33,6 +35,9 @@ type Parser struct {
 	// Estado atual por conexão
 	lastEventPerConn map[string]string      // ConnID -> Last Event Name
 	lastOPPerConn    map[string]*TraceEvent // ConnID -> Evento sendo montado
+	transPerConn     map[string]string      // ConnID -> TransID do statement corrente (formato "(TRA_n, ...)")
+	sqlOpenPerConn   map[string]bool        // ConnID -> texto do statement ainda sendo lido
+	currentConn      string                 // ConnID do último cabeçalho lido
 
 	// Regexes
 	reHeader      *regexp.Regexp
@@ -40,6 +45,10 @@ type Parser struct {
 	reTransaction *regexp.Regexp
 	reStatement   *regexp.Regexp
 	reCommit      *regexp.Regexp
+	reTraID       *regexp.Regexp
+	reTransLine   *regexp.Regexp
+	reParam       *regexp.Regexp
+	reFetched     *regexp.Regexp
 
 	onCommit     func(transID string, events []*TraceEvent)
 	targetDBBase string
@@ -54,12 +63,18 @@ func NewParser(targetDBBase, ownAppName string, onCommit func(string, []*TraceEv
 		pendingEvents:    make(map[string][]*TraceEvent),
 		lastEventPerConn: make(map[string]string),
 		lastOPPerConn:    make(map[string]*TraceEvent),
+		transPerConn:     make(map[string]string),
+		sqlOpenPerConn:   make(map[string]bool),
 
 		reHeader:      regexp.MustCompile(`^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{4}\s+\((\d+):(\w+)\)\s+([A-Z_]+)`),
 		reAttach:      regexp.MustCompile(`^\s+([a-zA-Z]:.*|\/.*)\s+\(user.*`),
 		reTransaction: regexp.MustCompile(`^\s+Transaction\s+(\d+)`),
-		reStatement:   regexp.MustCompile(`(?i)^\s+(INSERT|UPDATE|DELETE)\s+INTO\s+([A-Z0-9_$]+)`),
+		reStatement:   regexp.MustCompile(`(?i)^\s*(INSERT|UPDATE|DELETE)\s+(?:OR\s+INSERT\s+)?(?:INTO\s+|FROM\s+)?"?([A-Z0-9_$]+)"?`),
 		reCommit:      regexp.MustCompile(`^\s+Transaction\s+(\d+),\s+duration`),
+		reTraID:       regexp.MustCompile(`^\s*\(TRA_(\d+),`),
+		reTransLine:   regexp.MustCompile(`Transaction\s+(\d+)`),
+		reParam:       regexp.MustCompile(`^\s*param(\d+)\s*=\s*(.*)$`),
+		reFetched:     regexp.MustCompile(`^\s*\d+\s+records?\s+fetched`),
 
 		ownAppName: ownAppName,
 		onCommit:   onCommit,
@@ -86,10 +101,6 @@ func (p *Parser) extractBaseName(dsn string) string {
 }
 
 func (p *Parser) ParseLine(line string) {
-	if strings.TrimSpace(line) != "" {
-		fmt.Printf("[TRACE RAW] %s\n", line)
-	}
-
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
@@ -97,85 +108,236 @@ func (p *Parser) ParseLine(line string) {
 	if len(matches) > 3 {
 		connID := matches[1]
 		event := matches[3]
+		// Um cabeçalho encerra o bloco anterior, que só vale para a conexão dele
+		p.flushOp(p.currentConn)
+		delete(p.lastEventPerConn, p.currentConn)
+		p.currentConn = connID
 		p.lastEventPerConn[connID] = event
 
 		if event == "DETACH_DATABASE" {
-			delete(p.connections, connID)
+			p.detach(connID)
 		}
 		return
 	}
 
-	for connID, lastEvent := range p.lastEventPerConn {
-		trimmed := strings.TrimSpace(line)
-		if trimmed == "" {
-			continue
-		}
+	// As linhas seguintes ao cabeçalho pertencem só à conexão dele
+	connID := p.currentConn
+	lastEvent, ok := p.lastEventPerConn[connID]
+	trimmed := strings.TrimSpace(line)
+	if !ok || trimmed == "" {
+		return
+	}
 
-		switch lastEvent {
-		case "ATTACH_DATABASE":
-			if m := p.reAttach.FindStringSubmatch(line); len(m) > 1 {
-				path := strings.ToUpper(m[1])
-				p.connections[connID] = path
-				if appIdx := strings.Index(line, "Application "); appIdx != -1 {
-					appPart := line[appIdx+12:]
-					if endIdx := strings.Index(appPart, ","); endIdx != -1 {
-						p.appNames[connID] = strings.TrimSpace(appPart[:endIdx])
-					}
+	switch lastEvent {
+	case "ATTACH_DATABASE":
+		if m := p.reAttach.FindStringSubmatch(line); len(m) > 1 {
+			path := strings.ToUpper(m[1])
+			p.connections[connID] = path
+			if appIdx := strings.Index(line, "Application "); appIdx != -1 {
+				appPart := line[appIdx+12:]
+				if endIdx := strings.Index(appPart, ","); endIdx != -1 {
+					p.appNames[connID] = strings.TrimSpace(appPart[:endIdx])
 				}
-				delete(p.lastEventPerConn, connID)
 			}
+			delete(p.lastEventPerConn, connID)
+		}
 
-		case "START_TRANSACTION":
-			if m := p.reTransaction.FindStringSubmatch(line); len(m) > 1 {
-				transID := m[1]
-				p.transactions[transID] = connID
-				delete(p.lastEventPerConn, connID)
-			}
+	case "START_TRANSACTION":
+		if m := p.matchTransaction(p.reTransaction, line); len(m) > 1 {
+			transID := m[1]
+			p.transactions[transID] = connID
+			delete(p.lastEventPerConn, connID)
+		}
 
-		case "EXECUTE_STATEMENT_FINISH":
+	case "EXECUTE_STATEMENT_FINISH":
+		if m := p.reTraID.FindStringSubmatch(line); len(m) > 1 {
+			p.transPerConn[connID] = m[1]
+			return
+		}
+		if _, building := p.lastOPPerConn[connID]; !building {
 			if m := p.reStatement.FindStringSubmatch(line); len(m) > 2 {
 				p.lastOPPerConn[connID] = &TraceEvent{
-					Type:  EventType(strings.ToUpper(m[1])),
-					Table: strings.ToUpper(m[2]),
-					SQL:   trimmed,
+					Type:    EventType(strings.ToUpper(m[1])),
+					Table:   strings.ToUpper(m[2]),
+					SQL:     trimmed,
+					TransID: p.transPerConn[connID],
 				}
+				p.sqlOpenPerConn[connID] = true
+				return
 			}
-			if m := regexp.MustCompile(`Transaction\s+(\d+)`).FindStringSubmatch(line); len(m) > 1 {
-				transID := m[1]
-				if op, ok := p.lastOPPerConn[connID]; ok {
-					op.TransID = transID
-					p.pendingEvents[transID] = append(p.pendingEvents[transID], op)
-					delete(p.lastOPPerConn, connID)
-				}
-				delete(p.lastEventPerConn, connID)
+		} else if p.appendStatementLine(connID, trimmed) {
+			if _, building := p.lastOPPerConn[connID]; !building {
+				delete(p.lastEventPerConn, connID) // Rodapé "records fetched": fim do bloco
 			}
-
-		case "COMMIT_TRANSACTION":
-			if m := p.reCommit.FindStringSubmatch(line); len(m) > 1 {
-				transID := m[1]
-				p.handleCommit(transID)
-				delete(p.lastEventPerConn, connID)
+			return
+		}
+		if m := p.reTransLine.FindStringSubmatch(line); len(m) > 1 {
+			transID := m[1]
+			if op, ok := p.lastOPPerConn[connID]; ok {
+				op.TransID = transID
+				p.pendingEvents[transID] = append(p.pendingEvents[transID], op)
+				delete(p.lastOPPerConn, connID)
 			}
+			delete(p.lastEventPerConn, connID)
+		}
+
+	case "COMMIT_TRANSACTION", "COMMIT_RETAINING":
+		if m := p.matchTransaction(p.reCommit, line); len(m) > 1 {
+			transID := m[1]
+			p.handleCommit(transID, lastEvent == "COMMIT_RETAINING")
+			delete(p.lastEventPerConn, connID)
+		}
+
+	case "ROLLBACK_TRANSACTION", "ROLLBACK_RETAINING":
+		if m := p.matchTransaction(p.reCommit, line); len(m) > 1 {
+			transID := m[1]
+			p.handleRollback(transID, lastEvent == "ROLLBACK_RETAINING")
+			delete(p.lastEventPerConn, connID)
 		}
 	}
 }
 
-func (p *Parser) handleCommit(transID string) {
-	connID, ok := p.transactions[transID]
+// matchTransaction aceita tanto o formato "Transaction N" quanto o "(TRA_N, ...)" do fbtracemgr
+func (p *Parser) matchTransaction(re *regexp.Regexp, line string) []string {
+	if m := re.FindStringSubmatch(line); len(m) > 1 {
+		return m
+	}
+	return p.reTraID.FindStringSubmatch(line)
+}
+
+// appendStatementLine trata as linhas seguintes ao início de um statement:
+// continuação do SQL, separador "^^^", parâmetros "paramN = tipo, valor" e o
+// rodapé "N records fetched" que encerra o bloco.
+func (p *Parser) appendStatementLine(connID, trimmed string) bool {
+	op := p.lastOPPerConn[connID]
+
+	if strings.HasPrefix(trimmed, "^^^") {
+		p.sqlOpenPerConn[connID] = false
+		return true
+	}
+	if m := p.reParam.FindStringSubmatch(trimmed); len(m) > 2 {
+		p.sqlOpenPerConn[connID] = false
+		idx, _ := strconv.Atoi(m[1])
+		for len(op.Params) <= idx {
+			op.Params = append(op.Params, nil)
+		}
+		op.Params[idx] = parseParamValue(m[2])
+		return true
+	}
+	if p.reFetched.MatchString(trimmed) {
+		p.sqlOpenPerConn[connID] = false
+		p.flushOp(connID)
+		return true
+	}
+	if p.sqlOpenPerConn[connID] {
+		op.SQL += "\n" + trimmed
+		return true
+	}
+	return false
+}
+
+// flushOp move o statement montado da conexão para o buffer da sua transação.
+// Sem transação identificada o statement é descartado, para não travar a conexão.
+func (p *Parser) flushOp(connID string) {
+	op, ok := p.lastOPPerConn[connID]
 	if !ok {
 		return
 	}
-	if p.appNames[connID] == p.ownAppName {
+	if op.TransID == "" {
+		log.Printf("[TRACE] Aviso: %s em %s sem transação identificada no trace. Descartado.", op.Type, op.Table)
+	} else {
+		p.pendingEvents[op.TransID] = append(p.pendingEvents[op.TransID], op)
+	}
+	delete(p.lastOPPerConn, connID)
+	delete(p.sqlOpenPerConn, connID)
+	delete(p.transPerConn, connID)
+}
+
+// parseParamValue converte o texto de um parâmetro do trace ("integer, \"10\"",
+// "varchar(60), \"JOAO\"", "date, <NULL>") para um valor Go
+func parseParamValue(raw string) interface{} {
+	depth := 0
+	sep := -1
+	for i, ch := range raw {
+		switch ch {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 && sep == -1 {
+				sep = i
+			}
+		}
+	}
+	if sep == -1 {
+		return nil
+	}
+
+	typ := strings.ToLower(strings.TrimSpace(raw[:sep]))
+	val := strings.TrimSpace(raw[sep+1:])
+	if val == "<NULL>" {
+		return nil
+	}
+	if len(val) >= 2 && strings.HasPrefix(val, "\"") && strings.HasSuffix(val, "\"") {
+		val = val[1 : len(val)-1]
+	}
+
+	switch {
+	case strings.HasPrefix(typ, "smallint"), strings.HasPrefix(typ, "integer"), strings.HasPrefix(typ, "bigint"):
+		if n, err := strconv.ParseInt(val, 10, 64); err == nil {
+			return n
+		}
+	}
+	return val
+}
+
+// handleCommit entrega os eventos da transação commitada. Num COMMIT RETAINING a
+// transação continua aberta e só o buffer é esvaziado.
+func (p *Parser) handleCommit(transID string, retaining bool) {
+	defer p.endTransaction(transID, retaining)
+
+	connID, ok := p.transactions[transID]
+	if !ok || p.appNames[connID] == p.ownAppName {
 		return
 	}
 	dbPath := p.connections[connID]
 	if !strings.Contains(strings.ToUpper(dbPath), p.targetDBBase) {
 		return
 	}
-	events := p.pendingEvents[transID]
-	if len(events) > 0 {
+	if events := p.pendingEvents[transID]; len(events) > 0 {
 		p.onCommit(transID, events)
 	}
+}
+
+// handleRollback descarta os eventos da transação desfeita
+func (p *Parser) handleRollback(transID string, retaining bool) {
+	if n := len(p.pendingEvents[transID]); n > 0 {
+		log.Printf("[TRACE] Transação %s desfeita: %d evento(s) descartado(s)", transID, n)
+	}
+	p.endTransaction(transID, retaining)
+}
+
+// endTransaction limpa o buffer da transação (e o registro dela, se terminou)
+func (p *Parser) endTransaction(transID string, retaining bool) {
 	delete(p.pendingEvents, transID)
-	delete(p.transactions, transID)
+	if !retaining {
+		delete(p.transactions, transID)
+	}
+}
+
+// detach limpa o estado da conexão encerrada. Transações ainda abertas são
+// desfeitas pelo servidor, então os eventos delas também são descartados.
+func (p *Parser) detach(connID string) {
+	delete(p.connections, connID)
+	delete(p.appNames, connID)
+	delete(p.lastEventPerConn, connID)
+	delete(p.lastOPPerConn, connID)
+	delete(p.sqlOpenPerConn, connID)
+	delete(p.transPerConn, connID)
+	for transID, owner := range p.transactions {
+		if owner == connID {
+			p.handleRollback(transID, false)
+		}
+	}
 }
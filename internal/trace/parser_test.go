@@ -0,0 +1,185 @@
+package trace
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Trechos no formato do fbtracemgr (Firebird 2.5) para a conexão 100 do ERP
+const (
+	traceAttach = `2024-01-15T10:00:00.0001 (100:0x7f01) ATTACH_DATABASE
+	/dados/ERP.FDB (user SYSDBA, Application ERP.EXE, protocol TCPv4)
+`
+	traceStart = `2024-01-15T10:00:00.0002 (100:0x7f01) START_TRANSACTION
+	/dados/ERP.FDB (user SYSDBA, Application ERP.EXE, protocol TCPv4)
+		(TRA_%s, CONCURRENCY | WAIT | READ_WRITE)
+`
+	traceUpdate = `2024-01-15T10:00:00.0003 (100:0x7f01) EXECUTE_STATEMENT_FINISH
+	/dados/ERP.FDB (user SYSDBA, Application ERP.EXE, protocol TCPv4)
+		(TRA_%s, CONCURRENCY | WAIT | READ_WRITE)
+
+Statement 55:
+-------------------------------------------------------------------------------
+UPDATE PRODUTO SET PRECO = ?
+WHERE CODIGO = ?
+^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^
+param0 = numeric(15,2), "10.50"
+param1 = integer, "7"
+
+1 records fetched
+      0 ms, 2 read(s), 1 write(s)
+`
+	traceCommit = `2024-01-15T10:00:00.0004 (100:0x7f01) %s
+	/dados/ERP.FDB (user SYSDBA, Application ERP.EXE, protocol TCPv4)
+		(TRA_%s, CONCURRENCY | WAIT | READ_WRITE)
+      0 ms
+`
+	traceDetach = `2024-01-15T10:00:00.0005 (100:0x7f01) DETACH_DATABASE
+	/dados/ERP.FDB (user SYSDBA, Application ERP.EXE, protocol TCPv4)
+`
+)
+
+func traceBlock(format, arg string) string {
+	return strings.Replace(format, "%s", arg, 1)
+}
+
+func traceEnd(event, transID string) string {
+	return strings.Replace(strings.Replace(traceCommit, "%s", event, 1), "%s", transID, 1)
+}
+
+// onConn200 troca a conexão do trecho para a 200
+func onConn200(block string) string {
+	return strings.Replace(block, "(100:0x7f01)", "(200:0x7f02)", 1)
+}
+
+func TestParserTransactions(t *testing.T) {
+	tests := []struct {
+		name  string
+		app   string // Nome da aplicação do próprio agente
+		trace []string
+		want  map[string]int // Transação entregue -> quantidade de eventos
+	}{
+		{
+			name:  "commit entrega os eventos",
+			trace: []string{traceAttach, traceBlock(traceStart, "1"), traceBlock(traceUpdate, "1"), traceEnd("COMMIT_TRANSACTION", "1")},
+			want:  map[string]int{"1": 1},
+		},
+		{
+			name:  "rollback descarta os eventos",
+			trace: []string{traceAttach, traceBlock(traceStart, "2"), traceBlock(traceUpdate, "2"), traceEnd("ROLLBACK_TRANSACTION", "2")},
+			want:  map[string]int{},
+		},
+		{
+			name: "commit retaining entrega e mantém a transação aberta",
+			trace: []string{traceAttach, traceBlock(traceStart, "3"), traceBlock(traceUpdate, "3"), traceEnd("COMMIT_RETAINING", "3"),
+				traceBlock(traceUpdate, "3"), traceBlock(traceUpdate, "3"), traceEnd("COMMIT_TRANSACTION", "3")},
+			want: map[string]int{"3": 2}, // A última entrega (2 eventos) sobrescreve a primeira
+		},
+		{
+			name: "rollback retaining descarta só o que veio antes",
+			trace: []string{traceAttach, traceBlock(traceStart, "4"), traceBlock(traceUpdate, "4"), traceEnd("ROLLBACK_RETAINING", "4"),
+				traceBlock(traceUpdate, "4"), traceEnd("COMMIT_TRANSACTION", "4")},
+			want: map[string]int{"4": 1},
+		},
+		{
+			name:  "detach desfaz a transação aberta",
+			trace: []string{traceAttach, traceBlock(traceStart, "5"), traceBlock(traceUpdate, "5"), traceDetach, traceEnd("COMMIT_TRANSACTION", "5")},
+			want:  map[string]int{},
+		},
+		{
+			name: "conexões intercaladas não misturam statements",
+			trace: []string{traceAttach, onConn200(traceAttach), traceBlock(traceStart, "10"), onConn200(traceBlock(traceStart, "20")),
+				traceBlock(traceUpdate, "10"), onConn200(traceBlock(traceUpdate, "20")), traceBlock(traceUpdate, "10"),
+				traceEnd("COMMIT_TRANSACTION", "10"), onConn200(traceEnd("COMMIT_TRANSACTION", "20"))},
+			want: map[string]int{"10": 2, "20": 1},
+		},
+		{
+			name:  "transações do próprio agente são ignoradas",
+			app:   "ERP.EXE",
+			trace: []string{traceAttach, traceBlock(traceStart, "6"), traceBlock(traceUpdate, "6"), traceEnd("COMMIT_TRANSACTION", "6")},
+			want:  map[string]int{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := make(map[string]int)
+			p := NewParser("localhost:/dados/ERP.FDB", tt.app, func(transID string, events []*TraceEvent) {
+				got[transID] = len(events)
+			})
+			for _, block := range tt.trace {
+				for _, line := range strings.Split(block, "\n") {
+					p.ParseLine(line)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("transações entregues = %v, esperado %v", got, tt.want)
+			}
+			if len(p.pendingEvents) != 0 || len(p.lastOPPerConn) != 0 || len(p.lastEventPerConn) > 1 {
+				t.Errorf("estado residual: pendentes=%d statements=%d blocos=%d", len(p.pendingEvents), len(p.lastOPPerConn), len(p.lastEventPerConn))
+			}
+		})
+	}
+}
+
+func TestParserStatement(t *testing.T) {
+	var got []*TraceEvent
+	p := NewParser("localhost:/dados/ERP.FDB", "", func(transID string, events []*TraceEvent) {
+		got = events
+	})
+	for _, block := range []string{traceAttach, traceBlock(traceStart, "9"), traceBlock(traceUpdate, "9"), traceEnd("COMMIT_TRANSACTION", "9")} {
+		for _, line := range strings.Split(block, "\n") {
+			p.ParseLine(line)
+		}
+	}
+
+	want := []*TraceEvent{{
+		Type:    EventUpdate,
+		Table:   "PRODUTO",
+		SQL:     "UPDATE PRODUTO SET PRECO = ?\nWHERE CODIGO = ?",
+		TransID: "9",
+		Params:  []interface{}{"10.50", int64(7)},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("eventos = %#v, esperado %#v", got, want)
+	}
+}
+
+func TestParserStatementWithoutTransaction(t *testing.T) {
+	var got []*TraceEvent
+	p := NewParser("localhost:/dados/ERP.FDB", "", func(transID string, events []*TraceEvent) {
+		got = append(got, events...)
+	})
+
+	// Statement sem a linha (TRA_n, ...): é descartado e não trava os seguintes da conexão
+	orphan := strings.Replace(traceBlock(traceUpdate, "0"), "\t\t(TRA_0, CONCURRENCY | WAIT | READ_WRITE)\n", "", 1)
+	orphan = strings.Replace(orphan, "1 records fetched\n", "", 1)
+	for _, block := range []string{traceAttach, traceBlock(traceStart, "7"), orphan, traceBlock(traceUpdate, "7"), traceEnd("COMMIT_TRANSACTION", "7")} {
+		for _, line := range strings.Split(block, "\n") {
+			p.ParseLine(line)
+		}
+	}
+	if len(got) != 1 || got[0].TransID != "7" {
+		t.Fatalf("eventos = %#v, esperado 1 evento da transação 7", got)
+	}
+}
+
+func TestParseParamValue(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want interface{}
+	}{
+		{`integer, "10"`, int64(10)},
+		{`bigint, "-5"`, int64(-5)},
+		{`varchar(60), "JOAO, DA SILVA"`, "JOAO, DA SILVA"},
+		{`numeric(15,2), "1.25"`, "1.25"},
+		{`date, <NULL>`, nil},
+		{`sem separador`, nil},
+	}
+	for _, tt := range tests {
+		if got := parseParamValue(tt.raw); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseParamValue(%q) = %#v, esperado %#v", tt.raw, got, tt.want)
+		}
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"strings"
 
 	"github.com/atsinformatica/firebird-sync-agent/internal/config"
+	"github.com/atsinformatica/firebird-sync-agent/internal/db"
 	_ "github.com/nakagami/firebirdsql"
 )
 
@@ -234,7 +235,7 @@ webhook:
 integracao:
   retry_interval_seconds: %d
   batch_size: 50
-  max_retries: 5
+  retry_max: 5
   timeout_seconds: 10
 `, p.NodeID, p.DBPath, p.RemoteURL, p.IntervalSeconds)
 
@@ -281,87 +282,32 @@ integracao:
 	json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
 }
 
-// InstallTriggers garante a existência da tabela de fila e instala as triggers nas tabelas selecionadas
-func InstallTriggers(db *sql.DB, tables []string) error {
-	log.Println("[INFO] Verificando/Criando tabela FILA_INTEGRACAO...")
-	_, err := db.Exec(`CREATE TABLE FILA_INTEGRACAO (
-    ID INTEGER NOT NULL PRIMARY KEY,
-    EVENT_ID CHAR(36) NOT NULL,
-    TABELA VARCHAR(31) NOT NULL,
-    OPERACAO CHAR(1) NOT NULL,
-    PK_JSON BLOB SUB_TYPE TEXT,
-    PAYLOAD_JSON BLOB SUB_TYPE TEXT,
-    ORIGEM VARCHAR(20),
-    STATUS CHAR(1) DEFAULT 'P',
-    TENTATIVAS INTEGER DEFAULT 0,
-    DT_EVENTO TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    DT_ULT_ENVIO TIMESTAMP,
-    ERRO_MSG BLOB SUB_TYPE TEXT
-	)`)
-	if err != nil {
-		log.Printf("[DEBUG] Nota: FILA_INTEGRACAO pode já existir: %v", err)
-	}
-
-	if _, err := db.Exec("CREATE GENERATOR GEN_FILA_INTEGRACAO_ID"); err != nil {
-		log.Printf("[DEBUG] Nota: Generator pode já existir: %v", err)
-	}
+// TriggerVersion identifica o formato das triggers geradas por InstallTriggers.
+// Incrementar sempre que o corpo gerado mudar, para saber quais bancos precisam reinstalar.
+const TriggerVersion = 5
 
-	_, err = db.Exec(`CREATE TRIGGER TRG_FILA_INTEGRACAO_BI FOR FILA_INTEGRACAO ACTIVE BEFORE INSERT POSITION 0 AS BEGIN 
-		IF (NEW.ID IS NULL) THEN NEW.ID = GEN_ID(GEN_FILA_INTEGRACAO_ID, 1); END`)
-	if err != nil {
-		log.Printf("[DEBUG] Nota: Trigger BI pode já existir: %v", err)
-	}
+// triggerVersionMarker é gravado como comentário no corpo da trigger
+const triggerVersionMarker = "SYNC_AGENT_TRIGGER_VERSION"
 
-	// Tabelas para Multi-Cliente (Broadcast)
-	_, _ = db.Exec(`CREATE TABLE SYNC_NODES (
-		NODE_ID VARCHAR(20) NOT NULL PRIMARY KEY,
-		NODE_NAME VARCHAR(100),
-		REMOTE_URL VARCHAR(255) NOT NULL,
-		LAST_SEEN TIMESTAMP,
-		ACTIVE CHAR(1) DEFAULT 'S' CHECK (ACTIVE IN ('S', 'N'))
-	)`)
-
-	_, _ = db.Exec(`CREATE TABLE FILA_DESTINOS (
-		ID INTEGER NOT NULL PRIMARY KEY,
-		FILA_ID INTEGER NOT NULL,
-		NODE_ID VARCHAR(20) NOT NULL,
-		STATUS CHAR(1) DEFAULT 'P',
-		TENTATIVAS INTEGER DEFAULT 0,
-		ERRO_MSG BLOB SUB_TYPE TEXT,
-		DT_ULT_TENTATIVA TIMESTAMP
-	)`)
-
-	_, _ = db.Exec("CREATE GENERATOR GEN_FILA_DESTINOS_ID")
-
-	_, _ = db.Exec(`CREATE TRIGGER TRG_FILA_DESTINOS_BI FOR FILA_DESTINOS ACTIVE BEFORE INSERT POSITION 0 AS BEGIN 
-		IF (NEW.ID IS NULL) THEN NEW.ID = GEN_ID(GEN_FILA_DESTINOS_ID, 1); END`)
-
-	// Campos técnicos que o ERP muda e NÃO devem disparar nova captura
-	ignoreFields := map[string]bool{
-		"FLAGINTEGRACAO":      true,
-		"SINCRONIZADO":        true,
-		"SYNC_TS":             true,
-		"DATA_ULT_ALTERACAO":  true,
-		"HORA_ULT_ALTERACAO":  true,
-		"DATA_ULT_SINCRONIZA": true,
-		"HORA_ULT_SINCRONIZA": true,
-		"VERSION":             true,
-		"USUARIO_ALT":         true,
-	}
+// InstallTriggers garante a existência da tabela de fila e instala as triggers nas tabelas selecionadas
+func InstallTriggers(dbConn *sql.DB, tables []string) error {
+	EnsureQueueSchema(dbConn)
 
 	for _, tableName := range tables {
-		rows, err := db.Query(`SELECT TRIM(R.RDB$FIELD_NAME) FROM RDB$RELATION_FIELDS R WHERE R.RDB$RELATION_NAME = ? ORDER BY R.RDB$FIELD_POSITION`, tableName)
+		colInfos, err := db.GetColumns(dbConn, tableName)
 		if err != nil {
 			return err
 		}
 
 		var cols []string
-		for rows.Next() {
-			var c string
-			rows.Scan(&c)
-			cols = append(cols, c)
+		colByName := make(map[string]db.ColumnInfo)
+		for _, c := range colInfos {
+			if c.Computed {
+				continue
+			}
+			cols = append(cols, c.Name)
+			colByName[c.Name] = c
 		}
-		rows.Close()
 
 		if len(cols) == 0 {
 			continue
@@ -370,7 +316,7 @@ func InstallTriggers(db *sql.DB, tables []string) error {
 		// Detecção de mudanças usando IS DISTINCT FROM (mais robusto no FB 2.5)
 		var changeChecks []string
 		for _, col := range cols {
-			if ignoreFields[strings.ToUpper(col)] {
+			if db.TechnicalFields[strings.ToUpper(col)] {
 				continue
 			}
 			// IS DISTINCT FROM trata NULLs automaticamente
@@ -383,7 +329,7 @@ func InstallTriggers(db *sql.DB, tables []string) error {
 			changeCondition = "1=1"
 		}
 
-		pkColRows, err := db.Query(`
+		pkColRows, err := dbConn.Query(`
 			SELECT TRIM(s.RDB$FIELD_NAME)
 			FROM RDB$RELATION_CONSTRAINTS c
 			JOIN RDB$INDEX_SEGMENTS s ON c.RDB$INDEX_NAME = s.RDB$INDEX_NAME
@@ -402,7 +348,7 @@ func InstallTriggers(db *sql.DB, tables []string) error {
 		pkColRows.Close()
 
 		if len(pkCols) == 0 {
-			uniRows, _ := db.Query(`
+			uniRows, _ := dbConn.Query(`
 				SELECT FIRST 1 TRIM(s.RDB$FIELD_NAME)
 				FROM RDB$RELATION_CONSTRAINTS c
 				JOIN RDB$INDEX_SEGMENTS s ON c.RDB$INDEX_NAME = s.RDB$INDEX_NAME
@@ -423,21 +369,39 @@ func InstallTriggers(db *sql.DB, tables []string) error {
 			pkCols = append(pkCols, cols[0])
 		}
 
-		var jsonParts []string
-		for i, col := range cols {
-			part := fmt.Sprintf(" '\"%s\": \"' || COALESCE(CAST(NEW.%s AS VARCHAR(200)), '') || '\"'", col, col)
-			jsonParts = append(jsonParts, part)
-			if i < len(cols)-1 {
-				jsonParts = append(jsonParts, " || ',' || ")
+		// JSON tipado montado campo a campo sobre o BLOB (evita o limite de 32K do VARCHAR)
+		var payloadStmts []string
+		sep := ""
+		for _, col := range cols {
+			expr, err := jsonValueExpr("NEW", colByName[col])
+			if err != nil {
+				return fmt.Errorf("erro trigger %s: %w", tableName, err)
 			}
+			if expr == "" {
+				continue
+			}
+			payloadStmts = append(payloadStmts, fmt.Sprintf("PAYLOAD = PAYLOAD || '%s\"%s\":' || COALESCE(%s, 'null');", sep, col, expr))
+			sep = ","
 		}
-		jsonPayload := strings.Join(jsonParts, "")
+		jsonPayload := strings.Join(payloadStmts, "\n\t\t\t\t")
 
 		var pkOldParts []string
 		var pkNewParts []string
 		for i, col := range pkCols {
-			pkOldParts = append(pkOldParts, fmt.Sprintf(" '\"%s\": \"' || COALESCE(CAST(OLD.%s AS VARCHAR(100)), '') || '\"'", col, col))
-			pkNewParts = append(pkNewParts, fmt.Sprintf(" '\"%s\": \"' || COALESCE(CAST(NEW.%s AS VARCHAR(100)), '') || '\"'", col, col))
+			info, ok := colByName[col]
+			if !ok {
+				info = db.ColumnInfo{Name: col, FieldType: db.FieldVarchar, Length: 100}
+			}
+			oldExpr, err := jsonValueExpr("OLD", info)
+			if err == nil && oldExpr == "" {
+				err = fmt.Errorf("coluna %s da chave não pode ser BLOB", col)
+			}
+			if err != nil {
+				return fmt.Errorf("erro trigger %s: %w", tableName, err)
+			}
+			newExpr, _ := jsonValueExpr("NEW", info)
+			pkOldParts = append(pkOldParts, fmt.Sprintf(" '\"%s\":' || COALESCE(%s, 'null')", col, oldExpr))
+			pkNewParts = append(pkNewParts, fmt.Sprintf(" '\"%s\":' || COALESCE(%s, 'null')", col, newExpr))
 			if i < len(pkCols)-1 {
 				pkOldParts = append(pkOldParts, " || ',' || ")
 				pkNewParts = append(pkNewParts, " || ',' || ")
@@ -457,8 +421,9 @@ func InstallTriggers(db *sql.DB, tables []string) error {
 		AS
 		DECLARE VARIABLE OP CHAR(1);
 		DECLARE VARIABLE PAYLOAD BLOB SUB_TYPE TEXT;
-		DECLARE VARIABLE PK_VAL VARCHAR(1000);
+		DECLARE VARIABLE PK_VAL VARCHAR(2000);
 		BEGIN
+			-- %s %d
 			IF (UPDATING) THEN
 			BEGIN
 				-- Idempotência: só entra se algo útil mudou
@@ -470,21 +435,256 @@ func InstallTriggers(db *sql.DB, tables []string) error {
 			ELSE OP = 'D';
 
 			IF (OP IN ('I', 'U')) THEN
-				PAYLOAD = '{' || %s || '}';
+			BEGIN
+				PAYLOAD = '{';
+				%s
+				PAYLOAD = PAYLOAD || '}';
+			END
 			ELSE
 				PAYLOAD = NULL;
 
 			IF (OP = 'D') THEN PK_VAL = '{' || %s || '}';
 			ELSE PK_VAL = '{' || %s || '}';
 
-			INSERT INTO FILA_INTEGRACAO (EVENT_ID, TABELA, OPERACAO, PK_JSON, PAYLOAD_JSON, ORIGEM)
-			VALUES (UUID_TO_CHAR(GEN_UUID()), '%s', :OP, :PK_VAL, :PAYLOAD, 'TRIGGER');
+			INSERT INTO FILA_INTEGRACAO (EVENT_ID, TABELA, OPERACAO, PK_JSON, PAYLOAD_JSON, ORIGEM, TRANSACAO_ID)
+			VALUES (UUID_TO_CHAR(GEN_UUID()), '%s', :OP, :PK_VAL, :PAYLOAD, 'TRIGGER', CURRENT_TRANSACTION);
 		END
-		`, triggerName, tableName, changeCondition, jsonPayload, pkOldJSON, pkNewJSON, tableName)
+		`, triggerName, tableName, triggerVersionMarker, TriggerVersion, changeCondition, jsonPayload, pkOldJSON, pkNewJSON, tableName)
 
-		if _, err := db.Exec(sql); err != nil {
+		if _, err := dbConn.Exec(sql); err != nil {
 			return fmt.Errorf("erro trigger %s: %v", tableName, err)
 		}
+
+		// Tabela com trigger passa a ser aceita no recebimento (/sync), sem reativar as desligadas manualmente
+		if _, err := dbConn.Exec(`INSERT INTO TABELAS_INTEGRADAS (NOME_TABELA, ATIVO)
+			SELECT CAST(? AS VARCHAR(31)), 'S' FROM RDB$DATABASE
+			WHERE NOT EXISTS (SELECT 1 FROM TABELAS_INTEGRADAS WHERE NOME_TABELA = ?)`, tableName, tableName); err != nil {
+			log.Printf("[WARN] Erro ao registrar %s em TABELAS_INTEGRADAS: %v", tableName, err)
+		}
 	}
 	return nil
 }
+
+// InstalledTriggerVersions retorna, por tabela, a versão da trigger de sincronização
+// instalada no banco (0 para triggers anteriores ao controle de versão)
+func InstalledTriggerVersions(dbConn *sql.DB) (map[string]int, error) {
+	rows, err := dbConn.Query(`
+		SELECT TRIM(RDB$RELATION_NAME), RDB$TRIGGER_SOURCE
+		FROM RDB$TRIGGERS
+		WHERE RDB$TRIGGER_NAME STARTING WITH 'TRG_SYNC_'`)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao listar triggers: %w", err)
+	}
+	defer rows.Close()
+
+	versions := make(map[string]int)
+	for rows.Next() {
+		var table string
+		var source sql.NullString
+		if err := rows.Scan(&table, &source); err != nil {
+			return nil, err
+		}
+		version := 0
+		if idx := strings.Index(source.String, triggerVersionMarker); idx != -1 {
+			fmt.Sscanf(source.String[idx+len(triggerVersionMarker):], "%d", &version)
+		}
+		versions[table] = version
+	}
+	return versions, rows.Err()
+}
+
+// EnsureQueueSchema cria (se necessário) as tabelas de controle usadas pela fila,
+// independente do modo de captura (triggers ou trace)
+func EnsureQueueSchema(dbConn *sql.DB) {
+	log.Println("[INFO] Verificando/Criando tabela FILA_INTEGRACAO...")
+	_, err := dbConn.Exec(`CREATE TABLE FILA_INTEGRACAO (
+    ID INTEGER NOT NULL PRIMARY KEY,
+    EVENT_ID CHAR(36) NOT NULL,
+    TABELA VARCHAR(31) NOT NULL,
+    OPERACAO CHAR(1) NOT NULL,
+    PK_JSON BLOB SUB_TYPE TEXT,
+    PAYLOAD_JSON BLOB SUB_TYPE TEXT,
+    ORIGEM VARCHAR(20),
+    STATUS CHAR(1) DEFAULT 'P',
+    TENTATIVAS INTEGER DEFAULT 0,
+    DT_EVENTO TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    DT_ULT_ENVIO TIMESTAMP,
+    ERRO_MSG BLOB SUB_TYPE TEXT
+	)`)
+	if err != nil {
+		log.Printf("[DEBUG] Nota: FILA_INTEGRACAO pode já existir: %v", err)
+	}
+
+	if _, err := dbConn.Exec("CREATE GENERATOR GEN_FILA_INTEGRACAO_ID"); err != nil {
+		log.Printf("[DEBUG] Nota: Generator pode já existir: %v", err)
+	}
+
+	_, err = dbConn.Exec(`CREATE TRIGGER TRG_FILA_INTEGRACAO_BI FOR FILA_INTEGRACAO ACTIVE BEFORE INSERT POSITION 0 AS BEGIN 
+		IF (NEW.ID IS NULL) THEN NEW.ID = GEN_ID(GEN_FILA_INTEGRACAO_ID, 1); END`)
+	if err != nil {
+		log.Printf("[DEBUG] Nota: Trigger BI pode já existir: %v", err)
+	}
+
+	// Transação de origem (CURRENT_TRANSACTION): eventos da mesma transação são enviados e aplicados juntos
+	_, _ = dbConn.Exec("ALTER TABLE FILA_INTEGRACAO ADD TRANSACAO_ID BIGINT")
+	_, _ = dbConn.Exec("CREATE INDEX IDX_FILA_INTEGRACAO_TRANSACAO ON FILA_INTEGRACAO (TRANSACAO_ID)")
+
+	// Tabelas para Multi-Cliente (Broadcast)
+	_, _ = dbConn.Exec(`CREATE TABLE SYNC_NODES (
+		NODE_ID VARCHAR(20) NOT NULL PRIMARY KEY,
+		NODE_NAME VARCHAR(100),
+		REMOTE_URL VARCHAR(255) NOT NULL,
+		LAST_SEEN TIMESTAMP,
+		ACTIVE CHAR(1) DEFAULT 'S' CHECK (ACTIVE IN ('S', 'N'))
+	)`)
+
+	_, _ = dbConn.Exec(`CREATE TABLE FILA_DESTINOS (
+		ID INTEGER NOT NULL PRIMARY KEY,
+		FILA_ID INTEGER NOT NULL,
+		NODE_ID VARCHAR(20) NOT NULL,
+		STATUS CHAR(1) DEFAULT 'P',
+		TENTATIVAS INTEGER DEFAULT 0,
+		ERRO_MSG BLOB SUB_TYPE TEXT,
+		DT_ULT_TENTATIVA TIMESTAMP,
+		NEXT_ATTEMPT_AT TIMESTAMP
+	)`)
+
+	// Migração: bancos criados antes do backoff não têm NEXT_ATTEMPT_AT
+	_, _ = dbConn.Exec("ALTER TABLE FILA_DESTINOS ADD NEXT_ATTEMPT_AT TIMESTAMP")
+
+	// Índices usados pelo Poller e pelo expurgo da retenção
+	_, _ = dbConn.Exec("CREATE INDEX IDX_FILA_STATUS ON FILA_INTEGRACAO (STATUS)")
+	_, _ = dbConn.Exec("CREATE INDEX IDX_FILA_DESTINOS_FILA ON FILA_DESTINOS (FILA_ID)")
+	_, _ = dbConn.Exec("CREATE INDEX IDX_FILA_DESTINOS_STATUS ON FILA_DESTINOS (STATUS)")
+
+	_, _ = dbConn.Exec("CREATE GENERATOR GEN_FILA_DESTINOS_ID")
+
+	_, _ = dbConn.Exec(`CREATE TRIGGER TRG_FILA_DESTINOS_BI FOR FILA_DESTINOS ACTIVE BEFORE INSERT POSITION 0 AS BEGIN 
+		IF (NEW.ID IS NULL) THEN NEW.ID = GEN_ID(GEN_FILA_DESTINOS_ID, 1); END`)
+
+	if _, err := dbConn.Exec("CREATE TABLE TABELAS_INTEGRADAS (NOME_TABELA VARCHAR(31) NOT NULL PRIMARY KEY, ATIVO CHAR(1) DEFAULT 'S')"); err != nil {
+		log.Printf("[DEBUG] Nota: TABELAS_INTEGRADAS pode já existir: %v", err)
+	}
+
+	// Auditoria dos comandos administrativos recebidos (locais ou via Relay)
+	_, _ = dbConn.Exec(`CREATE TABLE SYNC_AUDITORIA (
+		ID INTEGER NOT NULL PRIMARY KEY,
+		DT_EVENTO TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		ORIGEM VARCHAR(20),
+		COMANDO VARCHAR(50) NOT NULL,
+		ARGS BLOB SUB_TYPE TEXT,
+		STATUS CHAR(1) NOT NULL,
+		RESULTADO BLOB SUB_TYPE TEXT
+	)`)
+
+	_, _ = dbConn.Exec("CREATE GENERATOR GEN_SYNC_AUDITORIA_ID")
+
+	_, _ = dbConn.Exec(`CREATE TRIGGER TRG_SYNC_AUDITORIA_BI FOR SYNC_AUDITORIA ACTIVE BEFORE INSERT POSITION 0 AS BEGIN 
+		IF (NEW.ID IS NULL) THEN NEW.ID = GEN_ID(GEN_SYNC_AUDITORIA_ID, 1); END`)
+
+	// Progresso das cargas iniciais/ressincronizações (comando resync)
+	_, _ = dbConn.Exec(`CREATE TABLE SYNC_RESYNC (
+		ID INTEGER NOT NULL PRIMARY KEY,
+		TABELA VARCHAR(31) NOT NULL,
+		NODE_ID VARCHAR(20) NOT NULL,
+		STATUS CHAR(1) DEFAULT 'A',
+		ULTIMA_PK BLOB SUB_TYPE TEXT,
+		TOTAL INTEGER DEFAULT 0,
+		ERRO_MSG BLOB SUB_TYPE TEXT,
+		DT_INICIO TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		DT_ATUALIZACAO TIMESTAMP
+	)`)
+
+	_, _ = dbConn.Exec("CREATE GENERATOR GEN_SYNC_RESYNC_ID")
+
+	// Detecção de conflitos: versão base enviada com cada evento e última versão de cada linha
+	_, _ = dbConn.Exec("ALTER TABLE FILA_INTEGRACAO ADD VERSAO_BASE CHAR(36)")
+
+	_, _ = dbConn.Exec(`CREATE TABLE SYNC_VERSOES (
+		TABELA VARCHAR(31) NOT NULL,
+		PK_KEY VARCHAR(250) NOT NULL,
+		EVENT_ID CHAR(36) NOT NULL,
+		ORIGEM VARCHAR(20),
+		DT_EVENTO TIMESTAMP,
+		PRIMARY KEY (TABELA, PK_KEY)
+	)`)
+
+	// Eventos recebidos retidos por conflito (política "park") ou erro de aplicação para revisão manual
+	_, _ = dbConn.Exec(`CREATE TABLE SYNC_CONFLITOS (
+		ID INTEGER NOT NULL PRIMARY KEY,
+		DT_CONFLITO TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		EVENT_ID CHAR(36) NOT NULL,
+		TABELA VARCHAR(31) NOT NULL,
+		OPERACAO CHAR(1),
+		PK_JSON BLOB SUB_TYPE TEXT,
+		ORIGEM VARCHAR(20),
+		EVENTO_JSON BLOB SUB_TYPE TEXT,
+		LOCAL_JSON BLOB SUB_TYPE TEXT,
+		MOTIVO BLOB SUB_TYPE TEXT,
+		STATUS CHAR(1) DEFAULT 'P'
+	)`)
+
+	_, _ = dbConn.Exec("CREATE GENERATOR GEN_SYNC_CONFLITOS_ID")
+
+	_, _ = dbConn.Exec(`CREATE TRIGGER TRG_SYNC_CONFLITOS_BI FOR SYNC_CONFLITOS ACTIVE BEFORE INSERT POSITION 0 AS BEGIN 
+		IF (NEW.ID IS NULL) THEN NEW.ID = GEN_ID(GEN_SYNC_CONFLITOS_ID, 1); END`)
+
+	// Erros de aplicação também são retidos; a resolução registra quem decidiu e quando
+	_, _ = dbConn.Exec("ALTER TABLE SYNC_CONFLITOS ADD ERRO_MSG BLOB SUB_TYPE TEXT")
+	_, _ = dbConn.Exec("ALTER TABLE SYNC_CONFLITOS ADD RESOLUCAO VARCHAR(10)")
+	_, _ = dbConn.Exec("ALTER TABLE SYNC_CONFLITOS ADD RESOLVIDO_POR VARCHAR(60)")
+	_, _ = dbConn.Exec("ALTER TABLE SYNC_CONFLITOS ADD DT_RESOLUCAO TIMESTAMP")
+}
+
+// jsonValueExpr gera a expressão PSQL que serializa a coluna como valor JSON
+// conforme o seu tipo. O resultado é NULL quando a coluna é NULL (o chamador
+// usa COALESCE(..., 'null')), vazio para BLOBs (que não vão no payload) e erro
+// para tipos que a trigger não sabe serializar, em vez de omitir a coluna.
+func jsonValueExpr(rec string, c db.ColumnInfo) (string, error) {
+	ref := rec + "." + c.Name
+
+	switch c.FieldType {
+	case db.FieldSmallint, db.FieldInteger, db.FieldBigint, db.FieldFloat, db.FieldDouble:
+		return fmt.Sprintf("CAST(%s AS VARCHAR(40))", ref), nil
+	case db.FieldBoolean:
+		return fmt.Sprintf("CASE WHEN %[1]s IS NULL THEN NULL WHEN %[1]s THEN 'true' ELSE 'false' END", ref), nil
+	case db.FieldDate:
+		return fmt.Sprintf("'\"' || CAST(%s AS VARCHAR(10)) || '\"'", ref), nil
+	case db.FieldTime:
+		return fmt.Sprintf("'\"' || CAST(%s AS VARCHAR(13)) || '\"'", ref), nil
+	case db.FieldTimestamp:
+		// ISO 8601: "YYYY-MM-DDTHH:MM:SS.ffff"
+		return fmt.Sprintf("'\"' || REPLACE(CAST(%s AS VARCHAR(24)), ' ', 'T') || '\"'", ref), nil
+	case db.FieldChar:
+		return jsonStringExpr(fmt.Sprintf("TRIM(TRAILING FROM CAST(%s AS VARCHAR(%d)))", ref, textLength(c))), nil
+	case db.FieldVarchar:
+		return jsonStringExpr(fmt.Sprintf("CAST(%s AS VARCHAR(%d))", ref, textLength(c))), nil
+	case db.FieldBlob:
+		// BLOBs (texto ou binário) não entram no payload da trigger: são lidos
+		// da linha de origem no envio e trafegados em base64 (models.BlobValue)
+		return "", nil
+	}
+	return "", fmt.Errorf("coluna %s: tipo %d não suportado na trigger de sincronização", c.Name, c.FieldType)
+}
+
+// jsonStringExpr envolve a expressão em aspas escapando \, " e as quebras de linha e
+// tabulações digitadas nos campos de texto. Os demais caracteres de controle, raros,
+// saem crus e são escapados na leitura da fila (db.triggerJSON): escapar os 32 aqui
+// multiplicaria o tamanho de cada campo de texto na trigger.
+func jsonStringExpr(expr string) string {
+	escaped := fmt.Sprintf("REPLACE(REPLACE(%s, '\\', '\\\\'), '\"', '\\\"')", expr)
+	for _, e := range []struct {
+		char   int
+		escape string
+	}{{9, `\t`}, {10, `\n`}, {13, `\r`}} {
+		escaped = fmt.Sprintf("REPLACE(%s, ASCII_CHAR(%d), '%s')", escaped, e.char, e.escape)
+	}
+	return "'\"' || " + escaped + " || '\"'"
+}
+
+func textLength(c db.ColumnInfo) int {
+	if c.Length <= 0 {
+		return 1
+	}
+	return c.Length
+}
@@ -0,0 +1,216 @@
+package webhook
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/atsinformatica/firebird-sync-agent/internal/models"
+)
+
+// Situação de cada evento na resposta do /sync/batch
+const (
+	BatchApplied   = "ok"        // Aplicado (ou resolvido pela política de conflito)
+	BatchDuplicate = "duplicate" // Já recebido antes
+	BatchParked    = "parked"    // Recusado pelo banco e retido em SYNC_CONFLITOS
+	BatchError     = "error"     // Falha temporária: reenviar
+	BatchSkipped   = "skipped"   // Não processado por causa de uma falha anterior no lote: reenviar
+)
+
+// MaxBatchEvents limita o tamanho de um lote (uma única transação no destino)
+const MaxBatchEvents = 5000
+
+// BatchResult é o resultado de um evento do lote, na mesma ordem do envio
+type BatchResult struct {
+	EventID string `json:"event_id"`
+	Status  string `json:"status"`
+	Error   string `json:"error,omitempty"`
+}
+
+// handleSyncBatch recebe uma lista ordenada de eventos e responde o resultado de cada um
+func (s *Server) handleSyncBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.authorized(r) {
+		http.Error(w, "Não autorizado", http.StatusUnauthorized)
+		return
+	}
+
+	var payloads []models.SyncPayload
+	decoder := json.NewDecoder(r.Body)
+	decoder.UseNumber() // Preserva a precisão de NUMERIC/BIGINT até a conversão por coluna
+	if err := decoder.Decode(&payloads); err != nil {
+		http.Error(w, "Lote inválido", http.StatusBadRequest)
+		return
+	}
+	if len(payloads) > MaxBatchEvents {
+		http.Error(w, fmt.Sprintf("Lote com mais de %d eventos", MaxBatchEvents), http.StatusRequestEntityTooLarge)
+		return
+	}
+	for i := range payloads {
+		payloads[i].RemoteAddr = r.RemoteAddr
+	}
+
+	results, err := s.ProcessBatch(payloads)
+	if err != nil {
+		log.Printf("[SERVER] Erro ao processar lote: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+// ProcessBatch aplica os eventos em ordem numa única transação. Cada evento avulso,
+// ou cada transação de origem inteira, fica num savepoint: o que o banco recusar é
+// desfeito e retido sem perder o restante do lote. Numa falha temporária o lote para
+// ali; o que veio antes é gravado e o restante volta como BatchSkipped para ser
+// reenviado na mesma ordem.
+func (s *Server) ProcessBatch(payloads []models.SyncPayload) ([]BatchResult, error) {
+	results := make([]BatchResult, len(payloads))
+	for i, p := range payloads {
+		results[i] = BatchResult{EventID: p.EventID, Status: BatchSkipped}
+	}
+	if len(payloads) == 0 {
+		return results, nil
+	}
+
+	registered := make(map[string]bool)
+	for _, p := range payloads {
+		if !registered[p.Origem] {
+			s.registerNode(p)
+			registered[p.Origem] = true
+		}
+	}
+
+	tx, err := s.dbConn.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("erro ao iniciar transação: %w", err)
+	}
+	defer tx.Rollback()
+
+	seen := make(map[string]bool, len(payloads)) // Repetidos dentro do próprio lote
+	for start := 0; start < len(payloads); {
+		end := transactionEnd(payloads, start)
+		ok, err := s.applyGroupTx(tx, payloads[start:end], results[start:end], seen)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+		start = end
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("erro ao comitar lote: %w", err)
+	}
+	return results, nil
+}
+
+// transactionEnd retorna o fim (exclusivo) do grupo que começa em start: o evento
+// sozinho ou os eventos seguidos da mesma transação de origem
+func transactionEnd(payloads []models.SyncPayload, start int) int {
+	first := payloads[start]
+	end := start + 1
+	if first.Transaction == 0 {
+		return end
+	}
+	for end < len(payloads) && payloads[end].Transaction == first.Transaction && payloads[end].Origem == first.Origem {
+		end++
+	}
+	return end
+}
+
+// applyGroupTx aplica um grupo (evento avulso ou transação de origem) de forma
+// atômica. Se o banco recusar um dos eventos, o grupo inteiro é desfeito e retido
+// em SYNC_CONFLITOS. Retorna false numa falha temporária: o lote deve parar.
+func (s *Server) applyGroupTx(tx *sql.Tx, group []models.SyncPayload, results []BatchResult, seen map[string]bool) (bool, error) {
+	if _, err := tx.Exec("SAVEPOINT SYNC_GRUPO"); err != nil {
+		return false, fmt.Errorf("erro ao criar savepoint: %w", err)
+	}
+
+	failed, failErr := -1, error(nil)
+	for i, p := range group {
+		duplicate, err := s.queue.IsDuplicate(p.EventID)
+		if err == nil && (duplicate || seen[p.EventID]) {
+			results[i].Status = BatchDuplicate
+			continue
+		}
+		if err == nil {
+			err = s.applyPayloadTx(tx, p)
+		}
+		if err != nil {
+			failed, failErr = i, err
+			break
+		}
+		results[i].Status = BatchApplied
+	}
+	if failed < 0 {
+		for _, p := range group {
+			seen[p.EventID] = true
+		}
+		return true, nil
+	}
+
+	if _, err := tx.Exec("ROLLBACK TO SAVEPOINT SYNC_GRUPO"); err != nil {
+		return false, fmt.Errorf("erro ao desfazer evento %s: %w", group[failed].EventID, err)
+	}
+	for i := range results {
+		if results[i].Status == BatchApplied {
+			results[i].Status = BatchSkipped
+		}
+	}
+
+	var rejected *applyError
+	if errors.As(failErr, &rejected) && !s.retryable(rejected.err, group[failed].Attempt) {
+		parkErr := s.parkGroupTx(tx, group, results, failed, rejected.err)
+		if parkErr == nil {
+			for _, p := range group {
+				seen[p.EventID] = true
+			}
+			return true, nil
+		}
+		failErr = parkErr
+	}
+
+	log.Printf("[SERVER] Lote interrompido no evento %s: %v", group[failed].EventID, failErr)
+	results[failed].Status, results[failed].Error = BatchError, failErr.Error()
+	return false, nil
+}
+
+// parkGroupTx retém todos os eventos do grupo: o recusado com o erro do banco e os
+// demais da mesma transação de origem apontando para ele
+func (s *Server) parkGroupTx(tx *sql.Tx, group []models.SyncPayload, results []BatchResult, failed int, applyErr error) error {
+	if _, err := tx.Exec("SAVEPOINT SYNC_GRUPO"); err != nil {
+		return fmt.Errorf("erro ao criar savepoint: %w", err)
+	}
+	for i, p := range group {
+		if results[i].Status == BatchDuplicate {
+			continue
+		}
+		reason := applyErr
+		if i != failed {
+			reason = fmt.Errorf("transação de origem %d desfeita: evento %s recusado (%v)", p.Transaction, group[failed].EventID, applyErr)
+		}
+		if err := s.parkFailedPayloadTx(tx, p, reason); err != nil {
+			if _, rbErr := tx.Exec("ROLLBACK TO SAVEPOINT SYNC_GRUPO"); rbErr != nil {
+				return fmt.Errorf("%v (e ao desfazer: %v)", err, rbErr)
+			}
+			for j := range results {
+				if results[j].Status == BatchParked {
+					results[j].Status, results[j].Error = BatchSkipped, ""
+				}
+			}
+			return err
+		}
+		results[i].Status, results[i].Error = BatchParked, reason.Error()
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package webhook
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/atsinformatica/firebird-sync-agent/internal/models"
+)
+
+func TestTransactionEnd(t *testing.T) {
+	ev := func(origem string, transaction int64) models.SyncPayload {
+		return models.SyncPayload{Origem: origem, Transaction: transaction}
+	}
+
+	tests := []struct {
+		name     string
+		payloads []models.SyncPayload
+		want     [][2]int // Grupos [início, fim) na ordem do lote
+	}{
+		{"eventos avulsos", []models.SyncPayload{ev("A", 0), ev("A", 0)}, [][2]int{{0, 1}, {1, 2}}},
+		{"transação inteira", []models.SyncPayload{ev("A", 5), ev("A", 5), ev("A", 5)}, [][2]int{{0, 3}}},
+		{
+			name:     "transações seguidas e evento avulso",
+			payloads: []models.SyncPayload{ev("A", 5), ev("A", 5), ev("A", 0), ev("A", 6)},
+			want:     [][2]int{{0, 2}, {2, 3}, {3, 4}},
+		},
+		{"mesmo número em outra origem", []models.SyncPayload{ev("A", 5), ev("B", 5)}, [][2]int{{0, 1}, {1, 2}}},
+		{"transação só agrupa eventos seguidos", []models.SyncPayload{ev("A", 5), ev("A", 0), ev("A", 5)}, [][2]int{{0, 1}, {1, 2}, {2, 3}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got [][2]int
+			for start := 0; start < len(tt.payloads); {
+				end := transactionEnd(tt.payloads, start)
+				got = append(got, [2]int{start, end})
+				start = end
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("grupos = %v, esperado %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/atsinformatica/firebird-sync-agent/internal/config"
+	"github.com/atsinformatica/firebird-sync-agent/internal/db"
+)
+
+// CommandCaller executa comandos em outro nó: via Relay quando habilitado, senão
+// direto no /command do nó (endereço de SYNC_NODES, como no envio de eventos)
+type CommandCaller struct {
+	conf  *config.Store
+	relay *RelayClient
+	queue *db.QueueManager
+}
+
+func NewCommandCaller(conf *config.Store, relay *RelayClient, queue *db.QueueManager) *CommandCaller {
+	return &CommandCaller{conf: conf, relay: relay, queue: queue}
+}
+
+func (c *CommandCaller) cfg() *config.Config {
+	return c.conf.Get()
+}
+
+func (c *CommandCaller) Call(ctx context.Context, target, name string, args json.RawMessage) (json.RawMessage, error) {
+	if c.cfg().Relay.Enabled && c.relay != nil {
+		return c.relay.Call(ctx, target, name, args)
+	}
+
+	nodes, err := c.queue.GetActiveNodes()
+	if err != nil {
+		return nil, fmt.Errorf("erro ao buscar nós ativos: %w", err)
+	}
+	var remoteURL string
+	for _, n := range nodes {
+		if strings.TrimSpace(n.NodeID) == target {
+			remoteURL = n.RemoteURL
+		}
+	}
+	if remoteURL == "" {
+		return nil, fmt.Errorf("nó %s sem endereço em SYNC_NODES", target)
+	}
+
+	body, _ := json.Marshal(CommandRequest{Target: target, Name: name, Args: args})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, commandURL(remoteURL), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	// O /command do outro nó pede o commands.token dele, não o token do webhook
+	token := c.cfg().Commands.Token
+	if token == "" {
+		return nil, fmt.Errorf("commands.token não configurado: necessário para chamar %s sem o Relay", target)
+	}
+	req.Header.Set("X-Admin-Token", token)
+
+	client := &http.Client{Timeout: commandTimeout(c.cfg())}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	raw, _ := io.ReadAll(resp.Body)
+	var result RelayCommandResult
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return nil, fmt.Errorf("resposta inesperada de %s (%d): %s", target, resp.StatusCode, bytes.TrimSpace(raw))
+	}
+	if !result.OK {
+		return nil, errors.New(result.Error)
+	}
+	return result.Result, nil
+}
+
+// commandURL troca o caminho /sync do endereço do nó por /command
+func commandURL(syncURL string) string {
+	return strings.TrimSuffix(strings.TrimRight(syncURL, "/"), "/sync") + "/command"
+}
+
+// commandTimeout é o prazo de resposta de um comando remoto (relay.command_timeout_seconds)
+func commandTimeout(cfg *config.Config) time.Duration {
+	if cfg.Relay.CommandTimeoutSeconds > 0 {
+		return time.Duration(cfg.Relay.CommandTimeoutSeconds) * time.Second
+	}
+	return 30 * time.Second
+}
@@ -12,31 +12,35 @@ import (
 )
 
 type Client struct {
-	cfg *config.Config
+	conf *config.Store
 }
 
-func NewClient(cfg *config.Config) *Client {
-	return &Client{cfg: cfg}
+func NewClient(conf *config.Store) *Client {
+	return &Client{conf: conf}
+}
+
+func (c *Client) cfg() *config.Config {
+	return c.conf.Get()
 }
 
 // Send envia um payload genérico para o remoto
 func (c *Client) Send(ctx context.Context, payload interface{}) error {
 	body, _ := json.Marshal(payload)
-	req, err := http.NewRequest("POST", c.cfg.Webhook.RemoteURL, bytes.NewBuffer(body))
+	req, err := http.NewRequest("POST", c.cfg().Webhook.RemoteURL, bytes.NewBuffer(body))
 	if err != nil {
 		return err
 	}
 
 	req.Header.Set("Content-Type", "application/json")
 	var token string
-	if c.cfg.Webhook.Token != "" {
-		token = c.cfg.Webhook.Token
+	if c.cfg().Webhook.Token != "" {
+		token = c.cfg().Webhook.Token
 	} else {
 		token = "ATS_SYNC_DEFAULT"
 	}
 	req.Header.Set("X-Sync-Token", token)
 
-	timeout := c.cfg.Integracao.TimeoutSeconds
+	timeout := c.cfg().Integracao.TimeoutSeconds
 	if timeout <= 0 {
 		timeout = 30
 	}
@@ -0,0 +1,336 @@
+package webhook
+
+import (
+	"bytes"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"strings"
+
+	"github.com/atsinformatica/firebird-sync-agent/internal/config"
+	"github.com/atsinformatica/firebird-sync-agent/internal/db"
+	"github.com/atsinformatica/firebird-sync-agent/internal/models"
+)
+
+// Resultado da verificação de conflito de um evento recebido
+const (
+	conflictApply = iota // Aplica o evento (sem conflito ou o recebido venceu)
+	conflictKeep         // Mantém a linha local; o evento é só registrado
+	conflictPark         // Retém o evento em SYNC_CONFLITOS
+)
+
+type conflictDecision struct {
+	action int
+	policy string
+	reason string
+	data   map[string]interface{} // Payload mesclado (política "merge"); nil = o recebido
+}
+
+// checkConflict verifica se a linha mudou aqui depois da versão que o emissor conhecia
+// (BaseVersion) e, havendo conflito, decide pela política da tabela
+func (s *Server) checkConflict(tx *sql.Tx, p models.SyncPayload) (conflictDecision, error) {
+	// Sem versão base: emissor antigo ou evento de carga/reparo, que impõe o estado
+	if p.BaseVersion == "" {
+		return conflictDecision{action: conflictApply}, nil
+	}
+
+	local, err := s.queue.GetRowVersionTx(tx, p.Table, p.PKJSON)
+	if err != nil {
+		return conflictDecision{}, err
+	}
+	if local == nil || local.EventID == p.BaseVersion {
+		return conflictDecision{action: conflictApply}, nil
+	}
+
+	policy := s.cfg().ConflictPolicy(p.Table)
+	reason := fmt.Sprintf("linha alterada por %s em %s, depois da versão conhecida por %s",
+		local.Origem, local.DTEvento.Format("2006-01-02 15:04:05"), p.Origem)
+	d := conflictDecision{policy: policy, reason: reason}
+
+	switch policy {
+	case config.ConflictPark:
+		d.action = conflictPark
+		return d, nil
+
+	case config.ConflictPriority:
+		incoming, current := s.cfg().NodePriority(p.Origem), s.cfg().NodePriority(local.Origem)
+		if incoming != current {
+			d.action = conflictKeep
+			if incoming < current {
+				d.action = conflictApply
+			}
+			return d, nil
+		}
+		// Mesma prioridade: desempata pelo horário
+
+	case config.ConflictMerge:
+		merged, err := s.mergeFields(tx, p)
+		if err != nil {
+			return conflictDecision{}, err
+		}
+		if merged != nil {
+			d.action = conflictApply
+			d.data = merged
+			return d, nil
+		}
+		// Sem a versão base registrada não há como saber o que mudou aqui: desempata pelo horário
+	}
+
+	// Last-writer-wins. Empate resolvido pelo nó de origem para os dois lados decidirem igual.
+	if p.Timestamp.After(local.DTEvento) || (p.Timestamp.Equal(local.DTEvento) && p.Origem > local.Origem) {
+		d.action = conflictApply
+	} else {
+		d.action = conflictKeep
+	}
+	return d, nil
+}
+
+// mergeFields mescla campo a campo: campos alterados aqui desde a versão base ficam
+// com o valor local, os demais vêm do evento. Retorna nil quando não é possível
+// mesclar (DELETE, linha inexistente ou versão base desconhecida).
+func (s *Server) mergeFields(tx *sql.Tx, p models.SyncPayload) (map[string]interface{}, error) {
+	if p.Operation == "D" || len(p.PayloadJSON) == 0 {
+		return nil, nil
+	}
+	base, err := db.EventPayloadTx(tx, p.BaseVersion)
+	if err != nil || base == nil {
+		return nil, err
+	}
+	current, err := s.queue.PayloadRowTx(tx, p.Table, p.PKJSON)
+	if err != nil || current == nil {
+		return nil, err
+	}
+	_, colMap, err := s.resolveTable(p.Table)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := make(map[string]interface{}, len(p.PayloadJSON))
+	for col, incoming := range p.PayloadJSON {
+		merged[col] = incoming
+
+		name := strings.ToUpper(strings.TrimSpace(col))
+		info, known := colMap[name]
+		baseValue, inBase := base[name]
+		currentValue, inCurrent := current[name]
+		if !known || !inBase || !inCurrent {
+			continue
+		}
+		if !sameValue(info, baseValue, currentValue) {
+			merged[col] = currentValue
+		}
+	}
+	return merged, nil
+}
+
+// sameValue compara dois valores depois de convertê-los para o tipo da coluna
+func sameValue(info db.ColumnInfo, a, b interface{}) bool {
+	ca, errA := info.Coerce(a)
+	cb, errB := info.Coerce(b)
+	if errA != nil || errB != nil {
+		return fmt.Sprint(a) == fmt.Sprint(b)
+	}
+	// NUMERIC/DECIMAL vêm como texto: "12.5" e "12.50" são o mesmo valor
+	if sa, ok := ca.(string); ok && info.IsNumeric() {
+		if sb, ok := cb.(string); ok {
+			ra, okA := new(big.Rat).SetString(sa)
+			rb, okB := new(big.Rat).SetString(sb)
+			if okA && okB {
+				return ra.Cmp(rb) == 0
+			}
+		}
+	}
+	return fmt.Sprint(ca) == fmt.Sprint(cb)
+}
+
+// parkConflict guarda o evento e a linha local em SYNC_CONFLITOS
+func (s *Server) parkConflict(tx *sql.Tx, p models.SyncPayload, reason, applyErr string) error {
+	var local interface{}
+	if row, err := s.queue.PayloadRowTx(tx, p.Table, p.PKJSON); err == nil && row != nil {
+		local = row
+	}
+	return db.InsertConflictTx(tx, db.Conflict{
+		EventID:  p.EventID,
+		Tabela:   p.Table,
+		Operacao: p.Operation,
+		PK:       p.PKJSON,
+		Origem:   p.Origem,
+		Evento:   p,
+		Local:    local,
+		Motivo:   reason,
+		Erro:     applyErr,
+	})
+}
+
+// parkFailedPayload retém em SYNC_CONFLITOS um evento que o banco recusou (constraint,
+// tipo, tabela não integrada...) e o registra como recebido, para o emissor parar de
+// reenviar. Só devolve erro se nem a retenção for possível.
+func (s *Server) parkFailedPayload(p models.SyncPayload, applyErr error) error {
+	tx, err := s.dbConn.Begin()
+	if err != nil {
+		return fmt.Errorf("erro ao aplicar no banco remoto: %w", applyErr)
+	}
+	defer tx.Rollback()
+
+	if err := s.parkFailedPayloadTx(tx, p, applyErr); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("erro ao aplicar no banco remoto: %w (retenção falhou: %v)", applyErr, err)
+	}
+	return nil
+}
+
+func (s *Server) parkFailedPayloadTx(tx *sql.Tx, p models.SyncPayload, applyErr error) error {
+	log.Printf("[CONFLITO] %s %v de %s: erro ao aplicar, retido para revisão: %v", p.Table, p.PKJSON, p.Origem, applyErr)
+
+	if err := s.parkConflict(tx, p, "erro ao aplicar o evento", applyErr.Error()); err != nil {
+		return fmt.Errorf("erro ao aplicar no banco remoto: %w (retenção falhou: %v)", applyErr, err)
+	}
+	recordHistoryTx(tx, p, "C", "Erro ao aplicar, retido para revisão: "+applyErr.Error())
+	return nil
+}
+
+// retryable indica se o emissor deve reenviar o evento recusado em vez de ele ser
+// retido. Erros de constraint costumam ser ordem de chegada (o pai da FK ainda não
+// chegou), então são reenviados até webhook.constraint_retries antes de reter.
+func (s *Server) retryable(err error, attempt int) bool {
+	if isTransientError(err) {
+		return true
+	}
+	return isConstraintError(err) && attempt < s.constraintRetries()
+}
+
+func (s *Server) constraintRetries() int {
+	if n := s.cfg().Webhook.ConstraintRetries; n > 0 {
+		return n
+	}
+	return 5
+}
+
+// isConstraintError indica violação de FK, PK/unique ou check
+func isConstraintError(err error) bool {
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "foreign key") || strings.Contains(msg, "constraint")
+}
+
+// isTransientError indica falhas que passam sozinhas (travas, conexão): o emissor
+// deve reenviar em vez de o evento ser retido
+func isTransientError(err error) bool {
+	var netErr net.Error
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, io.EOF) || errors.As(err, &netErr) {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	for _, transient := range []string{"deadlock", "lock conflict", "concurrent update", "connection"} {
+		if strings.Contains(msg, transient) {
+			return true
+		}
+	}
+	return false
+}
+
+// ResolveConflict resolve um conflito retido. ResolutionIncoming aplica o evento
+// recebido; ResolutionEdited aplica o evento com os campos de data por cima do
+// payload recebido; ResolutionLocal descarta o evento e reenvia a linha local ao nó
+// de origem, para os dois lados convergirem. by identifica quem decidiu.
+func (s *Server) ResolveConflict(id int64, resolution string, data map[string]interface{}, by string) (*db.ConflictRecord, error) {
+	tx, err := s.dbConn.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("erro ao iniciar transação: %w", err)
+	}
+	defer tx.Rollback()
+
+	c, err := db.LockConflictTx(tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if c == nil {
+		return nil, fmt.Errorf("conflito %d não encontrado", id)
+	}
+	if c.Status != db.ConflictPending {
+		return nil, fmt.Errorf("conflito %d já resolvido (%s, por %s)", id, c.Resolucao, c.ResolvidoPor)
+	}
+
+	var p models.SyncPayload
+	decoder := json.NewDecoder(bytes.NewReader(c.Evento))
+	decoder.UseNumber()
+	if err := decoder.Decode(&p); err != nil {
+		return nil, fmt.Errorf("evento do conflito %d inválido: %w", id, err)
+	}
+
+	switch resolution {
+	case db.ResolutionIncoming, db.ResolutionEdited:
+		if resolution == db.ResolutionEdited {
+			if len(data) == 0 {
+				return nil, fmt.Errorf("informe os dados corrigidos")
+			}
+			edited := make(map[string]interface{}, len(p.PayloadJSON)+len(data))
+			for col, v := range p.PayloadJSON {
+				edited[col] = v
+			}
+			for col, v := range data {
+				edited[col] = v
+			}
+			p.PayloadJSON = edited
+			if p.Operation == "D" {
+				p.Operation = "U" // Corrigir um DELETE é gravar a linha com os dados informados
+			}
+		}
+		if err := s.applyToDBTx(tx, p); err != nil {
+			s.schema.Invalidate(p.Table)
+			return nil, fmt.Errorf("erro ao aplicar o evento: %w", err)
+		}
+		version := db.RowVersion{EventID: p.EventID, Origem: p.Origem, DTEvento: p.Timestamp}
+		if err := db.SetRowVersionTx(tx, p.Table, p.PKJSON, version); err != nil {
+			return nil, err
+		}
+
+	case db.ResolutionLocal:
+		row, err := s.queue.PayloadRowTx(tx, p.Table, p.PKJSON)
+		if err != nil {
+			return nil, err
+		}
+		var upserts, deletes []map[string]interface{}
+		if row != nil {
+			upserts = append(upserts, p.PKJSON)
+		} else {
+			deletes = append(deletes, p.PKJSON)
+		}
+		// Na mesma transação: se a resolução não comitar, o reenvio também não fica na fila
+		if _, err := s.queue.EnqueueRepairTx(tx, p.Table, p.Origem, upserts, deletes); err != nil {
+			return nil, fmt.Errorf("erro ao reenviar a linha local para %s: %w", p.Origem, err)
+		}
+
+	default:
+		return nil, fmt.Errorf("resolução inválida: %q (use %s, %s ou %s)", resolution, db.ResolutionIncoming, db.ResolutionLocal, db.ResolutionEdited)
+	}
+
+	if err := db.ResolveConflictTx(tx, id, resolution, by); err != nil {
+		return nil, err
+	}
+	note := fmt.Sprintf("Conflito %d resolvido por %s: %s", id, by, resolution)
+	if _, err := tx.Exec("UPDATE FILA_INTEGRACAO SET STATUS = 'A', ERRO_MSG = ? WHERE EVENT_ID = ? AND STATUS = 'C'", note, p.EventID); err != nil {
+		log.Printf("[CONFLITO] Erro ao atualizar histórico do evento %s: %v", p.EventID, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("erro ao comitar resolução: %w", err)
+	}
+
+	log.Printf("[CONFLITO] %s %v: %s", p.Table, p.PKJSON, note)
+	return s.queue.GetConflict(id)
+}
+
+func logConflict(p models.SyncPayload, d conflictDecision) {
+	outcome := map[int]string{conflictApply: "aplicado o recebido", conflictKeep: "mantido o local", conflictPark: "retido para revisão"}[d.action]
+	if d.data != nil {
+		outcome = "campos mesclados"
+	}
+	log.Printf("[CONFLITO] %s %v de %s (%s): %s; %s", p.Table, p.PKJSON, p.Origem, d.policy, d.reason, outcome)
+}
@@ -0,0 +1,41 @@
+package webhook
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/atsinformatica/firebird-sync-agent/internal/config"
+)
+
+func TestRetryable(t *testing.T) {
+	fk := errors.New(`violation of FOREIGN KEY constraint "FK_ITEM_PEDIDO" on table "ITEM_PEDIDO"`)
+	unique := errors.New(`violation of PRIMARY or UNIQUE KEY constraint "PK_PRODUTO" on table "PRODUTO"`)
+	lock := errors.New("lock conflict on no wait transaction")
+	conversion := errors.New("conversion error from string \"ABC\"")
+
+	tests := []struct {
+		name    string
+		retries int // webhook.constraint_retries (0 = padrão)
+		err     error
+		attempt int
+		want    bool
+	}{
+		{"trava sempre reenvia", 0, lock, 50, true},
+		{"fk na primeira tentativa reenvia", 0, fk, 0, true},
+		{"fk dentro do limite padrão reenvia", 0, fk, 4, true},
+		{"fk no limite padrão é retida", 0, fk, 5, false},
+		{"unique respeita o limite configurado", 2, unique, 1, true},
+		{"unique além do limite configurado é retida", 2, unique, 2, false},
+		{"erro do próprio evento é retido de imediato", 0, conversion, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{}
+			cfg.Webhook.ConstraintRetries = tt.retries
+			s := &Server{conf: config.NewStore(cfg)}
+			if got := s.retryable(tt.err, tt.attempt); got != tt.want {
+				t.Errorf("retryable(%q, %d) = %v, esperado %v", tt.err, tt.attempt, got, tt.want)
+			}
+		})
+	}
+}
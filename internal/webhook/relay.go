@@ -1,77 +1,289 @@
 package webhook
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"math/rand"
 	"net/url"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/atsinformatica/firebird-sync-agent/internal/config"
 	"github.com/atsinformatica/firebird-sync-agent/internal/models"
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
 
 // RelayMessage deve ser idêntico ao do Hub
 type RelayMessage struct {
+	ID         string          `json:"id,omitempty"` // Em "sync", o EVENT_ID; usado para correlacionar o "ack"
 	TargetNode string          `json:"target"`
 	SourceNode string          `json:"source"`
 	Payload    json.RawMessage `json:"payload"`
 	Type       string          `json:"type"`
 }
 
+// Status possíveis de uma confirmação (mensagem "ack")
+const (
+	AckOK      = "ok"      // Evento aplicado (ou já aplicado antes) no destino
+	AckError   = "error"   // O destino recebeu mas não conseguiu aplicar
+	AckOffline = "offline" // O Hub não encontrou o destino conectado
+	AckQueued  = "queued"  // O Hub guardou a mensagem para entregar quando o destino conectar
+)
+
+// RelayAck é o payload da mensagem "ack" devolvida ao emissor de um "sync"
+type RelayAck struct {
+	EventID string `json:"event_id"`
+	Status  string `json:"status"`
+	Error   string `json:"error,omitempty"`
+}
+
+// RelayPresence é o payload da mensagem "presence" enviada pelo Hub
+type RelayPresence struct {
+	NodeID   string   `json:"node_id,omitempty"`
+	Online   bool     `json:"online"`
+	Snapshot bool     `json:"snapshot,omitempty"` // Nodes é a lista completa de nós online (logo após conectar)
+	Nodes    []string `json:"nodes,omitempty"`
+}
+
+// RelayCommand é o payload de uma mensagem "command" (requisição RPC entre nós)
+type RelayCommand struct {
+	Name           string          `json:"name"`
+	Args           json.RawMessage `json:"args,omitempty"`
+	TimeoutSeconds int             `json:"timeout_seconds,omitempty"`
+}
+
+// RelayCommandResult é o payload da resposta "command_result", com o mesmo ID da requisição
+type RelayCommandResult struct {
+	OK     bool            `json:"ok"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// CommandHandler executa um comando pedido por outro nó (ou localmente, via /command)
+type CommandHandler func(ctx context.Context, sourceNode, name string, args json.RawMessage) (interface{}, error)
+
 type RelayClient struct {
-	cfg     *config.Config
-	handler *Server
-	conn    *websocket.Conn
-	send    chan RelayMessage
+	conf      *config.Store
+	handler   *Server
+	conn      *websocket.Conn
+	send      chan RelayMessage
+	onAck     func(sourceNode string, ack RelayAck)
+	onCommand CommandHandler
+
+	// Chamadas RPC aguardando resposta (ID da requisição -> canal)
+	pendingMu sync.Mutex
+	pending   map[string]chan RelayCommandResult
+
+	// Presença dos demais nós, informada pelo Hub
+	presenceMu    sync.RWMutex
+	connected     bool
+	presenceKnown bool // false enquanto o Hub não enviou a lista (Hub antigo: assume online)
+	online        map[string]bool
 }
 
-func NewRelayClient(cfg *config.Config, handler *Server) *RelayClient {
+func NewRelayClient(conf *config.Store, handler *Server) *RelayClient {
 	return &RelayClient{
-		cfg:     cfg,
+		conf:    conf,
 		handler: handler,
 		send:    make(chan RelayMessage, 100),
+		online:  make(map[string]bool),
+		pending: make(map[string]chan RelayCommandResult),
+	}
+}
+
+func (c *RelayClient) cfg() *config.Config {
+	return c.conf.Get()
+}
+
+// SetCommandHandler registra quem executa os comandos recebidos via Relay
+func (c *RelayClient) SetCommandHandler(handler CommandHandler) {
+	c.onCommand = handler
+}
+
+// IsOnline indica se o nó está conectado ao Hub. Sem conexão com o Hub, nenhum nó
+// é considerado online; com um Hub que não envia presença, todos são.
+func (c *RelayClient) IsOnline(nodeID string) bool {
+	c.presenceMu.RLock()
+	defer c.presenceMu.RUnlock()
+	if !c.connected {
+		return false
+	}
+	return !c.presenceKnown || c.online[nodeID]
+}
+
+// OnlineNodes retorna os nós que o Hub informou como online
+func (c *RelayClient) OnlineNodes() []string {
+	c.presenceMu.RLock()
+	defer c.presenceMu.RUnlock()
+	nodes := make([]string, 0, len(c.online))
+	for nodeID := range c.online {
+		nodes = append(nodes, nodeID)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+// Connected indica se a conexão com o Hub está ativa
+func (c *RelayClient) Connected() bool {
+	c.presenceMu.RLock()
+	defer c.presenceMu.RUnlock()
+	return c.connected
+}
+
+func (c *RelayClient) setConnected(connected bool) {
+	c.presenceMu.Lock()
+	defer c.presenceMu.Unlock()
+	c.connected = connected
+	c.presenceKnown = false
+	c.online = make(map[string]bool)
+}
+
+func (c *RelayClient) applyPresence(p RelayPresence) {
+	c.presenceMu.Lock()
+	defer c.presenceMu.Unlock()
+	if p.Snapshot {
+		c.online = make(map[string]bool, len(p.Nodes))
+		for _, nodeID := range p.Nodes {
+			c.online[nodeID] = true
+		}
+		c.presenceKnown = true
+		return
+	}
+	if p.Online {
+		c.online[p.NodeID] = true
+		log.Printf("[RELAY] Nó %s online", p.NodeID)
+	} else {
+		delete(c.online, p.NodeID)
+		log.Printf("[RELAY] Nó %s offline", p.NodeID)
 	}
 }
 
+// SetAckHandler registra quem trata as confirmações recebidas (normalmente o Poller)
+func (c *RelayClient) SetAckHandler(handler func(sourceNode string, ack RelayAck)) {
+	c.onAck = handler
+}
+
 func (c *RelayClient) Start(ctx context.Context) {
+	attempt := 0
 	for {
-		err := c.connectAndListen(ctx)
-		if err != nil {
-			log.Printf("[RELAY] Erro na conexão: %v. Reconectando em 5s...", err)
+		established, err := c.connectAndListen(ctx)
+		if ctx.Err() != nil {
+			return
 		}
+		if established {
+			attempt = 0
+		}
+		attempt++
+
+		delay := c.reconnectDelay(attempt)
+		log.Printf("[RELAY] Erro na conexão: %v. Reconectando em %s...", err, delay)
 
 		select {
 		case <-ctx.Done():
 			return
-		case <-time.After(5 * time.Second):
+		case <-time.After(delay):
 			continue
 		}
 	}
 }
 
-func (c *RelayClient) connectAndListen(ctx context.Context) error {
-	u, err := url.Parse(c.cfg.Relay.HubURL)
+// reconnectDelay calcula 1s * 2^(tentativa-1), limitado a relay.reconnect_max_seconds, com jitter de ±20%
+func (c *RelayClient) reconnectDelay(attempt int) time.Duration {
+	maxDelay := time.Duration(c.cfg().Relay.ReconnectMaxSeconds) * time.Second
+	if maxDelay <= 0 {
+		maxDelay = time.Minute
+	}
+
+	delay := time.Second
+	for i := 1; i < attempt && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+
+	jitter := time.Duration((rand.Float64()*0.4 - 0.2) * float64(delay))
+	return delay + jitter
+}
+
+// keepaliveIntervals retorna intervalo de ping, prazo de leitura e prazo de escrita
+func (c *RelayClient) keepaliveIntervals() (time.Duration, time.Duration, time.Duration) {
+	ping := time.Duration(c.cfg().Relay.PingIntervalSeconds) * time.Second
+	if ping <= 0 {
+		ping = 30 * time.Second
+	}
+	pong := time.Duration(c.cfg().Relay.PongTimeoutSeconds) * time.Second
+	if pong <= ping {
+		pong = ping * 5 / 2
+	}
+	write := time.Duration(c.cfg().Relay.WriteTimeoutSeconds) * time.Second
+	if write <= 0 {
+		write = 10 * time.Second
+	}
+	return ping, pong, write
+}
+
+// connectAndListen mantém uma conexão com o Hub até ela cair. O retorno indica se a
+// conexão chegou a ser estabelecida (para zerar o backoff de reconexão).
+func (c *RelayClient) connectAndListen(ctx context.Context) (bool, error) {
+	u, err := url.Parse(c.cfg().Relay.HubURL)
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	q := u.Query()
-	q.Set("node_id", c.cfg.NodeID)
-	q.Set("token", c.cfg.Relay.Token)
+	q.Set("node_id", c.cfg().NodeID)
+	q.Set("token", c.cfg().Relay.Token)
 	u.RawQuery = q.Encode()
 
-	log.Printf("[RELAY] Conectando ao Hub em %s...", u.String())
-	conn, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+	log.Printf("[RELAY] Conectando ao Hub em %s...", c.cfg().Relay.HubURL)
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, u.String(), nil)
 	if err != nil {
-		return err
+		return false, err
 	}
 	c.conn = conn
-	defer c.conn.Close()
+	defer conn.Close()
+
+	c.setConnected(true)
+	defer c.setConnected(false)
 
-	// Goroutine de envio
+	// Qualquer coisa recebida do Hub (mensagem, ping ou pong) renova o prazo de leitura;
+	// sem isso, uma conexão meio-aberta ficaria pendurada no ReadMessage por horas
+	pingInterval, pongTimeout, writeTimeout := c.keepaliveIntervals()
+	extendDeadline := func() {
+		conn.SetReadDeadline(time.Now().Add(pongTimeout))
+	}
+	extendDeadline()
+	conn.SetPongHandler(func(string) error {
+		extendDeadline()
+		return nil
+	})
+	conn.SetPingHandler(func(data string) error {
+		extendDeadline()
+		err := conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(writeTimeout))
+		if err == websocket.ErrCloseSent {
+			return nil
+		}
+		return err
+	})
+
+	// Goroutine de envio, presa a esta conexão: termina antes de connectAndListen retornar
+	done := make(chan struct{})
+	var writer sync.WaitGroup
+	writer.Add(1)
 	go func() {
+		defer writer.Done()
+		defer conn.Close() // Falha na escrita também encerra a leitura
+
+		ticker := time.NewTicker(pingInterval)
+		defer ticker.Stop()
+
 		for {
 			select {
 			case msg, ok := <-c.send:
@@ -79,22 +291,35 @@ func (c *RelayClient) connectAndListen(ctx context.Context) error {
 					return
 				}
 				data, _ := json.Marshal(msg)
-				if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+				if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
 					log.Printf("[RELAY] Erro ao enviar mensagem: %v", err)
 					return
 				}
+			case <-ticker.C:
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeTimeout)); err != nil {
+					log.Printf("[RELAY] Erro ao enviar ping: %v", err)
+					return
+				}
+			case <-done:
+				return
 			case <-ctx.Done():
 				return
 			}
 		}
 	}()
+	defer func() {
+		close(done)
+		writer.Wait()
+	}()
 
 	// Loop de recebimento
 	for {
-		_, message, err := c.conn.ReadMessage()
+		_, message, err := conn.ReadMessage()
 		if err != nil {
-			return err
+			return true, err
 		}
+		extendDeadline()
 
 		var relayMsg RelayMessage
 		if err := json.Unmarshal(message, &relayMsg); err != nil {
@@ -102,29 +327,216 @@ func (c *RelayClient) connectAndListen(ctx context.Context) error {
 			continue
 		}
 
-		if relayMsg.Type == "sync" {
+		switch relayMsg.Type {
+		case "sync":
 			var payload models.SyncPayload
-			if err := json.Unmarshal(relayMsg.Payload, &payload); err != nil {
+			decoder := json.NewDecoder(bytes.NewReader(relayMsg.Payload))
+			decoder.UseNumber()
+			if err := decoder.Decode(&payload); err != nil {
 				log.Printf("[RELAY] Erro ao decodificar sync payload: %v", err)
 				continue
 			}
 
-			// Processa o dado como se tivesse vindo do Webhook HTTP
+			// Processa o dado como se tivesse vindo do Webhook HTTP e confirma ao emissor
+			source := relayMsg.SourceNode
 			go func() {
+				ack := RelayAck{EventID: payload.EventID, Status: AckOK}
 				if err := c.handler.ProcessPayload(payload); err != nil {
 					log.Printf("[RELAY] Erro ao processar sync do Relay: %v", err)
+					ack.Status = AckError
+					ack.Error = err.Error()
 				}
+				c.sendAck(source, ack)
 			}()
+
+		case "sync_batch":
+			// Eventos de uma mesma transação de origem: aplicados juntos, confirmados um a um
+			var payloads []models.SyncPayload
+			decoder := json.NewDecoder(bytes.NewReader(relayMsg.Payload))
+			decoder.UseNumber()
+			if err := decoder.Decode(&payloads); err != nil {
+				log.Printf("[RELAY] Erro ao decodificar lote do Relay: %v", err)
+				continue
+			}
+
+			source := relayMsg.SourceNode
+			go func() {
+				var results []BatchResult
+				var err error
+				if len(payloads) > MaxBatchEvents {
+					err = fmt.Errorf("lote com mais de %d eventos", MaxBatchEvents)
+				} else {
+					results, err = c.handler.ProcessBatch(payloads)
+				}
+				if err != nil {
+					log.Printf("[RELAY] Erro ao processar lote do Relay: %v", err)
+				}
+				for i, p := range payloads {
+					ack := RelayAck{EventID: p.EventID, Status: AckOK}
+					switch {
+					case err != nil:
+						ack.Status, ack.Error = AckError, err.Error()
+					case results[i].Status == BatchError:
+						ack.Status, ack.Error = AckError, results[i].Error
+					case results[i].Status == BatchSkipped:
+						ack.Status, ack.Error = AckError, "não processado: falha anterior no lote"
+					}
+					c.sendAck(source, ack)
+				}
+			}()
+
+		case "ack":
+			var ack RelayAck
+			if err := json.Unmarshal(relayMsg.Payload, &ack); err != nil {
+				log.Printf("[RELAY] Erro ao decodificar ack: %v", err)
+				continue
+			}
+			if c.onAck != nil {
+				c.onAck(relayMsg.SourceNode, ack)
+			}
+
+		case "command":
+			var cmd RelayCommand
+			if err := json.Unmarshal(relayMsg.Payload, &cmd); err != nil {
+				log.Printf("[RELAY] Erro ao decodificar comando: %v", err)
+				continue
+			}
+			go c.handleCommand(ctx, relayMsg.SourceNode, relayMsg.ID, cmd)
+
+		case "command_result":
+			var result RelayCommandResult
+			if err := json.Unmarshal(relayMsg.Payload, &result); err != nil {
+				log.Printf("[RELAY] Erro ao decodificar resposta de comando: %v", err)
+				continue
+			}
+			c.pendingMu.Lock()
+			ch, ok := c.pending[relayMsg.ID]
+			c.pendingMu.Unlock()
+			if ok {
+				// Resposta repetida (ex: recusa do Hub seguida da resposta real) ou
+				// tardia não pode travar a leitura: só a primeira é entregue
+				select {
+				case ch <- result:
+				default:
+				}
+			}
+
+		case "presence":
+			var presence RelayPresence
+			if err := json.Unmarshal(relayMsg.Payload, &presence); err != nil {
+				log.Printf("[RELAY] Erro ao decodificar presença: %v", err)
+				continue
+			}
+			c.applyPresence(presence)
 		}
 	}
 }
 
+func (c *RelayClient) sendAck(targetNode string, ack RelayAck) {
+	data, _ := json.Marshal(ack)
+	c.send <- RelayMessage{
+		ID:         ack.EventID,
+		TargetNode: targetNode,
+		SourceNode: c.cfg().NodeID,
+		Payload:    data,
+		Type:       "ack",
+	}
+}
+
 func (c *RelayClient) SendSync(targetNode string, payload models.SyncPayload) {
 	data, _ := json.Marshal(payload)
 	c.send <- RelayMessage{
+		ID:         payload.EventID,
 		TargetNode: targetNode,
-		SourceNode: c.cfg.NodeID,
+		SourceNode: c.cfg().NodeID,
 		Payload:    data,
 		Type:       "sync",
 	}
 }
+
+// SendSyncBatch envia numa só mensagem os eventos de uma transação de origem; o
+// destino confirma cada evento com um "ack"
+func (c *RelayClient) SendSyncBatch(targetNode string, payloads []models.SyncPayload) {
+	data, _ := json.Marshal(payloads)
+	c.send <- RelayMessage{
+		ID:         uuid.New().String(),
+		TargetNode: targetNode,
+		SourceNode: c.cfg().NodeID,
+		Payload:    data,
+		Type:       "sync_batch",
+	}
+}
+
+// Call envia um comando a outro nó e aguarda a resposta, até relay.command_timeout_seconds
+func (c *RelayClient) Call(ctx context.Context, targetNode, name string, args json.RawMessage) (json.RawMessage, error) {
+	if !c.IsOnline(targetNode) {
+		return nil, fmt.Errorf("nó %s offline no Relay", targetNode)
+	}
+
+	timeout := c.commandTimeout()
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	id := uuid.New().String()
+	ch := make(chan RelayCommandResult, 1)
+	c.pendingMu.Lock()
+	c.pending[id] = ch
+	c.pendingMu.Unlock()
+	defer func() {
+		c.pendingMu.Lock()
+		delete(c.pending, id)
+		c.pendingMu.Unlock()
+	}()
+
+	payload, _ := json.Marshal(RelayCommand{Name: name, Args: args, TimeoutSeconds: int(timeout.Seconds())})
+	msg := RelayMessage{ID: id, TargetNode: targetNode, SourceNode: c.cfg().NodeID, Payload: payload, Type: "command"}
+
+	select {
+	case c.send <- msg:
+	case <-ctx.Done():
+		return nil, fmt.Errorf("não foi possível enviar %s para %s: %w", name, targetNode, ctx.Err())
+	}
+
+	select {
+	case result := <-ch:
+		if !result.OK {
+			return nil, errors.New(result.Error)
+		}
+		return result.Result, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("sem resposta de %s para %s em %s", targetNode, name, timeout)
+	}
+}
+
+// handleCommand executa o comando recebido e devolve o "command_result" ao emissor
+func (c *RelayClient) handleCommand(ctx context.Context, sourceNode, requestID string, cmd RelayCommand) {
+	timeout := c.commandTimeout()
+	if cmd.TimeoutSeconds > 0 {
+		timeout = time.Duration(cmd.TimeoutSeconds) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	log.Printf("[RELAY] Comando %s recebido de %s", cmd.Name, sourceNode)
+	result := RelayCommandResult{OK: true}
+	if c.onCommand == nil {
+		result = RelayCommandResult{Error: "este nó não aceita comandos"}
+	} else if value, err := c.onCommand(ctx, sourceNode, cmd.Name, cmd.Args); err != nil {
+		result = RelayCommandResult{Error: err.Error()}
+	} else if data, err := json.Marshal(value); err != nil {
+		result = RelayCommandResult{Error: fmt.Sprintf("erro ao serializar resultado: %v", err)}
+	} else {
+		result.Result = data
+	}
+
+	payload, _ := json.Marshal(result)
+	select {
+	case c.send <- RelayMessage{ID: requestID, TargetNode: sourceNode, SourceNode: c.cfg().NodeID, Payload: payload, Type: "command_result"}:
+	case <-ctx.Done():
+		log.Printf("[RELAY] Resposta de %s para %s descartada: %v", cmd.Name, sourceNode, ctx.Err())
+	}
+}
+
+func (c *RelayClient) commandTimeout() time.Duration {
+	return commandTimeout(c.cfg())
+}
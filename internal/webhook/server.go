@@ -1,36 +1,160 @@
 package webhook
 
 import (
+	"crypto/subtle"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"sort"
 	"strings"
 
+	"github.com/atsinformatica/firebird-sync-agent/internal/config"
 	"github.com/atsinformatica/firebird-sync-agent/internal/db"
 	"github.com/atsinformatica/firebird-sync-agent/internal/models"
 )
 
 type Server struct {
+	conf   *config.Store
 	dbConn *sql.DB
 	queue  *db.QueueManager
-	token  string
+	schema *db.SchemaCache
+
+	relay    *RelayClient   // Para encaminhar comandos a outros nós
+	commands CommandHandler // Comandos executados neste nó
 }
 
-func NewServer(dbConn *sql.DB, queue *db.QueueManager, token string) *Server {
+func NewServer(conf *config.Store, dbConn *sql.DB, queue *db.QueueManager) *Server {
 	return &Server{
+		conf:   conf,
 		dbConn: dbConn,
 		queue:  queue,
-		token:  token,
+		schema: db.NewSchemaCache(dbConn),
 	}
 }
 
+// cfg retorna a configuração atual (pode mudar com reload_config e rotate_token)
+func (s *Server) cfg() *config.Config {
+	return s.conf.Get()
+}
+
+// SetRelay habilita o encaminhamento de comandos para outros nós via Relay
+func (s *Server) SetRelay(relay *RelayClient) {
+	s.relay = relay
+}
+
+// SetCommandHandler registra quem executa os comandos destinados a este nó
+func (s *Server) SetCommandHandler(handler CommandHandler) {
+	s.commands = handler
+}
+
 func (s *Server) Listen(addr string) error {
 	http.HandleFunc("/sync", s.handleSync)
+	http.HandleFunc("/sync/batch", s.handleSyncBatch)
+	http.HandleFunc("/command", s.handleCommand)
 	return http.ListenAndServe(addr, nil)
 }
 
+// authorized confere o X-Sync-Token contra a config atual (o token pode ser trocado
+// pelo comando rotate_token sem reiniciar o agente)
+func (s *Server) authorized(r *http.Request) bool {
+	return tokenMatch(r.Header.Get("X-Sync-Token"), s.cfg().Webhook.Token)
+}
+
+// adminAuthorized confere o X-Admin-Token do /command contra commands.token, que não
+// é o mesmo segredo compartilhado com os demais nós para o /sync
+func (s *Server) adminAuthorized(r *http.Request) bool {
+	token := s.cfg().Commands.Token
+	return token != "" && tokenMatch(r.Header.Get("X-Admin-Token"), token)
+}
+
+// tokenMatch compara tokens em tempo constante
+func tokenMatch(got, want string) bool {
+	return subtle.ConstantTimeCompare([]byte(got), []byte(want)) == 1
+}
+
+// isLoopback indica se a requisição veio da própria máquina
+func isLoopback(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// CommandRequest é o corpo do POST /command
+type CommandRequest struct {
+	Target string          `json:"target"` // Vazio ou o próprio NodeID = executa localmente
+	Name   string          `json:"name"`
+	Args   json.RawMessage `json:"args,omitempty"`
+}
+
+// handleCommand executa um comando neste nó ou o encaminha via Relay ao nó de destino.
+// Só quem chama da própria máquina age como o nó local (comandos administrativos e
+// encaminhamento); outros endereços, mesmo com o token, executam apenas os comandos
+// liberados a origens fora de commands.admin_sources.
+func (s *Server) handleCommand(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.adminAuthorized(r) {
+		http.Error(w, "Não autorizado", http.StatusUnauthorized)
+		return
+	}
+	nodeID := s.cfg().NodeID
+	local := isLoopback(r)
+	source := nodeID
+	if !local {
+		source = "http:" + r.RemoteAddr
+	}
+
+	var req CommandRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" {
+		http.Error(w, "Requisição inválida", http.StatusBadRequest)
+		return
+	}
+
+	var result json.RawMessage
+	var err error
+	status := http.StatusInternalServerError
+	if req.Target == "" || req.Target == nodeID {
+		if s.commands == nil {
+			http.Error(w, "Comandos não habilitados", http.StatusNotImplemented)
+			return
+		}
+		var value interface{}
+		if value, err = s.commands(r.Context(), source, req.Name, req.Args); err == nil {
+			result, err = json.Marshal(value)
+		}
+	} else {
+		if !local {
+			// O encaminhamento sai com a identidade deste nó no Relay
+			http.Error(w, "Encaminhamento permitido apenas localmente", http.StatusForbidden)
+			return
+		}
+		if s.relay == nil {
+			http.Error(w, "Relay desabilitado", http.StatusNotImplemented)
+			return
+		}
+		status = http.StatusBadGateway
+		result, err = s.relay.Call(r.Context(), req.Target, req.Name, req.Args)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		log.Printf("[SERVER] Comando %s em %s falhou: %v", req.Name, req.Target, err)
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(RelayCommandResult{Error: err.Error()})
+		return
+	}
+	json.NewEncoder(w).Encode(RelayCommandResult{OK: true, Result: result})
+}
+
 func (s *Server) handleSync(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
@@ -38,13 +162,15 @@ func (s *Server) handleSync(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 1. Valida Token
-	if r.Header.Get("X-Sync-Token") != s.token {
+	if !s.authorized(r) {
 		http.Error(w, "Não autorizado", http.StatusUnauthorized)
 		return
 	}
 
 	var payload models.SyncPayload
-	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+	decoder := json.NewDecoder(r.Body)
+	decoder.UseNumber() // Preserva a precisão de NUMERIC/BIGINT até a conversão por coluna
+	if err := decoder.Decode(&payload); err != nil {
 		http.Error(w, "Payload inválido", http.StatusBadRequest)
 		return
 	}
@@ -81,50 +207,133 @@ func (s *Server) ProcessPayload(payload models.SyncPayload) error {
 	}
 	defer tx.Rollback() // Se falhar, desfaz
 
-	// 4. Aplica dado no Firebird
-	if err := s.applyToDBTx(tx, payload); err != nil {
-		return fmt.Errorf("erro ao aplicar no banco remoto: %w", err)
+	if err := s.applyPayloadTx(tx, payload); err != nil {
+		var rejected *applyError
+		if !errors.As(err, &rejected) || s.retryable(rejected.err, payload.Attempt) {
+			return err
+		}
+		// Erro do próprio evento: reenviar não resolve. Retém para revisão e libera o emissor.
+		tx.Rollback()
+		return s.parkFailedPayload(payload, rejected.err)
 	}
 
-	// 5. Registra na fila local como 'A' (Aplicado) para histórico
+	// 6. COMMIT REAL
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("erro ao comitar alteração: %w", err)
+	}
+
+	return nil
+}
+
+// applyError indica que o banco recusou o próprio evento ao aplicá-lo
+type applyError struct {
+	err error
+}
+
+func (e *applyError) Error() string {
+	return fmt.Sprintf("erro ao aplicar no banco remoto: %v", e.err)
+}
+
+func (e *applyError) Unwrap() error {
+	return e.err
+}
+
+// applyPayloadTx verifica conflito, aplica o evento e grava o histórico na transação.
+// Falhas na aplicação voltam como *applyError.
+func (s *Server) applyPayloadTx(tx *sql.Tx, payload models.SyncPayload) error {
+	// 4. Verifica conflito com alterações locais feitas depois da versão conhecida pelo emissor
+	decision, err := s.checkConflict(tx, payload)
+	if err != nil {
+		return fmt.Errorf("erro ao verificar conflito: %w", err)
+	}
+	if decision.policy != "" {
+		logConflict(payload, decision)
+	}
+
+	var erroMsg interface{} // NULL quando aplicado sem conflito
+	status := "A"
+	switch decision.action {
+	case conflictApply:
+		apply := payload
+		if decision.data != nil {
+			apply.PayloadJSON = decision.data
+			erroMsg = "Conflito: campos mesclados com a versão local"
+		}
+		if err := s.applyToDBTx(tx, apply); err != nil {
+			// Metadados podem ter mudado (ALTER TABLE): recarrega na próxima tentativa
+			s.schema.Invalidate(payload.Table)
+			return &applyError{err: err}
+		}
+		version := db.RowVersion{EventID: payload.EventID, Origem: payload.Origem, DTEvento: payload.Timestamp}
+		if err := db.SetRowVersionTx(tx, payload.Table, payload.PKJSON, version); err != nil {
+			return fmt.Errorf("erro ao registrar versão da linha: %w", err)
+		}
+	case conflictKeep:
+		erroMsg = "Conflito: mantida a versão local (" + decision.reason + ")"
+	case conflictPark:
+		if err := s.parkConflict(tx, payload, decision.reason, ""); err != nil {
+			return fmt.Errorf("erro ao reter conflito: %w", err)
+		}
+		status, erroMsg = "C", "Conflito retido para revisão ("+decision.reason+")"
+	}
+
+	// 5. Registra na fila local para histórico: 'A' (Aplicado) ou 'C' (Conflito retido)
+	recordHistoryTx(tx, payload, status, erroMsg)
+	return nil
+}
+
+// recordHistoryTx grava o evento recebido em FILA_INTEGRACAO, que também serve de
+// controle de duplicidade (IsDuplicate)
+func recordHistoryTx(tx *sql.Tx, payload models.SyncPayload, status string, erroMsg interface{}) {
 	pkJSON, _ := json.Marshal(payload.PKJSON)
 	payloadJSON, _ := json.Marshal(payload.PayloadJSON)
 
 	queryQueue := `
-		INSERT INTO FILA_INTEGRACAO (EVENT_ID, TABELA, OPERACAO, PK_JSON, PAYLOAD_JSON, ORIGEM, STATUS, TENTATIVAS, DT_EVENTO)
-		VALUES (?, ?, ?, ?, ?, ?, 'A', 0, CURRENT_TIMESTAMP)
+		INSERT INTO FILA_INTEGRACAO (EVENT_ID, TABELA, OPERACAO, PK_JSON, PAYLOAD_JSON, ORIGEM, STATUS, TENTATIVAS, DT_EVENTO, ERRO_MSG)
+		VALUES (?, ?, ?, ?, ?, ?, ?, 0, CURRENT_TIMESTAMP, ?)
 	`
-	if _, err := tx.Exec(queryQueue, payload.EventID, payload.Table, payload.Operation, string(pkJSON), string(payloadJSON), payload.Origem); err != nil {
+	if _, err := tx.Exec(queryQueue, payload.EventID, payload.Table, payload.Operation, string(pkJSON), string(payloadJSON), payload.Origem, status, erroMsg); err != nil {
 		log.Printf("[SERVER] Erro ao gravar histórico: %v", err)
 	}
+}
 
-	// 6. COMMIT REAL
-	if err := tx.Commit(); err != nil {
-		return fmt.Errorf("erro ao comitar alteração: %w", err)
+func (s *Server) applyToDBTx(tx *sql.Tx, p models.SyncPayload) error {
+	table, colMap, err := s.resolveTable(p.Table)
+	if err != nil {
+		return err
 	}
 
-	return nil
-}
+	// PK é sempre estrita: coluna desconhecida no MATCHING/WHERE nunca é descartada
+	pkVals, err := s.prepareValues(table, colMap, p.PKJSON, true)
+	if err != nil {
+		return err
+	}
+	if len(pkVals) == 0 {
+		return fmt.Errorf("evento sem PK para %s", table)
+	}
 
-func (s *Server) applyToDBTx(tx *sql.Tx, p models.SyncPayload) error {
 	if p.Operation == "D" {
 		params := []interface{}{}
 		clauses := []string{}
-		for k, v := range p.PKJSON {
-			clauses = append(clauses, fmt.Sprintf("%s = ?", k))
+		for k, v := range pkVals {
+			clauses = append(clauses, fmt.Sprintf("%s = ?", db.QuoteIdent(k)))
 			params = append(params, v)
 		}
-		query := fmt.Sprintf("DELETE FROM %s WHERE %s", p.Table, strings.Join(clauses, " AND "))
-		_, err := tx.Exec(query, params...)
+		query := fmt.Sprintf("DELETE FROM %s WHERE %s", db.QuoteIdent(table), strings.Join(clauses, " AND "))
+		_, err = tx.Exec(query, params...)
 		return err
 	}
 
+	if len(p.PayloadJSON) == 0 {
+		return fmt.Errorf("payload vazio para update/insert")
+	}
+
 	// Merge: PK e Data precisam estar juntos no INSERT/VALUES
-	allData := make(map[string]interface{})
-	for k, v := range p.PKJSON {
-		allData[k] = v
+	allData, err := s.prepareValues(table, colMap, p.PayloadJSON, false)
+	if err != nil {
+		return err
 	}
-	for k, v := range p.PayloadJSON {
+	for k, v := range pkVals {
 		allData[k] = v
 	}
 
@@ -133,39 +342,83 @@ func (s *Server) applyToDBTx(tx *sql.Tx, p models.SyncPayload) error {
 	vals := []interface{}{}
 	pkCols := []string{}
 
-	if len(allData) == 0 {
-		return fmt.Errorf("payload vazio para update/insert")
-	}
-
 	for k, v := range allData {
-		cols = append(cols, k)
+		cols = append(cols, db.QuoteIdent(k))
 		placeholders = append(placeholders, "?")
-
-		// Especial para Firebird 2.5: Tratar string vazia como NULL para campos numéricos/data
-		if s, ok := v.(string); ok && s == "" {
-			vals = append(vals, nil)
-		} else {
-			vals = append(vals, v)
-		}
+		vals = append(vals, v)
 	}
 
-	for k := range p.PKJSON {
-		pkCols = append(pkCols, k)
+	for k := range pkVals {
+		pkCols = append(pkCols, db.QuoteIdent(k))
 	}
 
 	query := fmt.Sprintf(
 		"UPDATE OR INSERT INTO %s (%s) VALUES (%s) MATCHING (%s)",
-		p.Table,
+		db.QuoteIdent(table),
 		strings.Join(cols, ", "),
 		strings.Join(placeholders, ", "),
 		strings.Join(pkCols, ", "),
 	)
 
-	log.Printf("[SERVER] Aplicando SQL: %s | Valores: %v", query, vals)
-	_, err := tx.Exec(query, vals...)
+	log.Printf("[SERVER] Aplicando SQL: %s", query)
+	_, err = tx.Exec(query, vals...)
 	return err
 }
 
+// resolveTable só aceita tabelas ativas em TABELAS_INTEGRADAS e existentes no catálogo,
+// devolvendo o nome canônico e as colunas indexadas pelo nome
+func (s *Server) resolveTable(name string) (string, map[string]db.ColumnInfo, error) {
+	table := strings.ToUpper(strings.TrimSpace(name))
+	if table == "" {
+		return "", nil, fmt.Errorf("tabela não informada")
+	}
+	if !db.IsTableIntegrated(s.dbConn, table) {
+		return "", nil, fmt.Errorf("tabela %q não está liberada em TABELAS_INTEGRADAS", name)
+	}
+
+	colInfos, err := s.schema.Columns(table)
+	if err != nil {
+		return "", nil, err
+	}
+	colMap := make(map[string]db.ColumnInfo, len(colInfos))
+	for _, c := range colInfos {
+		colMap[c.Name] = c
+	}
+	return table, colMap, nil
+}
+
+// prepareValues valida cada coluna do payload contra o catálogo e converte o valor
+// para o tipo da coluna de destino, reunindo os erros numa única mensagem.
+// Colunas desconhecidas são rejeitadas ou descartadas conforme webhook.unknown_columns.
+func (s *Server) prepareValues(table string, colMap map[string]db.ColumnInfo, data map[string]interface{}, strict bool) (map[string]interface{}, error) {
+	result := make(map[string]interface{}, len(data))
+	var problems []string
+
+	for k, v := range data {
+		info, ok := colMap[strings.ToUpper(strings.TrimSpace(k))]
+		if !ok || info.Computed {
+			if strict || s.cfg().Webhook.UnknownColumns != config.UnknownColumnsDrop {
+				problems = append(problems, fmt.Sprintf("%q: coluna desconhecida", k))
+			} else {
+				log.Printf("[SERVER] Coluna desconhecida %q descartada em %s", k, table)
+			}
+			continue
+		}
+		cv, err := info.Coerce(v)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", info.Name, err))
+			continue
+		}
+		result[info.Name] = cv
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return nil, fmt.Errorf("erro de validação em %s (%s)", table, strings.Join(problems, "; "))
+	}
+	return result, nil
+}
+
 func (s *Server) registerNode(p models.SyncPayload) {
 	if p.Origem == "" || p.Origem == "TRIGGER" {
 		return
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"database/sql"
 	"embed"
 	"flag"
 	"fmt"
@@ -9,10 +10,13 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
+	"github.com/atsinformatica/firebird-sync-agent/internal/command"
 	"github.com/atsinformatica/firebird-sync-agent/internal/config"
 	"github.com/atsinformatica/firebird-sync-agent/internal/db"
 	"github.com/atsinformatica/firebird-sync-agent/internal/sync"
+	"github.com/atsinformatica/firebird-sync-agent/internal/trace"
 	"github.com/atsinformatica/firebird-sync-agent/internal/ui"
 	"github.com/atsinformatica/firebird-sync-agent/internal/webhook"
 	"github.com/kardianos/service"
@@ -76,6 +80,9 @@ func main() {
 		fmt.Println("  start      Inicia o serviço")
 		fmt.Println("  stop       Para o serviço")
 		fmt.Println("  ui         Força modo UI")
+		fmt.Println("  command    Executa um comando no nó (ou em outro via Relay): command [-config path] <NODE_ID> <comando> [args JSON]")
+		fmt.Println("  conflicts  Revisa eventos retidos: conflicts [-config path] [list|show|accept|keep|edit] ...")
+		fmt.Println("  resync     Carga inicial de tabela para os destinos: resync [-config path] <TABELA|all> [-node NODE_ID] [-restart] [-page N]")
 		fmt.Println("\nOpções:")
 		flag.PrintDefaults()
 	}
@@ -140,6 +147,12 @@ func main() {
 			srv := ui.NewUIServer(nil)
 			srv.Start(":8090")
 			return
+		case "command":
+			os.Exit(runCommandCLI(*configFlag, flag.Args()))
+		case "resync":
+			os.Exit(runResyncCLI(*configFlag, flag.Args()))
+		case "conflicts":
+			os.Exit(runConflictsCLI(*configFlag, flag.Args()))
 		}
 	}
 
@@ -184,10 +197,19 @@ func StartAgent(configPath string) {
 		return
 	}
 
-	// Auto-instalação: Garante FILA e triggers básicos (CLIENTE, PRODUTO)
-	log.Println("I: Verificando/Instalando Tabelas e Triggers automáticos...")
-	if err := ui.InstallTriggers(dbConn, []string{"CLIENTE", "PRODUTO"}); err != nil {
-		log.Printf("[WARN] Erro na autoinstalação de triggers: %v\n", err)
+	captureMode := cfg.CaptureMode()
+	log.Printf("I: Modo de captura: %s\n", captureMode)
+
+	if captureMode == config.CaptureTrace {
+		// Modo Trace: nenhuma trigger é criada no schema do ERP, apenas as tabelas de controle
+		log.Println("I: Verificando/Instalando Tabelas de controle...")
+		ui.EnsureQueueSchema(dbConn)
+	} else {
+		// Auto-instalação: Garante FILA e triggers básicos (CLIENTE, PRODUTO)
+		log.Println("I: Verificando/Instalando Tabelas e Triggers automáticos...")
+		if err := ui.InstallTriggers(dbConn, []string{"CLIENTE", "PRODUTO"}); err != nil {
+			log.Printf("[WARN] Erro na autoinstalação de triggers: %v\n", err)
+		}
 	}
 
 	queue := db.NewQueueManager(dbConn, cfg.NodeID)
@@ -206,17 +228,38 @@ func StartAgent(configPath string) {
 		}
 	}
 
-	webhookServer := webhook.NewServer(dbConn, queue, cfg.Webhook.Token)
-	webhookClient := webhook.NewClient(cfg)
+	// Config compartilhada entre as goroutines; reload_config e rotate_token publicam
+	// uma nova versão em vez de alterar esta
+	conf := config.NewStore(cfg)
+	if cfg.Commands.Token == "" {
+		log.Println("[WARN] commands.token não configurado: endpoint /command desabilitado")
+	}
+
+	webhookServer := webhook.NewServer(conf, dbConn, queue)
+	webhookClient := webhook.NewClient(conf)
+
+	// Comandos de diagnóstico e administrativos respondidos via Relay e /command
+	commands := command.NewRegistry()
+	command.RegisterDiagnostics(commands, conf, dbConn, queue)
+	admin := command.RegisterAdmin(commands, conf, configPath, dbConn, queue)
+	webhookServer.SetCommandHandler(commands.Execute)
 
 	// Inicializa Relay se habilitado
 	var relayClient *webhook.RelayClient
 	if cfg.Relay.Enabled {
 		log.Printf("I: Inicializando Cliente RELAY para %s\n", cfg.Relay.HubURL)
-		relayClient = webhook.NewRelayClient(cfg, webhookServer)
+		relayClient = webhook.NewRelayClient(conf, webhookServer)
+		relayClient.SetCommandHandler(commands.Execute)
+		webhookServer.SetRelay(relayClient)
 	}
 
-	poller := sync.NewPoller(cfg, queue, webhookClient, relayClient)
+	// Reconciliação por checksum fala com o outro nó pelo Relay ou direto pelo /command
+	command.RegisterReconcile(admin, webhook.NewCommandCaller(conf, relayClient, queue))
+
+	// Revisão dos eventos retidos em SYNC_CONFLITOS (conflito ou erro de aplicação)
+	command.RegisterConflicts(admin, webhookServer)
+
+	poller := sync.NewPoller(conf, queue, webhookClient, relayClient)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -226,6 +269,11 @@ func StartAgent(configPath string) {
 		go relayClient.Start(ctx)
 	}
 
+	// Inicia captura via Trace (CDC sem triggers)
+	if captureMode == config.CaptureTrace {
+		go startTraceCapture(ctx, cfg, dbConn, queue)
+	}
+
 	// Se houver config de UI port e NÃO for serviço, podemos rodar UI junto?
 	// Por enquanto, modo agente é só agente.
 	// Mas vamos respeitar a porta de escuta do webhook
@@ -235,5 +283,34 @@ func StartAgent(configPath string) {
 	log.Printf("[POLLER] Monitorando banco...")
 	go poller.Start(ctx)
 
+	// Retenção: apaga da fila o que já foi concluído há mais de retention.*_days
+	go sync.NewPurger(conf, queue).Start(ctx)
+
 	select {}
 }
+
+// startTraceCapture monta o pipeline Listener -> Parser -> DataResolver -> FILA_INTEGRACAO
+// e mantém o listener de pé, reiniciando-o se o fbtracemgr parar inesperadamente.
+func startTraceCapture(ctx context.Context, cfg *config.Config, dbConn *sql.DB, queue *db.QueueManager) {
+	resolver := db.NewDataResolver(dbConn, queue)
+	parser := trace.NewParser(cfg.Firebird.DSN, cfg.Firebird.AppName, func(transID string, events []*trace.TraceEvent) {
+		log.Printf("[TRACE] Transação %s commitada com %d evento(s)", transID, len(events))
+		if err := resolver.Resolve(events); err != nil {
+			log.Printf("[TRACE] Erro ao resolver transação %s: %v", transID, err)
+		}
+	})
+	listener := trace.NewListener(cfg, parser)
+
+	for {
+		log.Println("[TRACE] Iniciando listener de Trace...")
+		if err := listener.Start(ctx); err != nil {
+			log.Printf("[TRACE] Listener parou: %v. Reiniciando em 10s...", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(10 * time.Second):
+		}
+	}
+}